@@ -0,0 +1,164 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+import (
+	"github.com/streadway/amqp"
+)
+
+const (
+	// RetryCountHeader is the message header holding how many times a message has been requeued after its
+	// inbound message handler failed.
+	RetryCountHeader = "x-retry-count"
+	// ErrorHeader is the message header holding the error that caused a message to be dead-lettered.
+	ErrorHeader = "x-error"
+	// OriginalQueueHeader is the message header holding the queue a dead-lettered message was consumed from.
+	OriginalQueueHeader = "x-original-queue"
+
+	defaultMaxRetries = 3
+)
+
+// ack acknowledges a successfully handled delivery.
+func (i *Inbound) ack(d amqp.Delivery) {
+	if !i.manualAck {
+		return
+	}
+
+	if err := d.Ack(false); err != nil {
+		i.logger.Errorf("failed to ack msg: %v", err)
+	}
+}
+
+// retry requeues a delivery whose inbound message handler failed, or dead-letters it once the retry limit is
+// reached. RabbitMQ does not count redeliveries of nacked messages on classic queues, so the delivery is
+// republished to the queue with an incremented retry count header and the original is acknowledged. If the
+// republish fails, the original is nacked with requeue instead so it is not lost.
+func (i *Inbound) retry(d amqp.Delivery, cause error) {
+	if !i.manualAck {
+		return
+	}
+
+	count := retryCount(d.Headers)
+	if count >= i.maxRetries {
+		i.deadLetter(d, cause)
+
+		return
+	}
+
+	headers := copyHeaders(d.Headers)
+	headers[RetryCountHeader] = int32(count + 1)
+
+	if err := i.republish(d, "", i.queue(), headers); err != nil {
+		i.logger.Errorf("failed to requeue msg: %v", err)
+		i.nack(d, true)
+
+		return
+	}
+
+	i.ack(d)
+}
+
+// deadLetter routes a permanently failing delivery to the dead-letter exchange with the error recorded in its
+// headers. Without a dead-letter exchange the delivery is rejected, which leaves it to the queue's own
+// dead-letter policy, if any.
+func (i *Inbound) deadLetter(d amqp.Delivery, cause error) {
+	if !i.manualAck {
+		return
+	}
+
+	if i.deadLetterExchange == "" {
+		i.nack(d, false)
+
+		return
+	}
+
+	routingKey := i.deadLetterRoutingKey
+	if routingKey == "" {
+		routingKey = i.queue()
+	}
+
+	headers := copyHeaders(d.Headers)
+	headers[ErrorHeader] = cause.Error()
+	headers[OriginalQueueHeader] = i.queue()
+
+	if err := i.republish(d, i.deadLetterExchange, routingKey, headers); err != nil {
+		i.logger.Errorf("failed to dead-letter msg: %v", err)
+		i.nack(d, true)
+
+		return
+	}
+
+	i.ack(d)
+}
+
+func (i *Inbound) nack(d amqp.Delivery, requeue bool) {
+	if err := d.Nack(false, requeue); err != nil {
+		i.logger.Errorf("failed to nack msg: %v", err)
+	}
+}
+
+// queue returns the name of the declared queue, which is generated by the AMQP server if none was configured.
+func (i *Inbound) queue() string {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	return i.que.Name
+}
+
+// republish publishes a copy of the delivery with the given headers.
+func (i *Inbound) republish(d amqp.Delivery, exchange, routingKey string, headers amqp.Table) error {
+	i.lock.RLock()
+	ch := i.ch
+	i.lock.RUnlock()
+
+	return ch.Publish(
+		exchange,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    d.DeliveryMode,
+			Priority:        d.Priority,
+			CorrelationId:   d.CorrelationId,
+			ReplyTo:         d.ReplyTo,
+			Expiration:      d.Expiration,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			UserId:          d.UserId,
+			AppId:           d.AppId,
+			Body:            d.Body,
+		})
+}
+
+func retryCount(headers amqp.Table) int {
+	switch v := headers[RetryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int16:
+		return int(v)
+	case int8:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	c := amqp.Table{}
+
+	for k, v := range headers {
+		c[k] = v
+	}
+
+	return c
+}
@@ -0,0 +1,139 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+const (
+	defaultHeartbeat = 10 * time.Second
+	defaultLocale    = "en_US"
+)
+
+// SASLMechanism is the SASL mechanism used to authenticate with the AMQP server.
+type SASLMechanism string
+
+const (
+	// SASLPlain authenticates with the username and password from the AMQP URL. This is the default.
+	SASLPlain SASLMechanism = "PLAIN"
+	// SASLAMQPlain authenticates with the username and password from the AMQP URL using the AMQPLAIN mechanism.
+	SASLAMQPlain SASLMechanism = "AMQPLAIN"
+	// SASLExternal authenticates with the client certificate presented during the TLS handshake.
+	// It requires an amqps:// URL and a client certificate.
+	SASLExternal SASLMechanism = "EXTERNAL"
+)
+
+// externalAuth implements the SASL EXTERNAL mechanism, where the identity is taken from the TLS client certificate.
+type externalAuth struct{}
+
+func (*externalAuth) Mechanism() string {
+	return string(SASLExternal)
+}
+
+func (*externalAuth) Response() string {
+	return ""
+}
+
+// connConfig holds the settings used to connect to an AMQP server.
+type connConfig struct {
+	certFile, keyFile string
+	caFile            string
+	tlsConfig         *tls.Config
+	saslMechanism     SASLMechanism
+}
+
+// dial opens a connection to the AMQP server at addr. TLS is used if addr uses the amqps scheme; TLS settings
+// are ignored for amqp URLs.
+func (c *connConfig) dial(addr string) (*amqp.Connection, error) {
+	tlsConfig, err := c.tls()
+	if err != nil {
+		return nil, err
+	}
+
+	uri, err := amqp.ParseURI(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid AMQP URL %s", addr)
+	}
+
+	config := amqp.Config{
+		Heartbeat:       defaultHeartbeat,
+		Locale:          defaultLocale,
+		TLSClientConfig: tlsConfig,
+	}
+
+	switch c.saslMechanism {
+	case "", SASLPlain:
+	case SASLAMQPlain:
+		config.SASL = []amqp.Authentication{&amqp.AMQPlainAuth{Username: uri.Username, Password: uri.Password}}
+	case SASLExternal:
+		if tlsConfig == nil || (len(tlsConfig.Certificates) == 0 && tlsConfig.GetClientCertificate == nil) {
+			return nil, errors.New("SASL EXTERNAL requires a TLS client certificate")
+		}
+
+		config.SASL = []amqp.Authentication{&externalAuth{}}
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %s", c.saslMechanism)
+	}
+
+	conn, err := amqp.DialConfig(addr, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to AMQP server at %s", addr)
+	}
+
+	return conn, nil
+}
+
+// tls builds the TLS configuration, or returns nil if no TLS setting is configured.
+func (c *connConfig) tls() (*tls.Config, error) {
+	if c.tlsConfig == nil && c.certFile == "" && c.keyFile == "" && c.caFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS13}
+
+	if c.tlsConfig != nil {
+		// The AMQP client sets the server name on the config it is given, so never hand it the caller's.
+		config = c.tlsConfig.Clone()
+	}
+
+	if c.certFile != "" || c.keyFile != "" {
+		if c.certFile == "" || c.keyFile == "" {
+			return nil, errors.New("invalid cert: both a certificate file and a key file are required")
+		}
+
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid cert")
+		}
+
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	if c.caFile != "" {
+		pem, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read CA certificates")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in %s", c.caFile)
+		}
+
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
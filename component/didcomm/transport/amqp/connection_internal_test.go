@@ -0,0 +1,156 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnConfig(t *testing.T) {
+	certFile, keyFile := generateCertificate(t)
+
+	t.Run("test connection - no TLS", func(t *testing.T) {
+		c := &connConfig{}
+
+		config, err := c.tls()
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("test connection - client certificate and CA bundle", func(t *testing.T) {
+		c := &connConfig{certFile: certFile, keyFile: keyFile, caFile: certFile}
+
+		config, err := c.tls()
+		require.NoError(t, err)
+		require.Len(t, config.Certificates, 1)
+		require.NotNil(t, config.RootCAs)
+		require.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	})
+
+	t.Run("test connection - custom TLS config is not modified", func(t *testing.T) {
+		custom := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: "broker"} //nolint:gosec // test config
+		c := &connConfig{tlsConfig: custom, certFile: certFile, keyFile: keyFile}
+
+		config, err := c.tls()
+		require.NoError(t, err)
+		require.Equal(t, "broker", config.ServerName)
+		require.Len(t, config.Certificates, 1)
+		require.Empty(t, custom.Certificates)
+	})
+
+	t.Run("test connection - certificate without key", func(t *testing.T) {
+		c := &connConfig{certFile: certFile}
+
+		_, err := c.tls()
+		require.EqualError(t, err, "invalid cert: both a certificate file and a key file are required")
+	})
+
+	t.Run("test connection - invalid CA bundle", func(t *testing.T) {
+		_, err := (&connConfig{caFile: "missing"}).tls()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to read CA certificates")
+
+		_, err = (&connConfig{caFile: keyFile}).tls()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no CA certificates found")
+	})
+
+	t.Run("test connection - TLS settings are ignored for amqp URLs", func(t *testing.T) {
+		conn, err := (&connConfig{caFile: certFile}).dial(testAMQPAddr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	})
+
+	t.Run("test connection - SASL mechanisms", func(t *testing.T) {
+		conn, err := (&connConfig{saslMechanism: SASLAMQPlain}).dial(testAMQPAddr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+
+		_, err = (&connConfig{saslMechanism: SASLExternal}).dial(testAMQPAddr)
+		require.EqualError(t, err, "SASL EXTERNAL requires a TLS client certificate")
+
+		_, err = (&connConfig{saslMechanism: "invalid"}).dial(testAMQPAddr)
+		require.EqualError(t, err, "unsupported SASL mechanism invalid")
+
+		auth := &externalAuth{}
+		require.Equal(t, "EXTERNAL", auth.Mechanism())
+		require.Empty(t, auth.Response())
+	})
+}
+
+// generateCertificate writes a self-signed certificate and its key to PEM files.
+func TestOutboundChannel(t *testing.T) {
+	outbound, err := NewOutbound()
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, outbound.Stop()) }()
+
+	ch, err := outbound.channel(testAMQPAddr)
+	require.NoError(t, err)
+
+	cached, err := outbound.channel(testAMQPAddr)
+	require.NoError(t, err)
+	require.Same(t, ch, cached)
+
+	require.NoError(t, ch.Close())
+
+	require.Eventually(t, func() bool {
+		reopened, errReopen := outbound.channel(testAMQPAddr)
+
+		return errReopen == nil && reopened != ch
+	}, time.Second, 10*time.Millisecond)
+}
+
+func generateCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "amqp")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { require.NoError(t, os.RemoveAll(dir)) })
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		0o600))
+
+	return certFile, keyFile
+}
@@ -9,8 +9,11 @@ require (
 	github.com/cenkalti/backoff v2.2.1+incompatible
 	github.com/google/uuid v1.1.2
 	github.com/hyperledger/aries-framework-go v0.1.8
+	github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/returnroute v0.0.0
 	github.com/ory/dockertest/v3 v3.6.2
 	github.com/pkg/errors v0.9.1
 	github.com/streadway/amqp v1.0.0
 	github.com/stretchr/testify v1.7.0
 )
+
+replace github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/returnroute => ../returnroute
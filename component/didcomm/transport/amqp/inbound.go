@@ -4,38 +4,83 @@ Copyright Scoir, Inc. All Rights Reserved.
 SPDX-License-Identifier: Apache-2.0
 */
 
-// Package amqp implements an inbound DIDComm transport for Aries (aries-framework-go).
+// Package amqp implements inbound and outbound DIDComm transports for Aries (aries-framework-go).
 //
 package amqp
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/cenkalti/backoff"
+	"github.com/google/uuid"
 	"github.com/hyperledger/aries-framework-go/pkg/common/log"
 	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
 	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
+
+	"github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/returnroute"
+)
+
+const (
+	defaultReconnectInitialInterval = 500 * time.Millisecond
+	defaultReconnectMaxInterval     = 30 * time.Second
+	defaultShutdownTimeout          = 10 * time.Second
 )
 
 // Inbound amqp type.
 type Inbound struct {
-	internalAddr      string
-	externalAddr      string
-	queueName         string
-	conn              *amqp.Connection
-	ch                *amqp.Channel
-	que               amqp.Queue
-	certFile, keyFile string
-	packager          transport.Packager
-	msgHandler        transport.InboundMessageHandler
-	logger            *log.Log
+	connConfig
+	internalAddr             string
+	externalAddr             string
+	queueName                string
+	queueDurable             bool
+	queueAutoDelete          bool
+	queueExclusive           bool
+	queueArgs                amqp.Table
+	exchangeName             string
+	exchangeKind             string
+	exchangeDurable          bool
+	exchangeArgs             amqp.Table
+	bindingKeys              []string
+	consumerTag              string
+	exclusiveConsumer        bool
+	conn                     *amqp.Connection
+	ch                       *amqp.Channel
+	que                      amqp.Queue
+	connClose                chan *amqp.Error
+	chClose                  chan *amqp.Error
+	packager                 transport.Packager
+	msgHandler               transport.InboundMessageHandler
+	logger                   *log.Log
+	reconnectInitialInterval time.Duration
+	reconnectMaxInterval     time.Duration
+	reconnectMaxElapsedTime  time.Duration
+	manualAck                bool
+	maxRetries               int
+	deadLetterExchange       string
+	deadLetterRoutingKey     string
+	workers                  int
+	prefetchCount            int
+	orderBySender            bool
+	state                    ConnectionState
+	lock                     sync.RWMutex
+	done                     chan struct{}
+	served                   chan struct{}
+	workerGroup              sync.WaitGroup
+	shutdownTimeout          time.Duration
+	metrics                  Metrics
+	tracer                   Tracer
+	returnRouteTTL           time.Duration
+	replyRoutes              *returnroute.Routes
 }
 
 // NewInbound creates a new AMQP inbound transport instance.
-func NewInbound(amqpServerURL, externalAddr, queueName, certFile, keyFile string) (*Inbound, error) {
+func NewInbound(amqpServerURL, externalAddr string, opts ...InboundOpt) (*Inbound, error) {
 	if amqpServerURL == "" {
 		return nil, errors.New("AMQP URL is mandatory")
 	}
@@ -44,140 +89,409 @@ func NewInbound(amqpServerURL, externalAddr, queueName, certFile, keyFile string
 		return nil, errors.New("external address is mandatory")
 	}
 
-	return &Inbound{
-		certFile:     certFile,
-		keyFile:      keyFile,
-		internalAddr: amqpServerURL,
-		externalAddr: externalAddr,
-		queueName:    queueName,
-		logger:       log.New("aries-framework/transport/amqp"),
-	}, nil
+	i := &Inbound{
+		internalAddr:             amqpServerURL,
+		externalAddr:             externalAddr,
+		logger:                   log.New("aries-framework/transport/amqp"),
+		reconnectInitialInterval: defaultReconnectInitialInterval,
+		reconnectMaxInterval:     defaultReconnectMaxInterval,
+		maxRetries:               defaultMaxRetries,
+		workers:                  1,
+		state:                    StateDisconnected,
+		done:                     make(chan struct{}),
+		served:                   make(chan struct{}),
+		shutdownTimeout:          defaultShutdownTimeout,
+		metrics:                  noopMetrics{},
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	i.replyRoutes = returnroute.New(i.returnRouteTTL)
+
+	// The consumer is cancelled by its tag on shutdown, so one is generated if none was set.
+	if i.consumerTag == "" {
+		i.consumerTag = "aries-amqp-" + uuid.New().String()
+	}
+
+	return i, nil
 }
 
 // Start the AMQP message loop.
+// If the connection to the AMQP server is lost after Start returns, the transport reconnects in the background.
 func (i *Inbound) Start(prov transport.Provider) error {
 	if prov == nil || prov.InboundMessageHandler() == nil {
 		return errors.New("creation of inbound handler failed")
 	}
 
-	conn, err := i.connection()
+	i.packager = prov.Packager()
+	i.msgHandler = prov.InboundMessageHandler()
+
+	if i.prefetchCount > 0 && !i.manualAck {
+		i.logger.Warnf("prefetch count %d is ignored since messages are acknowledged automatically", i.prefetchCount)
+	}
+
+	msgs, err := i.connect()
 	if err != nil {
 		return err
 	}
 
+	go i.listenAndServe(msgs)
+
+	return nil
+}
+
+// State returns the current state of the connection to the AMQP server.
+func (i *Inbound) State() ConnectionState {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	return i.state
+}
+
+func (i *Inbound) setState(state ConnectionState) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.state != StateStopped {
+		i.state = state
+	}
+}
+
+func (i *Inbound) connection() (*amqp.Connection, error) {
+	return i.dial(i.internalAddr)
+}
+
+// connect dials the AMQP server, declares the queue and starts consuming from it.
+func (i *Inbound) connect() (<-chan amqp.Delivery, error) {
+	conn, err := i.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	connClose := conn.NotifyClose(make(chan *amqp.Error, 1))
+
 	ch, err := conn.Channel()
 	if err != nil {
-		return errors.Wrap(err, "unable to get channel")
+		i.closeConnection(conn)
+
+		return nil, errors.Wrap(err, "unable to get channel")
 	}
 
-	q, err := ch.QueueDeclare(
-		i.queueName, // name
-		false,       // durable
-		false,       // delete when unused
-		false,       // exclusive
-		false,       // no-wait
-		nil,         // arguments
+	chClose := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	q, err := i.declare(ch)
+	if err != nil {
+		i.closeConnection(conn)
+
+		return nil, err
+	}
+
+	if i.manualAck && i.deadLetterExchange != "" {
+		err = ch.ExchangeDeclarePassive(
+			i.deadLetterExchange, // name
+			amqp.ExchangeTopic,   // kind, ignored for a passive declaration
+			true,                 // durable
+			false,                // auto-deleted
+			false,                // internal
+			false,                // no-wait
+			nil,                  // arguments
+		)
+		if err != nil {
+			i.closeConnection(conn)
+
+			return nil, errors.Wrapf(err, "dead-letter exchange %s is not available", i.deadLetterExchange)
+		}
+	}
+
+	if i.prefetchCount > 0 && i.manualAck {
+		err = ch.Qos(
+			i.prefetchCount, // prefetch count
+			0,               // prefetch size
+			false,           // global
+		)
+		if err != nil {
+			i.closeConnection(conn)
+
+			return nil, errors.Wrap(err, "unable to set prefetch count")
+		}
+	}
+
+	msgs, err := ch.Consume(
+		q.Name,              // queue
+		i.consumerTag,       // consumer
+		!i.manualAck,        // auto-ack
+		i.exclusiveConsumer, // exclusive
+		false,               // no-local
+		false,               // no-wait
+		nil,                 // args
 	)
 	if err != nil {
-		return errors.Wrap(err, "unable to declare queue")
+		i.closeConnection(conn)
+
+		return nil, errors.Wrap(err, "unable to consume")
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.state == StateStopped {
+		i.closeConnection(conn)
+
+		return nil, errors.New("transport is stopped")
 	}
 
 	i.conn = conn
 	i.ch = ch
 	i.que = q
-	i.packager = prov.Packager()
-	i.msgHandler = prov.InboundMessageHandler()
+	i.connClose = connClose
+	i.chClose = chClose
+	i.state = StateConnected
+
+	return msgs, nil
+}
 
-	go func() {
-		if err := i.listenAndServe(); err != nil {
-			i.logger.Fatalf("AMQP start with address [%s] failed, cause:  %v", i.externalAddr, err)
+// declare declares the queue and, if configured, the exchange it is bound to.
+func (i *Inbound) declare(ch *amqp.Channel) (amqp.Queue, error) {
+	q, err := ch.QueueDeclare(
+		i.queueName,       // name
+		i.queueDurable,    // durable
+		i.queueAutoDelete, // delete when unused
+		i.queueExclusive,  // exclusive
+		false,             // no-wait
+		i.queueArgs,       // arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, errors.Wrap(err, "unable to declare queue")
+	}
+
+	if i.exchangeName == "" {
+		return q, nil
+	}
+
+	err = ch.ExchangeDeclare(
+		i.exchangeName,    // name
+		i.exchangeKind,    // kind
+		i.exchangeDurable, // durable
+		false,             // auto-deleted
+		false,             // internal
+		false,             // no-wait
+		i.exchangeArgs,    // arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, errors.Wrap(err, "unable to declare exchange")
+	}
+
+	keys := i.bindingKeys
+	if len(keys) == 0 {
+		keys = []string{q.Name}
+	}
+
+	for _, key := range keys {
+		err = ch.QueueBind(
+			q.Name,         // queue name
+			key,            // routing key
+			i.exchangeName, // exchange
+			false,          // no-wait
+			nil,            // arguments
+		)
+		if err != nil {
+			return amqp.Queue{}, errors.Wrapf(err, "unable to bind queue with routing key %s", key)
 		}
-	}()
+	}
 
-	return nil
+	return q, nil
 }
 
-func (i *Inbound) connection() (*amqp.Connection, error) {
-	var conn *amqp.Connection
+func (i *Inbound) setQueueArg(name string, value interface{}) {
+	if i.queueArgs == nil {
+		i.queueArgs = amqp.Table{}
+	}
+
+	i.queueArgs[name] = value
+}
+
+func (i *Inbound) closeConnection(conn *amqp.Connection) {
+	if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		i.logger.Warnf("failed to close AMQP connection: %v", err)
+	}
+}
 
-	var err error
+// listenAndServe handles deliveries until the transport is stopped, reconnecting whenever the delivery
+// channel is closed by a lost connection or channel.
+func (i *Inbound) listenAndServe(msgs <-chan amqp.Delivery) {
+	defer close(i.served)
 
-	if i.certFile != "" && i.keyFile != "" {
-		config := &tls.Config{MinVersion: tls.VersionTLS13}
-		config.Certificates = make([]tls.Certificate, 1)
+	tasks := i.startWorkers()
+	defer i.stopWorkers(tasks)
 
-		config.Certificates[0], err = tls.LoadX509KeyPair(i.certFile, i.keyFile)
-		if err != nil {
-			return nil, errors.Wrap(err, "invalid cert")
+	for msgs != nil {
+		for d := range msgs {
+			i.metrics.MessageReceived()
+			i.dispatch(d, tasks)
 		}
 
-		conn, err = amqp.DialTLS(i.internalAddr, config)
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to connect to AMQP server")
+		if i.State() == StateStopped {
+			return
 		}
-	} else {
-		conn, err = amqp.Dial(i.internalAddr)
-		if err != nil {
-			return nil, errors.Wrapf(err, "unable to connect to AMQP server at %s", i.internalAddr)
+
+		i.logger.Warnf("AMQP connection with address [%s] lost, cause: %v", i.externalAddr, i.closeReason())
+
+		msgs = i.reconnect()
+	}
+}
+
+// closeReason returns the error reported through NotifyClose by the channel or the connection, if any.
+func (i *Inbound) closeReason() error {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	for _, c := range []chan *amqp.Error{i.chClose, i.connClose} {
+		select {
+		case err, ok := <-c:
+			if ok && err != nil {
+				return err
+			}
+		default:
 		}
 	}
 
-	return conn, nil
+	return errors.New("delivery channel closed")
 }
 
-func (i *Inbound) listenAndServe() error {
-	msgs, err := i.ch.Consume(
-		i.queueName, // queue
-		"",          // consumer
-		true,        // auto-ack
-		false,       // exclusive
-		false,       // no-local
-		false,       // no-wait
-		nil,         // args
-	)
-	if err != nil {
-		return errors.Wrap(err, "unable to consume")
-	}
+// reconnect tries to re-establish the connection with exponential backoff. It returns nil if the transport
+// was stopped or the backoff gave up.
+func (i *Inbound) reconnect() <-chan amqp.Delivery {
+	i.setState(StateReconnecting)
 
-	for d := range msgs {
-		message := d.Body
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = i.reconnectInitialInterval
+	b.MaxInterval = i.reconnectMaxInterval
+	b.MaxElapsedTime = i.reconnectMaxElapsedTime
+	b.Reset()
 
-		unpackMsg, err := i.packager.UnpackMessage(message)
-		if err != nil {
-			i.logger.Errorf("failed to unpack msg: %v", err)
+	for attempt := 1; ; attempt++ {
+		next := b.NextBackOff()
+		if next == backoff.Stop {
+			i.logger.Errorf("AMQP reconnect with address [%s] gave up after %d attempts", i.externalAddr, attempt-1)
+			i.setState(StateDisconnected)
 
-			continue
+			return nil
 		}
 
-		trans := &decorator.Transport{}
+		select {
+		case <-i.done:
+			return nil
+		case <-time.After(next):
+		}
 
-		err = json.Unmarshal(unpackMsg.Message, trans)
+		msgs, err := i.connect()
 		if err != nil {
-			i.logger.Errorf("unmarshal transport decorator : %v", err)
+			i.logger.Warnf("AMQP reconnect attempt %d with address [%s] failed, cause: %v",
+				attempt, i.externalAddr, err)
+
+			continue
 		}
 
-		messageHandler := i.msgHandler
+		i.logger.Infof("AMQP connection with address [%s] re-established", i.externalAddr)
+		i.metrics.Reconnected()
 
-		err = messageHandler(unpackMsg)
-		if err != nil {
-			i.logger.Errorf("incoming msg processing failed: %v", err)
-		}
+		return msgs
+	}
+}
+
+// unpack unpacks a delivery, dead-lettering it if that fails.
+func (i *Inbound) unpack(d amqp.Delivery) (*transport.Envelope, bool) {
+	unpackMsg, err := i.packager.UnpackMessage(d.Body)
+	if err != nil {
+		i.logger.Errorf("failed to unpack msg: %v", err)
+		i.metrics.UnpackFailed()
+		i.deadLetter(d, fmt.Errorf("failed to unpack msg: %w", err))
+
+		return nil, false
 	}
 
-	return nil
+	return unpackMsg, true
 }
 
-// Stop the AMQP message loop.
+// process hands an unpacked delivery to the inbound message handler.
+func (i *Inbound) process(d amqp.Delivery, unpackMsg *transport.Envelope) {
+	trans := &decorator.Transport{}
+
+	err := json.Unmarshal(unpackMsg.Message, trans)
+	if err != nil {
+		i.logger.Errorf("unmarshal transport decorator : %v", err)
+	}
+
+	i.addReturnRoute(d, unpackMsg, trans)
+
+	err = i.handleMessage(d, unpackMsg)
+	if err != nil {
+		i.logger.Errorf("incoming msg processing failed: %v", err)
+		i.retry(d, err)
+
+		return
+	}
+
+	i.ack(d)
+}
+
+// Stop the AMQP message loop. It waits for in-flight messages as described in Shutdown, for up to the shutdown
+// timeout set with WithShutdownTimeout.
 func (i *Inbound) Stop() error {
-	if err := i.ch.Close(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), i.shutdownTimeout)
+	defer cancel()
+
+	return i.Shutdown(ctx)
+}
+
+// Shutdown gracefully stops the AMQP message loop. It cancels the consumer so that no new messages are delivered,
+// waits until the messages already delivered have been handled or ctx is done, and then closes the channel and
+// the connection. If ctx is done first, unacknowledged messages are returned to the queue by the AMQP server.
+// Calling Shutdown on a transport that was never started or was already stopped does nothing.
+func (i *Inbound) Shutdown(ctx context.Context) error {
+	i.lock.Lock()
+
+	if i.state == StateStopped {
+		i.lock.Unlock()
+
+		return nil
+	}
+
+	i.state = StateStopped
+	close(i.done)
+
+	ch, conn := i.ch, i.conn
+
+	i.lock.Unlock()
+
+	// The transport never connected, so there is nothing to wait for or close.
+	if ch == nil {
+		return nil
+	}
+
+	if err := ch.Cancel(i.consumerTag, false); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		i.logger.Warnf("failed to cancel AMQP consumer %s: %v", i.consumerTag, err)
+	}
+
+	var waitErr error
+
+	select {
+	case <-i.served:
+	case <-ctx.Done():
+		waitErr = fmt.Errorf("in-flight messages not handled before shutdown: %w", ctx.Err())
+	}
+
+	if err := ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
 		return fmt.Errorf("channel shutdown failed: %w", err)
 	}
 
-	if err := i.conn.Close(); err != nil {
+	if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
 		return fmt.Errorf("connection shutdown failed: %w", err)
 	}
 
-	return nil
+	return waitErr
 }
 
 // Endpoint provides the AMQP connection details.
@@ -0,0 +1,600 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/packager"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/packer"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/packer/legacy/authcrypt"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	mockpackager "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/packager"
+	mockprovider "github.com/hyperledger/aries-framework-go/pkg/mock/provider"
+	mockstorage "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+// testAMQPAddr is the RabbitMQ instance started by TestMain in the external test package.
+const testAMQPAddr = "amqp://127.0.0.1:5673"
+
+func TestInboundReconnect(t *testing.T) {
+	t.Run("test inbound transport - default reconnect backoff", func(t *testing.T) {
+		inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue("queue"))
+		require.NoError(t, err)
+		require.Equal(t, defaultReconnectInitialInterval, inbound.reconnectInitialInterval)
+		require.Equal(t, defaultReconnectMaxInterval, inbound.reconnectMaxInterval)
+		require.Zero(t, inbound.reconnectMaxElapsedTime)
+		require.Equal(t, StateDisconnected, inbound.State())
+	})
+
+	t.Run("test inbound transport - reconnect after connection loss", func(t *testing.T) {
+		inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue("reconnect-queue"),
+			WithReconnectBackOff(10*time.Millisecond, 100*time.Millisecond, 0))
+		require.NoError(t, err)
+
+		received := make(chan struct{}, 1)
+
+		err = inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				received <- struct{}{}
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, StateConnected, inbound.State())
+
+		inbound.lock.RLock()
+		conn := inbound.conn
+		inbound.lock.RUnlock()
+
+		require.NoError(t, conn.Close())
+
+		require.Eventually(t, func() bool {
+			inbound.lock.RLock()
+			defer inbound.lock.RUnlock()
+
+			return inbound.state == StateConnected && inbound.conn != conn
+		}, 5*time.Second, 10*time.Millisecond)
+
+		inbound.lock.RLock()
+		ch := inbound.ch
+		inbound.lock.RUnlock()
+
+		require.NoError(t, ch.Publish("", "reconnect-queue", false, false, amqp.Publishing{Body: []byte("data")}))
+
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for message after reconnect")
+		}
+
+		require.NoError(t, inbound.Stop())
+		require.NoError(t, inbound.Stop())
+		require.Equal(t, StateStopped, inbound.State())
+	})
+
+	t.Run("test inbound transport - reconnect gives up", func(t *testing.T) {
+		inbound, err := NewInbound("amqp://127.0.0.1:1", "http://example.com", WithQueue("queue"),
+			WithReconnectBackOff(time.Millisecond, time.Millisecond, 10*time.Millisecond))
+		require.NoError(t, err)
+
+		require.Nil(t, inbound.reconnect())
+		require.Equal(t, StateDisconnected, inbound.State())
+	})
+}
+
+func TestInboundWorkers(t *testing.T) {
+	publish := func(t *testing.T, queue string, bodies ...string) {
+		t.Helper()
+
+		conn, err := amqp.Dial(testAMQPAddr)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, conn.Close()) }()
+
+		ch, err := conn.Channel()
+		require.NoError(t, err)
+
+		for _, body := range bodies {
+			require.NoError(t, ch.Publish("", queue, false, false, amqp.Publishing{Body: []byte(body)}))
+		}
+	}
+
+	t.Run("test inbound transport - slow handler does not block other workers", func(t *testing.T) {
+		queue := "workers-queue"
+
+		inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue(queue),
+			WithWorkers(2), WithPrefetch(2), WithManualAck())
+		require.NoError(t, err)
+
+		release := make(chan struct{})
+		done := make(chan struct{})
+
+		var calls int32
+
+		err = inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					// Only returns once a second worker has handled another message.
+					<-release
+					close(done)
+
+					return nil
+				}
+
+				close(release)
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		publish(t, queue, "first", "second")
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "messages were not handled concurrently")
+		}
+	})
+
+	t.Run("test inbound transport - order by sender", func(t *testing.T) {
+		queue := "ordered-queue"
+
+		inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue(queue),
+			WithWorkers(4), WithOrderBySender())
+		require.NoError(t, err)
+
+		var (
+			mutex    sync.Mutex
+			received = map[string][]string{}
+			count    int
+		)
+
+		const perSender = 20
+
+		err = inbound.Start(&testProvider{
+			packager: &senderPackager{},
+			handler: func(envelope *transport.Envelope) error {
+				time.Sleep(time.Duration(len(envelope.Message)%3) * time.Millisecond)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				received[string(envelope.FromKey)] = append(received[string(envelope.FromKey)], string(envelope.Message))
+				count++
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		var bodies, expectedA, expectedB []string
+
+		for n := 0; n < perSender; n++ {
+			a, b := fmt.Sprintf("a%d", n), fmt.Sprintf("b%d", n)
+			bodies = append(bodies, a, b)
+			expectedA = append(expectedA, a)
+			expectedB = append(expectedB, b)
+		}
+
+		publish(t, queue, bodies...)
+
+		require.Eventually(t, func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return count == 2*perSender
+		}, 5*time.Second, 10*time.Millisecond)
+
+		require.Equal(t, expectedA, received["a"])
+		require.Equal(t, expectedB, received["b"])
+	})
+
+	t.Run("test inbound transport - order by reply-to queue", func(t *testing.T) {
+		packager := &countingPackager{Packager: &senderPackager{}}
+		inbound := &Inbound{packager: packager}
+		tasks := []chan task{make(chan task, 1), make(chan task, 1)}
+
+		inbound.dispatch(amqp.Delivery{ReplyTo: "sender-queue", Body: []byte("a")}, tasks)
+
+		select {
+		case t1 := <-tasks[workerIndex([]byte("sender-queue"), len(tasks))]:
+			require.Nil(t, t1.envelope)
+		default:
+			require.Fail(t, "delivery was not routed by its reply-to queue")
+		}
+
+		require.Zero(t, atomic.LoadInt32(&packager.unpacked))
+
+		inbound.dispatch(amqp.Delivery{Body: []byte("a")}, tasks)
+
+		t2 := <-tasks[workerIndex([]byte("a"), len(tasks))]
+		require.NotNil(t, t2.envelope)
+		require.EqualValues(t, 1, atomic.LoadInt32(&packager.unpacked))
+	})
+
+	t.Run("test inbound transport - worker index", func(t *testing.T) {
+		require.Equal(t, workerIndex([]byte("key"), 8), workerIndex([]byte("key"), 8))
+		require.Zero(t, workerIndex([]byte("key"), 1))
+	})
+}
+
+func TestReturnRoute(t *testing.T) {
+	queue := "return-route-queue"
+	legacyPackager, senderKey, recipientKey := newLegacyPackager(t)
+
+	returnRoute, err := json.Marshal(&decorator.Transport{
+		ReturnRoute: &decorator.ReturnRoute{Value: decorator.TransportReturnRouteAll},
+	})
+	require.NoError(t, err)
+
+	request, err := legacyPackager.PackMessage(&transport.Envelope{
+		MediaTypeProfile: transport.MediaTypeRFC0019EncryptedEnvelope,
+		Message:          returnRoute,
+		FromKey:          []byte(senderKey),
+		ToKeys:           []string{recipientKey},
+	})
+	require.NoError(t, err)
+
+	inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue(queue), WithReturnRouteTTL(time.Minute))
+	require.NoError(t, err)
+
+	outbound, err := NewOutbound(WithReturnRoute(inbound))
+	require.NoError(t, err)
+
+	err = inbound.Start(&testProvider{
+		packager: legacyPackager,
+		handler: func(*transport.Envelope) error {
+			_, sendErr := outbound.Send([]byte("reply"), &service.Destination{
+				RecipientKeys:   []string{senderKey},
+				ServiceEndpoint: "http://unreachable.example.com",
+			})
+
+			return sendErr
+		},
+	})
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, inbound.Stop()) }()
+
+	conn, err := amqp.Dial(testAMQPAddr)
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, conn.Close()) }()
+
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+
+	replyQueue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	require.NoError(t, err)
+
+	replies, err := ch.Consume(replyQueue.Name, "", true, false, false, false, nil)
+	require.NoError(t, err)
+
+	err = ch.Publish("", queue, false, false, amqp.Publishing{
+		ReplyTo:       replyQueue.Name,
+		CorrelationId: "correlation-id",
+		Body:          request,
+	})
+	require.NoError(t, err)
+
+	select {
+	case d := <-replies:
+		require.Equal(t, []byte("reply"), d.Body)
+		require.Equal(t, "correlation-id", d.CorrelationId)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for reply")
+	}
+
+	require.True(t, outbound.AcceptRecipient([]string{"other", senderKey}))
+	require.False(t, outbound.AcceptRecipient([]string{"other"}))
+
+	t.Run("test return route - none removes the route", func(t *testing.T) {
+		envelope, errUnpack := legacyPackager.UnpackMessage(request)
+		require.NoError(t, errUnpack)
+
+		none := &decorator.Transport{ReturnRoute: &decorator.ReturnRoute{Value: decorator.TransportReturnRouteNone}}
+		inbound.addReturnRoute(amqp.Delivery{}, envelope, none)
+
+		require.False(t, outbound.AcceptRecipient([]string{senderKey}))
+		require.Error(t, inbound.reply([]byte("reply"), []string{senderKey}))
+	})
+
+	t.Run("test return route - outbound messages carry the inbound queue as reply-to", func(t *testing.T) {
+		require.Equal(t, queue, outbound.replyTo())
+	})
+}
+
+// newLegacyPackager creates a packager with the legacy authcrypt packer used by DIDComm v1 agents, and the did:keys
+// of a sender and a recipient whose private keys it holds.
+func newLegacyPackager(t *testing.T) (transport.Packager, string, string) {
+	t.Helper()
+
+	keyManager, err := localkms.New("local-lock://test/primary/", &mockprovider.Provider{
+		StorageProviderValue: mockstorage.NewMockStoreProvider(),
+		SecretLockValue:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	crypto, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	legacyPacker := authcrypt.New(&mockprovider.Provider{KMSValue: keyManager, CryptoValue: crypto})
+
+	p, err := packager.New(&mockprovider.Provider{
+		PackerList:      []packer.Packer{legacyPacker},
+		PackerValue:     legacyPacker,
+		VDRegistryValue: &mockvdr.MockVDRegistry{},
+	})
+	require.NoError(t, err)
+
+	_, senderKey, err := keyManager.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+
+	_, recipientKey, err := keyManager.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+
+	senderDIDKey, _ := fingerprint.CreateDIDKey(senderKey)
+	recipientDIDKey, _ := fingerprint.CreateDIDKey(recipientKey)
+
+	return p, senderDIDKey, recipientDIDKey
+}
+
+func TestInboundShutdown(t *testing.T) {
+	publish := func(t *testing.T, queue string) {
+		t.Helper()
+
+		conn, err := amqp.Dial(testAMQPAddr)
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, conn.Close()) }()
+
+		ch, err := conn.Channel()
+		require.NoError(t, err)
+
+		require.NoError(t, ch.Publish("", queue, false, false, amqp.Publishing{Body: []byte("data")}))
+	}
+
+	t.Run("test inbound transport - stop before start", func(t *testing.T) {
+		inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue("queue"))
+		require.NoError(t, err)
+
+		require.NoError(t, inbound.Stop())
+		require.NoError(t, inbound.Stop())
+		require.Equal(t, StateStopped, inbound.State())
+	})
+
+	t.Run("test inbound transport - shutdown waits for in-flight messages", func(t *testing.T) {
+		queue := "shutdown-queue"
+
+		inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue(queue), WithManualAck())
+		require.NoError(t, err)
+
+		started := make(chan struct{})
+
+		var handled int32
+
+		err = inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				close(started)
+				time.Sleep(200 * time.Millisecond)
+				atomic.StoreInt32(&handled, 1)
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		publish(t, queue)
+		<-started
+
+		require.NoError(t, inbound.Shutdown(context.Background()))
+		require.EqualValues(t, 1, atomic.LoadInt32(&handled))
+		require.NoError(t, inbound.Shutdown(context.Background()))
+	})
+
+	t.Run("test inbound transport - shutdown deadline", func(t *testing.T) {
+		queue := "shutdown-deadline-queue"
+
+		inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue(queue), WithManualAck(),
+			WithShutdownTimeout(10*time.Millisecond))
+		require.NoError(t, err)
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		err = inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				close(started)
+				<-release
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		publish(t, queue)
+		<-started
+
+		err = inbound.Stop()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "in-flight messages not handled before shutdown")
+
+		close(release)
+	})
+}
+
+func TestInboundObservability(t *testing.T) {
+	queue := "observed-queue"
+	traceParent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	metrics := &testMetrics{}
+	tracer := &testTracer{}
+
+	inbound, err := NewInbound(testAMQPAddr, "http://example.com", WithQueue(queue),
+		WithMetrics(metrics), WithTracer(tracer), WithReconnectBackOff(10*time.Millisecond, 10*time.Millisecond, 0))
+	require.NoError(t, err)
+
+	err = inbound.Start(&testProvider{
+		packager: &senderPackager{},
+		handler: func(envelope *transport.Envelope) error {
+			time.Sleep(time.Millisecond)
+
+			if string(envelope.Message) == "fail" {
+				return errors.New("handler error")
+			}
+
+			return nil
+		},
+	})
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, inbound.Stop()) }()
+
+	inbound.lock.RLock()
+	ch := inbound.ch
+	inbound.lock.RUnlock()
+
+	for _, body := range []string{"ok", "fail", ""} {
+		err = ch.Publish("", queue, false, false, amqp.Publishing{
+			Headers: amqp.Table{TraceParentHeader: traceParent, TraceStateHeader: "vendor=value"},
+			Body:    []byte(body),
+		})
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&metrics.received) == 3 && atomic.LoadInt32(&metrics.handled) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.EqualValues(t, 1, atomic.LoadInt32(&metrics.unpackFailed))
+	require.EqualValues(t, 1, atomic.LoadInt32(&metrics.handlerFailed))
+
+	tracer.lock.Lock()
+	require.Equal(t, []string{traceParent, traceParent}, tracer.traceParents)
+	tracer.lock.Unlock()
+
+	require.NoError(t, ch.Close())
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&metrics.reconnected) == 1 && inbound.State() == StateConnected
+	}, 5*time.Second, 10*time.Millisecond)
+
+	parent, state := TraceContext(amqp.Table{TraceStateHeader: "vendor=value"})
+	require.Empty(t, parent)
+	require.Equal(t, "vendor=value", state)
+}
+
+func TestConnectionState(t *testing.T) {
+	require.Equal(t, "disconnected", StateDisconnected.String())
+	require.Equal(t, "connected", StateConnected.String())
+	require.Equal(t, "reconnecting", StateReconnecting.String())
+	require.Equal(t, "stopped", StateStopped.String())
+	require.Equal(t, "unknown", ConnectionState(-1).String())
+}
+
+type testMetrics struct {
+	received, unpackFailed, handlerFailed, handled, reconnected int32
+}
+
+func (m *testMetrics) MessageReceived() { atomic.AddInt32(&m.received, 1) }
+func (m *testMetrics) UnpackFailed()    { atomic.AddInt32(&m.unpackFailed, 1) }
+func (m *testMetrics) HandlerFailed()   { atomic.AddInt32(&m.handlerFailed, 1) }
+func (m *testMetrics) Reconnected()     { atomic.AddInt32(&m.reconnected, 1) }
+
+func (m *testMetrics) HandlerDuration(d time.Duration) {
+	if d > 0 {
+		atomic.AddInt32(&m.handled, 1)
+	}
+}
+
+type testTracer struct {
+	lock         sync.Mutex
+	traceParents []string
+}
+
+func (t *testTracer) Trace(headers amqp.Table, envelope *transport.Envelope,
+	next transport.InboundMessageHandler) error {
+	traceParent, _ := TraceContext(headers)
+
+	t.lock.Lock()
+	t.traceParents = append(t.traceParents, traceParent)
+	t.lock.Unlock()
+
+	return next(envelope)
+}
+
+// senderPackager unpacks a message into an envelope whose sender key is the first byte of the message.
+type senderPackager struct{}
+
+func (p *senderPackager) PackMessage(envelope *transport.Envelope) ([]byte, error) {
+	return envelope.Message, nil
+}
+
+func (p *senderPackager) UnpackMessage(encMessage []byte) (*transport.Envelope, error) {
+	if len(encMessage) == 0 {
+		return nil, errors.New("empty message")
+	}
+
+	return &transport.Envelope{Message: encMessage, FromKey: encMessage[:1]}, nil
+}
+
+// countingPackager counts the messages it unpacks.
+type countingPackager struct {
+	transport.Packager
+	unpacked int32
+}
+
+func (p *countingPackager) UnpackMessage(encMessage []byte) (*transport.Envelope, error) {
+	atomic.AddInt32(&p.unpacked, 1)
+
+	return p.Packager.UnpackMessage(encMessage)
+}
+
+type testProvider struct {
+	packager transport.Packager
+	handler  transport.InboundMessageHandler
+}
+
+func (p *testProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return p.handler
+}
+
+func (p *testProvider) Packager() transport.Packager {
+	return p.packager
+}
+
+func (p *testProvider) AriesFrameworkID() string {
+	return "test"
+}
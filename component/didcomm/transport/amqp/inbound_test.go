@@ -79,20 +79,20 @@ func TestInboundTransport(t *testing.T) {
 	t.Run("test inbound transport - with host/port", func(t *testing.T) {
 		addr := amqpAddr
 
-		inbound, err := NewInbound(addr, externalAddr, "queue", "", "")
+		inbound, err := NewInbound(addr, externalAddr, WithQueue("queue"))
 		require.NoError(t, err)
 		require.Equal(t, externalAddr, inbound.Endpoint())
 	})
 
 	t.Run("test inbound transport - with host/port, no external address", func(t *testing.T) {
 		internalAddr := "amqp://example.com:5672"
-		_, err := NewInbound(internalAddr, "", "queue", "", "")
+		_, err := NewInbound(internalAddr, "", WithQueue("queue"))
 		require.Error(t, err)
 	})
 
 	t.Run("test inbound transport - without host/port", func(t *testing.T) {
 		port := ":5555"
-		inbound, err := NewInbound(port, externalAddr, "queue", "", "")
+		inbound, err := NewInbound(port, externalAddr, WithQueue("queue"))
 		require.NoError(t, err)
 
 		mockPackager := &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}}
@@ -102,7 +102,7 @@ func TestInboundTransport(t *testing.T) {
 
 	t.Run("test inbound transport - nil context", func(t *testing.T) {
 		addr := amqpAddr
-		inbound, err := NewInbound(addr, externalAddr, "queue", "", "")
+		inbound, err := NewInbound(addr, externalAddr, WithQueue("queue"))
 		require.NoError(t, err)
 		require.NotEmpty(t, inbound)
 
@@ -112,7 +112,7 @@ func TestInboundTransport(t *testing.T) {
 
 	t.Run("test inbound transport - invalid TLS", func(t *testing.T) {
 		addr := amqpAddr
-		inbound, err := NewInbound(addr, externalAddr, "queue", "invalid", "invalid")
+		inbound, err := NewInbound(addr, externalAddr, WithQueue("queue"), WithCertificate("invalid", "invalid"))
 		require.NoError(t, err)
 
 		mockPackager := &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}}
@@ -122,7 +122,7 @@ func TestInboundTransport(t *testing.T) {
 	})
 
 	t.Run("test inbound transport - invalid port number", func(t *testing.T) {
-		_, err := NewInbound("", "", "", "", "")
+		_, err := NewInbound("", "")
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "AMQP URL is mandatory")
 	})
@@ -133,7 +133,7 @@ func TestInboundDataProcessing(t *testing.T) {
 		addr := amqpAddr
 		queue := "queue1"
 		// initiate inbound with port
-		inbound, err := NewInbound(addr, "http://example.com", queue, "", "")
+		inbound, err := NewInbound(addr, "http://example.com", WithQueue(queue))
 		require.NoError(t, err)
 		require.NotEmpty(t, inbound)
 
@@ -168,7 +168,7 @@ func TestInboundDataProcessing(t *testing.T) {
 	t.Run("test inbound transport - unpacking error", func(t *testing.T) {
 		addr := amqpAddr
 		queue := "queue2"
-		inbound, err := NewInbound(addr, "http://example.com", queue, "", "")
+		inbound, err := NewInbound(addr, "http://example.com", WithQueue(queue))
 		require.NoError(t, err)
 		require.NotEmpty(t, inbound)
 
@@ -200,7 +200,7 @@ func TestInboundDataProcessing(t *testing.T) {
 		addr := amqpAddr
 		queue := "queue3"
 
-		inbound, err := NewInbound(addr, "http://example.com", queue, "", "")
+		inbound, err := NewInbound(addr, "http://example.com", WithQueue(queue))
 		require.NoError(t, err)
 		require.NotEmpty(t, inbound)
 
@@ -230,6 +230,210 @@ func TestInboundDataProcessing(t *testing.T) {
 	})
 }
 
+func TestInboundAcknowledgement(t *testing.T) {
+	const dlx = "dead-letter"
+
+	ch, cleanup := amqpClient(t, amqpAddr)
+	defer cleanup()
+
+	err := ch.ExchangeDeclare(dlx, amqp.ExchangeFanout, true, false, false, false, nil)
+	require.NoError(t, err)
+
+	deadLetters := func(t *testing.T, queue string) <-chan amqp.Delivery {
+		t.Helper()
+
+		_, err = ch.QueueDeclare(queue, false, false, false, false, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, ch.QueueBind(queue, "", dlx, false, nil))
+
+		msgs, err := ch.Consume(queue, "", true, false, false, false, nil)
+		require.NoError(t, err)
+
+		return msgs
+	}
+
+	publish := func(t *testing.T, queue string) {
+		t.Helper()
+
+		wait := make(chan amqp.Confirmation, 1)
+		_ = ch.NotifyPublish(wait)
+
+		require.NoError(t, ch.Publish("", queue, false, false, amqp.Publishing{Body: []byte("random")}))
+		<-wait
+	}
+
+	receive := func(t *testing.T, msgs <-chan amqp.Delivery) amqp.Delivery {
+		t.Helper()
+
+		select {
+		case d := <-msgs:
+			return d
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for dead-lettered message")
+		}
+
+		return amqp.Delivery{}
+	}
+
+	t.Run("test inbound transport - missing dead-letter exchange", func(t *testing.T) {
+		inbound, err := NewInbound(amqpAddr, externalAddr, WithQueue("ack-queue0"),
+			WithManualAck(), WithDeadLetterExchange("missing", ""))
+		require.NoError(t, err)
+
+		mockPackager := &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}}
+		err = inbound.Start(&mockProvider{packagerValue: mockPackager})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "dead-letter exchange missing is not available")
+	})
+
+	t.Run("test inbound transport - handler error is retried then dead-lettered", func(t *testing.T) {
+		queue := "ack-queue1"
+		msgs := deadLetters(t, "dead-letters1")
+
+		inbound, err := NewInbound(amqpAddr, externalAddr, WithQueue(queue),
+			WithManualAck(), WithMaxRetries(2), WithDeadLetterExchange(dlx, ""))
+		require.NoError(t, err)
+
+		mockPackager := &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("invalid-data")}}
+		err = inbound.Start(&mockProvider{packagerValue: mockPackager})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		publish(t, queue)
+
+		d := receive(t, msgs)
+		require.Equal(t, []byte("random"), d.Body)
+		require.Equal(t, "error", d.Headers[ErrorHeader])
+		require.Equal(t, queue, d.Headers[OriginalQueueHeader])
+		require.EqualValues(t, 2, d.Headers[RetryCountHeader])
+	})
+
+	t.Run("test inbound transport - unpack error is dead-lettered", func(t *testing.T) {
+		queue := "ack-queue2"
+		msgs := deadLetters(t, "dead-letters2")
+
+		inbound, err := NewInbound(amqpAddr, externalAddr, WithQueue(queue),
+			WithManualAck(), WithDeadLetterExchange(dlx, ""))
+		require.NoError(t, err)
+
+		mockPackager := &mockpackager.Packager{UnpackErr: errors.New("error unpacking")}
+		err = inbound.Start(&mockProvider{packagerValue: mockPackager})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		publish(t, queue)
+
+		d := receive(t, msgs)
+		require.Contains(t, d.Headers[ErrorHeader], "error unpacking")
+		require.Nil(t, d.Headers[RetryCountHeader])
+	})
+
+	t.Run("test inbound transport - successful message is acknowledged", func(t *testing.T) {
+		queue := "ack-queue3"
+
+		inbound, err := NewInbound(amqpAddr, externalAddr, WithQueue(queue), WithManualAck())
+		require.NoError(t, err)
+
+		mockPackager := &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("valid-data")}}
+		err = inbound.Start(&mockProvider{packagerValue: mockPackager})
+		require.NoError(t, err)
+
+		publish(t, queue)
+
+		require.Eventually(t, func() bool {
+			q, err := ch.QueueInspect(queue)
+
+			return err == nil && q.Messages == 0
+		}, 5*time.Second, 50*time.Millisecond)
+
+		// Unacknowledged messages would be returned to the queue once the consumer's channel is closed.
+		require.NoError(t, inbound.Stop())
+
+		q, err := ch.QueueInspect(queue)
+		require.NoError(t, err)
+		require.Zero(t, q.Messages)
+	})
+}
+
+func TestInboundQueueTopology(t *testing.T) {
+	ch, cleanup := amqpClient(t, amqpAddr)
+	defer cleanup()
+
+	mockPackager := &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("valid-data")}}
+
+	t.Run("test inbound transport - quorum queue with limits", func(t *testing.T) {
+		queue := "quorum-queue"
+
+		inbound, err := NewInbound(amqpAddr, externalAddr, WithQueue(queue),
+			WithQuorumQueue(), WithMessageTTL(time.Minute), WithMaxLength(100),
+			WithQueueArgs(amqp.Table{"x-max-length-bytes": int64(1 << 20)}))
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: mockPackager})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		// The queue was declared as a durable quorum queue, so redeclaring it as a transient classic queue fails.
+		conflictCh, conflictCleanup := amqpClient(t, amqpAddr)
+		defer conflictCleanup()
+
+		_, err = conflictCh.QueueDeclare(queue, false, false, false, false, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "PRECONDITION_FAILED")
+	})
+
+	t.Run("test inbound transport - exchange bindings", func(t *testing.T) {
+		queue := "bound-queue"
+
+		inbound, err := NewInbound(amqpAddr, externalAddr, WithQueue(queue), WithDurableQueue(),
+			WithExchange("didcomm", amqp.ExchangeTopic, true, nil), WithBindingKeys("agent.*", "mediator.#"),
+			WithConsumerTag("bound-consumer"), WithExclusiveConsumer())
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: mockPackager})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		wait := make(chan amqp.Confirmation, 2)
+		_ = ch.NotifyPublish(wait)
+
+		for _, key := range []string{"agent.alice", "mediator.bob.inbox"} {
+			require.NoError(t, ch.Publish("didcomm", key, false, false, amqp.Publishing{Body: []byte("random")}))
+			<-wait
+		}
+
+		require.Eventually(t, func() bool {
+			q, err := ch.QueueInspect(queue)
+
+			return err == nil && q.Messages == 0 && q.Consumers == 1
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("test inbound transport - server-named queue", func(t *testing.T) {
+		inbound, err := NewInbound(amqpAddr, externalAddr, WithAutoDeleteQueue(), WithExclusiveQueue())
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: mockPackager})
+		require.NoError(t, err)
+		require.NoError(t, inbound.Stop())
+	})
+
+	t.Run("test inbound transport - invalid exchange kind", func(t *testing.T) {
+		inbound, err := NewInbound(amqpAddr, externalAddr, WithQueue("queue"),
+			WithExchange("invalid", "invalid", false, nil))
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: mockPackager})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to declare exchange")
+	})
+}
+
 func amqpClient(t *testing.T, addr string) (ch *amqp.Channel, cleanup func()) {
 	t.Helper()
 
@@ -0,0 +1,82 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+import (
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// TraceParentHeader is the message header carrying the W3C Trace Context traceparent.
+	TraceParentHeader = "traceparent"
+	// TraceStateHeader is the message header carrying the W3C Trace Context tracestate.
+	TraceStateHeader = "tracestate"
+)
+
+// Metrics receives measurements from the AMQP inbound transport, for example to export them to Prometheus.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// MessageReceived is called for every message delivered by the AMQP server.
+	MessageReceived()
+	// UnpackFailed is called when a message cannot be unpacked.
+	UnpackFailed()
+	// HandlerFailed is called when the inbound message handler returns an error.
+	HandlerFailed()
+	// HandlerDuration is called with the time the inbound message handler took, whether it failed or not.
+	HandlerDuration(d time.Duration)
+	// Reconnected is called when the connection to the AMQP server has been re-established.
+	Reconnected()
+}
+
+// Tracer continues the trace carried in the headers of AMQP messages, so that a DIDComm exchange can be followed
+// across services. Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Trace invokes next, the inbound message handler, for a message with the given headers. It typically extracts
+	// the trace context from the headers (see TraceContext), starts a span for the handler and finishes it once
+	// next returns.
+	Trace(headers amqp.Table, envelope *transport.Envelope, next transport.InboundMessageHandler) error
+}
+
+// TraceContext returns the W3C Trace Context carried in the headers of an AMQP message, if any.
+func TraceContext(headers amqp.Table) (traceParent, traceState string) {
+	traceParent, _ = headers[TraceParentHeader].(string)
+	traceState, _ = headers[TraceStateHeader].(string)
+
+	return traceParent, traceState
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) MessageReceived()              {}
+func (noopMetrics) UnpackFailed()                 {}
+func (noopMetrics) HandlerFailed()                {}
+func (noopMetrics) HandlerDuration(time.Duration) {}
+func (noopMetrics) Reconnected()                  {}
+
+// handleMessage invokes the inbound message handler through the tracer, if any, and records its metrics.
+func (i *Inbound) handleMessage(d amqp.Delivery, envelope *transport.Envelope) error {
+	start := time.Now()
+
+	var err error
+
+	if i.tracer != nil {
+		err = i.tracer.Trace(d.Headers, envelope, i.msgHandler)
+	} else {
+		err = i.msgHandler(envelope)
+	}
+
+	i.metrics.HandlerDuration(time.Since(start))
+
+	if err != nil {
+		i.metrics.HandlerFailed()
+	}
+
+	return err
+}
@@ -0,0 +1,297 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	queueTypeArg  = "x-queue-type"
+	messageTTLArg = "x-message-ttl"
+	maxLengthArg  = "x-max-length"
+	quorumQueue   = "quorum"
+)
+
+// InboundOpt is an option for the AMQP inbound transport.
+type InboundOpt func(i *Inbound)
+
+// WithQueue sets the name of the queue the inbound transport consumes from.
+// If not set, the AMQP server generates a queue name.
+func WithQueue(name string) InboundOpt {
+	return func(i *Inbound) {
+		i.queueName = name
+	}
+}
+
+// WithCertificate sets the client certificate and key used to connect to the AMQP server over TLS.
+// Like all TLS settings, it only applies to amqps URLs and is ignored for amqp URLs.
+func WithCertificate(certFile, keyFile string) InboundOpt {
+	return func(i *Inbound) {
+		i.certFile = certFile
+		i.keyFile = keyFile
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to the AMQP server, for example to set the server name
+// or the root CAs of a private PKI. Certificates set with WithCertificate are added to it.
+// If not set and TLS is otherwise configured, a configuration requiring TLS 1.3 is used.
+func WithTLSConfig(config *tls.Config) InboundOpt {
+	return func(i *Inbound) {
+		i.tlsConfig = config
+	}
+}
+
+// WithCACertificate sets the path of a PEM bundle of CA certificates used to verify the AMQP server's certificate.
+// It replaces the root CAs of a configuration set with WithTLSConfig.
+func WithCACertificate(caFile string) InboundOpt {
+	return func(i *Inbound) {
+		i.caFile = caFile
+	}
+}
+
+// WithSASLMechanism sets the SASL mechanism used to authenticate with the AMQP server. Defaults to SASLPlain.
+func WithSASLMechanism(mechanism SASLMechanism) InboundOpt {
+	return func(i *Inbound) {
+		i.saslMechanism = mechanism
+	}
+}
+
+// WithDurableQueue declares the queue as durable so that it survives a restart of the AMQP server.
+// Queues are not durable by default.
+func WithDurableQueue() InboundOpt {
+	return func(i *Inbound) {
+		i.queueDurable = true
+	}
+}
+
+// WithAutoDeleteQueue declares the queue as auto-deleted, so it is removed once its last consumer is gone.
+func WithAutoDeleteQueue() InboundOpt {
+	return func(i *Inbound) {
+		i.queueAutoDelete = true
+	}
+}
+
+// WithExclusiveQueue declares the queue as exclusive to the inbound transport's connection.
+func WithExclusiveQueue() InboundOpt {
+	return func(i *Inbound) {
+		i.queueExclusive = true
+	}
+}
+
+// WithQuorumQueue declares the queue as a durable quorum queue.
+func WithQuorumQueue() InboundOpt {
+	return func(i *Inbound) {
+		i.queueDurable = true
+		i.setQueueArg(queueTypeArg, quorumQueue)
+	}
+}
+
+// WithMessageTTL sets the time a message may stay in the queue before it expires.
+func WithMessageTTL(ttl time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.setQueueArg(messageTTLArg, ttl.Milliseconds())
+	}
+}
+
+// WithMaxLength sets the maximum number of messages the queue holds.
+func WithMaxLength(maxLength int64) InboundOpt {
+	return func(i *Inbound) {
+		i.setQueueArg(maxLengthArg, maxLength)
+	}
+}
+
+// WithQueueArgs adds arguments to the queue declaration, for example x-dead-letter-exchange.
+// Arguments set by other options with the same name are overwritten.
+func WithQueueArgs(args amqp.Table) InboundOpt {
+	return func(i *Inbound) {
+		for k, v := range args {
+			i.setQueueArg(k, v)
+		}
+	}
+}
+
+// WithExchange declares an exchange of the given kind (for example amqp.ExchangeTopic) and binds the queue to it
+// with the routing keys set by WithBindingKeys, or with the queue name if there are none.
+func WithExchange(name, kind string, durable bool, args amqp.Table) InboundOpt {
+	return func(i *Inbound) {
+		i.exchangeName = name
+		i.exchangeKind = kind
+		i.exchangeDurable = durable
+		i.exchangeArgs = args
+	}
+}
+
+// WithBindingKeys sets the routing keys the queue is bound to the exchange with. Only used with WithExchange.
+func WithBindingKeys(keys ...string) InboundOpt {
+	return func(i *Inbound) {
+		i.bindingKeys = append(i.bindingKeys, keys...)
+	}
+}
+
+// WithConsumerTag sets the consumer tag. If not set, a unique tag is generated.
+func WithConsumerTag(tag string) InboundOpt {
+	return func(i *Inbound) {
+		i.consumerTag = tag
+	}
+}
+
+// WithExclusiveConsumer makes the inbound transport the only consumer of the queue.
+func WithExclusiveConsumer() InboundOpt {
+	return func(i *Inbound) {
+		i.exclusiveConsumer = true
+	}
+}
+
+// WithReconnectBackOff sets the exponential backoff used to reconnect to the AMQP server after the connection
+// is lost. The delay between attempts starts at initial and doubles up to max. If maxElapsed is greater than zero
+// the transport gives up reconnecting after that much time, otherwise it retries forever.
+// Defaults to an initial delay of 500ms, a maximum delay of 30s and no time limit.
+func WithReconnectBackOff(initial, max, maxElapsed time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.reconnectInitialInterval = initial
+		i.reconnectMaxInterval = max
+		i.reconnectMaxElapsedTime = maxElapsed
+	}
+}
+
+// WithManualAck switches the inbound transport from auto-ack to manual acknowledgement. A message is acknowledged
+// only once the inbound message handler succeeds. Messages whose handler fails are requeued up to the retry limit
+// (see WithMaxRetries) and then dead-lettered, as are messages that cannot be unpacked.
+func WithManualAck() InboundOpt {
+	return func(i *Inbound) {
+		i.manualAck = true
+	}
+}
+
+// WithMaxRetries sets how many times a message whose inbound message handler failed is requeued before it is
+// dead-lettered. Only used with WithManualAck. Defaults to 3.
+func WithMaxRetries(maxRetries int) InboundOpt {
+	return func(i *Inbound) {
+		i.maxRetries = maxRetries
+	}
+}
+
+// WithDeadLetterExchange sets the exchange that permanently failing and unpackable messages are published to,
+// with the error recorded in the ErrorHeader header. The exchange must already exist. If routingKey is empty the
+// name of the inbound queue is used. Only used with WithManualAck. If not set, such messages are rejected without
+// requeue, so they are dropped unless the queue itself has a dead-letter exchange configured.
+func WithDeadLetterExchange(exchange, routingKey string) InboundOpt {
+	return func(i *Inbound) {
+		i.deadLetterExchange = exchange
+		i.deadLetterRoutingKey = routingKey
+	}
+}
+
+// WithWorkers sets the number of goroutines that handle messages concurrently. Defaults to 1.
+// Use WithOrderBySender to keep messages from the same sender in order.
+func WithWorkers(workers int) InboundOpt {
+	return func(i *Inbound) {
+		if workers > 0 {
+			i.workers = workers
+		}
+	}
+}
+
+// WithPrefetch sets the number of unacknowledged messages the AMQP server delivers to the transport ahead of
+// handling. It is unlimited if not set. The AMQP server only bounds deliveries that are acknowledged manually, so
+// WithPrefetch requires WithManualAck: with auto-ack it has no effect, and the transport logs a warning on Start.
+func WithPrefetch(count int) InboundOpt {
+	return func(i *Inbound) {
+		i.prefetchCount = count
+	}
+}
+
+// WithOrderBySender preserves the order of messages that have the same sender by always handing them to the same
+// worker. Messages with a reply-to queue, such as those published by an outbound transport set up with
+// WithReturnRoute, are routed by that queue, which identifies the sending agent without unpacking them. Other
+// messages are routed by sender key, so they must be unpacked before being handed to a worker: they are unpacked
+// one at a time, and only their handling runs in parallel.
+func WithOrderBySender() InboundOpt {
+	return func(i *Inbound) {
+		i.orderBySender = true
+	}
+}
+
+// WithShutdownTimeout sets how long Stop waits for in-flight messages to be handled. Defaults to 10 seconds.
+func WithShutdownTimeout(timeout time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.shutdownTimeout = timeout
+	}
+}
+
+// WithReturnRouteTTL sets how long the reply queue of a sender that asked for return routing is kept after its
+// last message, see WithReturnRoute. Defaults to 10 minutes.
+func WithReturnRouteTTL(ttl time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.returnRouteTTL = ttl
+	}
+}
+
+// WithMetrics sets the receiver of the inbound transport's metrics. No metrics are recorded by default.
+func WithMetrics(metrics Metrics) InboundOpt {
+	return func(i *Inbound) {
+		i.metrics = metrics
+	}
+}
+
+// WithTracer sets the tracer that invokes the inbound message handler within the trace carried by each message.
+func WithTracer(tracer Tracer) InboundOpt {
+	return func(i *Inbound) {
+		i.tracer = tracer
+	}
+}
+
+// OutboundOpt is an option for the AMQP outbound transport.
+type OutboundOpt func(o *Outbound)
+
+// WithOutboundCertificate sets the client certificate and key used to connect to AMQP servers over TLS.
+func WithOutboundCertificate(certFile, keyFile string) OutboundOpt {
+	return func(o *Outbound) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// WithOutboundTLSConfig sets the TLS configuration used to connect to AMQP servers.
+// See WithTLSConfig for details.
+func WithOutboundTLSConfig(config *tls.Config) OutboundOpt {
+	return func(o *Outbound) {
+		o.tlsConfig = config
+	}
+}
+
+// WithOutboundCACertificate sets the path of a PEM bundle of CA certificates used to verify AMQP servers'
+// certificates. See WithCACertificate for details.
+func WithOutboundCACertificate(caFile string) OutboundOpt {
+	return func(o *Outbound) {
+		o.caFile = caFile
+	}
+}
+
+// WithOutboundSASLMechanism sets the SASL mechanism used to authenticate with AMQP servers.
+// Defaults to SASLPlain.
+func WithOutboundSASLMechanism(mechanism SASLMechanism) OutboundOpt {
+	return func(o *Outbound) {
+		o.saslMechanism = mechanism
+	}
+}
+
+// WithReturnRoute links the outbound transport with an inbound transport to support the DIDComm ~transport
+// return_route decorator. Messages sent to a sender that asked the inbound transport for return routing are
+// published to the reply-to queue of its message, with its correlation ID, rather than to the sender's endpoint,
+// until the route expires (see WithReturnRouteTTL) or the sender asks for a return_route of none.
+// Messages published by the outbound transport carry the inbound transport's queue as their reply-to queue, so
+// recipients on the same AMQP server can reply to them in the same way.
+func WithReturnRoute(inbound *Inbound) OutboundOpt {
+	return func(o *Outbound) {
+		o.inbound = inbound
+	}
+}
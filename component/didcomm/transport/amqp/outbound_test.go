@@ -0,0 +1,85 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/amqp"
+)
+
+func TestOutboundTransport(t *testing.T) {
+	t.Run("test outbound transport - accept", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+		require.NoError(t, outbound.Start(&mockProvider{}))
+
+		require.True(t, outbound.Accept("amqp://example.com:5672?queue=queue"))
+		require.True(t, outbound.Accept("amqps://example.com:5671?queue=queue"))
+		require.False(t, outbound.Accept("http://example.com"))
+		require.False(t, outbound.AcceptRecipient([]string{"key"}))
+	})
+
+	t.Run("test outbound transport - invalid destination", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+
+		_, err = outbound.Send([]byte("data"), nil)
+		require.EqualError(t, err, "destination is mandatory")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "http://example.com?queue=q"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported endpoint scheme")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: amqpAddr})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a queue")
+	})
+
+	t.Run("test outbound transport - invalid TLS", func(t *testing.T) {
+		outbound, err := NewOutbound(WithOutboundCertificate("invalid", "invalid"))
+		require.NoError(t, err)
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: amqpAddr + "?queue=q"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid cert")
+	})
+
+	t.Run("test outbound transport - send", func(t *testing.T) {
+		queue := "outbound-queue"
+
+		ch, cleanup := amqpClient(t, amqpAddr)
+		defer cleanup()
+
+		_, err := ch.QueueDeclare(queue, false, false, false, false, nil)
+		require.NoError(t, err)
+
+		msgs, err := ch.Consume(queue, "", true, false, false, false, nil)
+		require.NoError(t, err)
+
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			_, err = outbound.Send([]byte("packed"), &service.Destination{ServiceEndpoint: amqpAddr + "?queue=" + queue})
+			require.NoError(t, err)
+
+			select {
+			case d := <-msgs:
+				require.Equal(t, []byte("packed"), d.Body)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "timed out waiting for message")
+			}
+		}
+
+		require.NoError(t, outbound.Stop())
+	})
+}
@@ -0,0 +1,84 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+
+	"github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/returnroute"
+)
+
+// replyRoute is where replies to a sender that asked for return routing are published.
+type replyRoute struct {
+	replyTo       string
+	correlationID string
+}
+
+// addReturnRoute records the reply queue of a delivery whose message carries a ~transport return_route decorator,
+// so that messages sent back to its sender are published to that queue instead of the sender's endpoint.
+// A return_route of none removes the sender's route.
+func (i *Inbound) addReturnRoute(d amqp.Delivery, envelope *transport.Envelope, trans *decorator.Transport) {
+	if returnroute.Requested(trans) && d.ReplyTo == "" {
+		i.logger.Warnf("return route requested but the message has no reply-to queue")
+
+		return
+	}
+
+	if !i.replyRoutes.Update(envelope, trans, replyRoute{replyTo: d.ReplyTo, correlationID: d.CorrelationId}) {
+		i.logger.Warnf("return route requested but the sender key of the message is unknown")
+	}
+}
+
+// returnRoute finds the reply route of the first recipient key that has one.
+func (i *Inbound) returnRoute(keys []string) (replyRoute, bool) {
+	route, ok := i.replyRoutes.Get(keys)
+	if !ok {
+		return replyRoute{}, false
+	}
+
+	r, ok := route.(replyRoute)
+
+	return r, ok
+}
+
+// reply publishes a packed message to the reply queue of one of the recipient keys, with the correlation ID
+// of the delivery that asked for return routing.
+func (i *Inbound) reply(data []byte, keys []string) error {
+	route, ok := i.returnRoute(keys)
+	if !ok {
+		return fmt.Errorf("no return route for recipient keys %v", keys)
+	}
+
+	i.lock.RLock()
+	ch := i.ch
+	i.lock.RUnlock()
+
+	if ch == nil {
+		return errors.New("inbound transport is not started")
+	}
+
+	err := ch.Publish(
+		"",            // exchange
+		route.replyTo, // routing key
+		false,         // mandatory
+		false,         // immediate
+		amqp.Publishing{
+			ContentType:   commContentType,
+			CorrelationId: route.correlationID,
+			Body:          data,
+		})
+	if err != nil {
+		return errors.Wrapf(err, "unable to publish to reply queue %s", route.replyTo)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+// ConnectionState describes the state of the inbound transport's connection to the AMQP server.
+type ConnectionState int
+
+const (
+	// StateDisconnected means the transport is not connected, either because it was never started or because
+	// it gave up reconnecting.
+	StateDisconnected ConnectionState = iota
+	// StateConnected means the transport is connected and consuming messages.
+	StateConnected
+	// StateReconnecting means the connection was lost and the transport is trying to re-establish it.
+	StateReconnecting
+	// StateStopped means the transport was stopped.
+	StateStopped
+)
+
+// String returns the name of the connection state.
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package amqp
+
+import (
+	"hash/fnv"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/streadway/amqp"
+)
+
+// task is a delivery waiting to be handled by a worker. The envelope is set if the delivery was already unpacked
+// in order to route it by sender key.
+type task struct {
+	delivery amqp.Delivery
+	envelope *transport.Envelope
+}
+
+// startWorkers starts the worker goroutines and returns the channels tasks are sent on. When ordering by sender
+// every worker has its own channel, otherwise all workers share a single one.
+func (i *Inbound) startWorkers() []chan task {
+	if !i.orderBySender {
+		tasks := make(chan task)
+
+		for w := 0; w < i.workers; w++ {
+			i.workerGroup.Add(1)
+
+			go i.work(tasks)
+		}
+
+		return []chan task{tasks}
+	}
+
+	tasks := make([]chan task, i.workers)
+
+	for w := range tasks {
+		tasks[w] = make(chan task)
+
+		i.workerGroup.Add(1)
+
+		go i.work(tasks[w])
+	}
+
+	return tasks
+}
+
+// stopWorkers stops the workers once they have handled all pending tasks, and waits for them to finish.
+func (i *Inbound) stopWorkers(tasks []chan task) {
+	for _, t := range tasks {
+		close(t)
+	}
+
+	i.workerGroup.Wait()
+}
+
+func (i *Inbound) work(tasks <-chan task) {
+	defer i.workerGroup.Done()
+
+	for t := range tasks {
+		envelope := t.envelope
+
+		if envelope == nil {
+			var ok bool
+
+			envelope, ok = i.unpack(t.delivery)
+			if !ok {
+				continue
+			}
+		}
+
+		i.process(t.delivery, envelope)
+	}
+}
+
+// dispatch hands a delivery to a worker. When ordering by sender, a delivery with a reply-to queue goes to the
+// worker of that queue, so that all messages of the sending agent go to the same worker, and is unpacked by that
+// worker. Other deliveries have to be unpacked here, one at a time, so that all messages with the same sender key
+// go to the same worker.
+func (i *Inbound) dispatch(d amqp.Delivery, tasks []chan task) {
+	if len(tasks) == 1 {
+		tasks[0] <- task{delivery: d}
+
+		return
+	}
+
+	if d.ReplyTo != "" {
+		tasks[workerIndex([]byte(d.ReplyTo), len(tasks))] <- task{delivery: d}
+
+		return
+	}
+
+	envelope, ok := i.unpack(d)
+	if !ok {
+		return
+	}
+
+	tasks[workerIndex(envelope.FromKey, len(tasks))] <- task{delivery: d, envelope: envelope}
+}
+
+func workerIndex(key []byte, workers int) int {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,315 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package dedup drops DIDComm messages redelivered by broker transports before they reach the inbound message
+// handler of Aries (aries-framework-go).
+//
+// Broker transports deliver messages at least once, so a message may be redelivered, for example after a lost
+// acknowledgement or a consumer crash. A Deduplicator records the id of every message handled successfully in an
+// Aries storage provider, such as the ones in component/storage, and drops later messages with the same id until
+// the retention window has passed. It is added to any inbound transport by starting the transport with the
+// provider returned by WrapProvider:
+//
+//	d, err := dedup.New(storageProvider, dedup.WithRetention(time.Hour))
+//	...
+//	err = inbound.Start(d.WrapProvider(prov))
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	defaultStoreName = "didcomm_dedup"
+	defaultRetention = 24 * time.Hour
+
+	// expiryTag is the tag holding the Unix time, in seconds, at which a record of a handled message expires.
+	expiryTag = "expiry"
+)
+
+// ErrInFlight is returned by the deduplicating handler for a message whose duplicate is being handled at the
+// same time. Broker transports redeliver the message later, when it is either recorded as handled or, if the
+// handler of its duplicate failed, handled again.
+var ErrInFlight = errors.New("a message with the same id is being handled")
+
+// Deduplicator drops DIDComm messages that were already handled successfully.
+// Checking and recording messages is atomic within a Deduplicator only, so agents sharing a storage provider may
+// occasionally both handle a message delivered to each of them at the same time.
+type Deduplicator struct {
+	store     storage.Store
+	retention time.Duration
+	now       func() time.Time
+	inflight  map[string]struct{}
+	lock      sync.Mutex
+	logger    *log.Log
+}
+
+// Option configures a Deduplicator.
+type Option func(d *Deduplicator)
+
+// WithRetention sets how long a handled message is remembered. Redeliveries after that are handled again.
+// Defaults to 24 hours.
+func WithRetention(retention time.Duration) Option {
+	return func(d *Deduplicator) {
+		d.retention = retention
+	}
+}
+
+// New creates a Deduplicator that records handled messages in a store of the given storage provider, named
+// didcomm_dedup.
+func New(provider storage.Provider, opts ...Option) (*Deduplicator, error) {
+	if provider == nil {
+		return nil, errors.New("storage provider is mandatory")
+	}
+
+	store, err := provider.OpenStore(defaultStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %w", defaultStoreName, err)
+	}
+
+	err = provider.SetStoreConfig(defaultStoreName, storage.StoreConfiguration{TagNames: []string{expiryTag}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set store configuration: %w", err)
+	}
+
+	d := &Deduplicator{
+		store:     store,
+		retention: defaultRetention,
+		now:       time.Now,
+		inflight:  map[string]struct{}{},
+		logger:    log.New("aries-framework/transport/dedup"),
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d, nil
+}
+
+// WrapProvider returns a transport provider whose inbound message handler is the one of prov, deduplicated.
+func (d *Deduplicator) WrapProvider(prov transport.Provider) transport.Provider {
+	if prov == nil {
+		return nil
+	}
+
+	return &provider{Provider: prov, handler: d.Handler(prov.InboundMessageHandler())}
+}
+
+// Handler wraps an inbound message handler so that messages already handled successfully within the retention
+// window are dropped. A message is recorded as handled only if next succeeds, so a redelivery of a message whose
+// handler failed is handled again. If the message cannot be recorded, the error is returned, so that the transport
+// does not acknowledge a message that would not be recognised when redelivered. Messages without an id are always
+// handed to next.
+func (d *Deduplicator) Handler(next transport.InboundMessageHandler) transport.InboundMessageHandler {
+	if next == nil {
+		return nil
+	}
+
+	return func(envelope *transport.Envelope) error {
+		key, ok := messageKey(envelope)
+		if !ok {
+			return next(envelope)
+		}
+
+		handled, err := d.begin(key)
+		if err != nil {
+			return err
+		}
+
+		if handled {
+			d.logger.Debugf("dropping duplicate message %s", key)
+
+			return nil
+		}
+
+		defer d.end(key)
+
+		if err = next(envelope); err != nil {
+			return err
+		}
+
+		return d.record(key)
+	}
+}
+
+// begin checks whether a message was already handled and, if not, marks it as being handled. The message is
+// reserved before the store is looked up, so that the lock is not held during the lookup while duplicates still
+// see it in flight, and the reservation is released if it turns out to be handled already.
+func (d *Deduplicator) begin(key string) (bool, error) {
+	d.lock.Lock()
+
+	if _, ok := d.inflight[key]; ok {
+		d.lock.Unlock()
+
+		return false, ErrInFlight
+	}
+
+	d.inflight[key] = struct{}{}
+
+	d.lock.Unlock()
+
+	handled, err := d.handled(key)
+	if err != nil || handled {
+		d.end(key)
+	}
+
+	return handled, err
+}
+
+func (d *Deduplicator) end(key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.inflight, key)
+}
+
+// handled checks whether the store holds an unexpired record of the message.
+func (d *Deduplicator) handled(key string) (bool, error) {
+	value, err := d.store.Get(key)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to look up message %s: %w", key, err)
+	}
+
+	expiry, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		// An unreadable record does not prove the message was handled.
+		d.logger.Warnf("invalid record of message %s: %v", key, err)
+
+		return false, nil
+	}
+
+	return d.now().Unix() < expiry, nil
+}
+
+// record stores that a message was handled.
+func (d *Deduplicator) record(key string) error {
+	expiry := strconv.FormatInt(d.now().Add(d.retention).Unix(), 10)
+
+	if err := d.store.Put(key, []byte(expiry), storage.Tag{Name: expiryTag, Value: expiry}); err != nil {
+		return fmt.Errorf("failed to record message %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Prune deletes the records of handled messages whose retention window has passed. Expired records are ignored
+// either way, so Prune only keeps the store from growing and may be called periodically, at any interval.
+func (d *Deduplicator) Prune() error {
+	iter, err := d.store.Query(expiryTag)
+	if err != nil {
+		return fmt.Errorf("failed to query message records: %w", err)
+	}
+
+	defer func() {
+		if errClose := iter.Close(); errClose != nil {
+			d.logger.Warnf("failed to close iterator: %v", errClose)
+		}
+	}()
+
+	expired, err := expiredRecords(iter, d.now().Unix())
+	if err != nil {
+		return err
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	if err = d.store.Batch(expired); err != nil {
+		return fmt.Errorf("failed to delete expired message records: %w", err)
+	}
+
+	return nil
+}
+
+// expiredRecords returns delete operations for the records of the iterator that expired before now.
+func expiredRecords(iter storage.Iterator, now int64) ([]storage.Operation, error) {
+	var expired []storage.Operation
+
+	for {
+		more, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next message record: %w", err)
+		}
+
+		if !more {
+			return expired, nil
+		}
+
+		key, err := iter.Key()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message record key: %w", err)
+		}
+
+		value, err := iter.Value()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message record value: %w", err)
+		}
+
+		expiry, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil || expiry <= now {
+			expired = append(expired, storage.Operation{Key: key})
+		}
+	}
+}
+
+// messageKey returns the key a message is recorded under: the hex SHA-256 digest of its DIDComm v1 @id or DIDComm
+// v2 id, qualified by the sender's key, if any, so that a sender cannot suppress the messages of another by reusing
+// their ids. The digest keeps keys short enough for every storage provider, whatever the length of ids and keys.
+func messageKey(envelope *transport.Envelope) (string, bool) {
+	if envelope == nil {
+		return "", false
+	}
+
+	msg := struct {
+		V1ID string `json:"@id"`
+		V2ID string `json:"id"`
+	}{}
+
+	if err := json.Unmarshal(envelope.Message, &msg); err != nil {
+		return "", false
+	}
+
+	id := msg.V1ID
+	if id == "" {
+		id = msg.V2ID
+	}
+
+	if id == "" {
+		return "", false
+	}
+
+	// The hex encoding of the sender's key contains no underscore, so distinct senders and ids never collide.
+	digest := sha256.Sum256([]byte(hex.EncodeToString(envelope.FromKey) + "_" + id))
+
+	return hex.EncodeToString(digest[:]), true
+}
+
+// provider is a transport provider with a deduplicated inbound message handler.
+type provider struct {
+	transport.Provider
+	handler transport.InboundMessageHandler
+}
+
+func (p *provider) InboundMessageHandler() transport.InboundMessageHandler {
+	return p.handler
+}
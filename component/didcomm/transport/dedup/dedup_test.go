@@ -0,0 +1,386 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dedup
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	mockpackager "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/packager"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicator(t *testing.T) {
+	t.Run("test deduplicator - missing storage provider", func(t *testing.T) {
+		_, err := New(nil)
+		require.EqualError(t, err, "storage provider is mandatory")
+	})
+
+	t.Run("test deduplicator - duplicates are dropped", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		var calls int
+
+		handler := d.Handler(func(*transport.Envelope) error {
+			calls++
+
+			return nil
+		})
+
+		for _, msg := range []string{`{"@id":"1"}`, `{"@id":"1"}`, `{"id":"2"}`, `{"id":"2"}`} {
+			require.NoError(t, handler(&transport.Envelope{Message: []byte(msg)}))
+		}
+
+		require.Equal(t, 2, calls)
+	})
+
+	t.Run("test deduplicator - failed messages are handled again", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		var calls int
+
+		handler := d.Handler(func(*transport.Envelope) error {
+			calls++
+			if calls == 1 {
+				return errors.New("handler failed")
+			}
+
+			return nil
+		})
+
+		envelope := &transport.Envelope{Message: []byte(`{"@id":"1"}`)}
+
+		require.EqualError(t, handler(envelope), "handler failed")
+		require.NoError(t, handler(envelope))
+		require.NoError(t, handler(envelope))
+		require.Equal(t, 2, calls)
+	})
+
+	t.Run("test deduplicator - messages without an id are not deduplicated", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		var calls int
+
+		handler := d.Handler(func(*transport.Envelope) error {
+			calls++
+
+			return nil
+		})
+
+		for _, msg := range []string{`{"type":"a"}`, `{"type":"a"}`, `not json`, `not json`} {
+			require.NoError(t, handler(&transport.Envelope{Message: []byte(msg)}))
+		}
+
+		require.NoError(t, handler(nil))
+		require.Equal(t, 5, calls)
+	})
+
+	t.Run("test deduplicator - ids are scoped by sender", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		var calls int
+
+		handler := d.Handler(func(*transport.Envelope) error {
+			calls++
+
+			return nil
+		})
+
+		msg := []byte(`{"@id":"1"}`)
+
+		require.NoError(t, handler(&transport.Envelope{Message: msg, FromKey: []byte("alice")}))
+		require.NoError(t, handler(&transport.Envelope{Message: msg, FromKey: []byte("mallory")}))
+		require.NoError(t, handler(&transport.Envelope{Message: msg, FromKey: []byte("alice")}))
+		require.Equal(t, 2, calls)
+	})
+
+	t.Run("test deduplicator - duplicate in flight", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		envelope := &transport.Envelope{Message: []byte(`{"@id":"1"}`)}
+
+		var handler transport.InboundMessageHandler
+
+		handler = d.Handler(func(*transport.Envelope) error {
+			require.ErrorIs(t, handler(envelope), ErrInFlight)
+
+			return nil
+		})
+
+		require.NoError(t, handler(envelope))
+	})
+
+	t.Run("test deduplicator - lookup does not block other messages", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		other := make(chan struct{})
+		key, _ := messageKey(&transport.Envelope{Message: []byte(`{"@id":"1"}`)})
+		store := &testStore{Store: d.store, get: func(k string) error {
+			if k != key {
+				return nil
+			}
+
+			select {
+			case <-other:
+				return nil
+			case <-time.After(time.Second):
+				return errors.New("lookup held the lock")
+			}
+		}}
+		d.store = store
+
+		handler := d.Handler(func(*transport.Envelope) error { return nil })
+
+		done := make(chan error, 1)
+
+		go func() { done <- handler(&transport.Envelope{Message: []byte(`{"@id":"1"}`)}) }()
+
+		require.Eventually(t, func() bool { return store.lookups(key) == 1 }, time.Second, time.Millisecond)
+		require.ErrorIs(t, handler(&transport.Envelope{Message: []byte(`{"@id":"1"}`)}), ErrInFlight)
+		require.NoError(t, handler(&transport.Envelope{Message: []byte(`{"@id":"2"}`)}))
+		close(other)
+
+		require.NoError(t, <-done)
+		require.Empty(t, d.inflight)
+	})
+
+	t.Run("test deduplicator - reservation released after lookup", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		lookupErr := errors.New("store unavailable")
+		d.store = &testStore{Store: d.store, get: func(string) error { return lookupErr }}
+
+		var calls int
+
+		handler := d.Handler(func(*transport.Envelope) error {
+			calls++
+
+			return nil
+		})
+
+		envelope := &transport.Envelope{Message: []byte(`{"@id":"1"}`)}
+
+		require.ErrorIs(t, handler(envelope), lookupErr)
+		require.Empty(t, d.inflight)
+
+		lookupErr = nil
+
+		require.NoError(t, handler(envelope))
+		require.NoError(t, handler(envelope))
+		require.Empty(t, d.inflight)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("test deduplicator - record failure is returned", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		recordErr := errors.New("store unavailable")
+		d.store = &testStore{Store: d.store, put: func(string) error { return recordErr }}
+
+		var calls int
+
+		handler := d.Handler(func(*transport.Envelope) error {
+			calls++
+
+			return nil
+		})
+
+		envelope := &transport.Envelope{Message: []byte(`{"@id":"1"}`)}
+
+		require.ErrorIs(t, handler(envelope), recordErr)
+		require.Empty(t, d.inflight)
+
+		recordErr = nil
+
+		require.NoError(t, handler(envelope))
+		require.NoError(t, handler(envelope))
+		require.Equal(t, 2, calls)
+	})
+
+	t.Run("test deduplicator - keys have a fixed length", func(t *testing.T) {
+		short, ok := messageKey(&transport.Envelope{Message: []byte(`{"@id":"1"}`)})
+		require.True(t, ok)
+
+		long, ok := messageKey(&transport.Envelope{
+			Message: []byte(`{"@id":"` + strings.Repeat("1", 300) + `"}`),
+			FromKey: []byte(strings.Repeat("k", 300)),
+		})
+		require.True(t, ok)
+
+		require.Len(t, short, 64)
+		require.Len(t, long, 64)
+	})
+
+	t.Run("test deduplicator - retention", func(t *testing.T) {
+		d, err := New(mem.NewProvider(), WithRetention(time.Hour))
+		require.NoError(t, err)
+
+		now := time.Now()
+		d.now = func() time.Time { return now }
+
+		var calls int
+
+		handler := d.Handler(func(*transport.Envelope) error {
+			calls++
+
+			return nil
+		})
+
+		envelope := &transport.Envelope{Message: []byte(`{"@id":"1"}`)}
+
+		require.NoError(t, handler(envelope))
+
+		now = now.Add(59 * time.Minute)
+		require.NoError(t, handler(envelope))
+		require.Equal(t, 1, calls)
+
+		now = now.Add(2 * time.Minute)
+		require.NoError(t, handler(envelope))
+		require.Equal(t, 2, calls)
+	})
+
+	t.Run("test deduplicator - prune", func(t *testing.T) {
+		provider := mem.NewProvider()
+
+		d, err := New(provider, WithRetention(time.Hour))
+		require.NoError(t, err)
+
+		now := time.Now()
+		d.now = func() time.Time { return now }
+
+		handler := d.Handler(func(*transport.Envelope) error { return nil })
+
+		require.NoError(t, handler(&transport.Envelope{Message: []byte(`{"@id":"old"}`)}))
+
+		now = now.Add(30 * time.Minute)
+		require.NoError(t, handler(&transport.Envelope{Message: []byte(`{"@id":"new"}`)}))
+
+		now = now.Add(45 * time.Minute)
+		require.NoError(t, d.Prune())
+
+		store, err := provider.OpenStore(defaultStoreName)
+		require.NoError(t, err)
+
+		oldKey, _ := messageKey(&transport.Envelope{Message: []byte(`{"@id":"old"}`)})
+		_, err = store.Get(oldKey)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		newKey, _ := messageKey(&transport.Envelope{Message: []byte(`{"@id":"new"}`)})
+		_, err = store.Get(newKey)
+		require.NoError(t, err)
+
+		require.NoError(t, d.Prune())
+	})
+
+	t.Run("test deduplicator - wrap provider", func(t *testing.T) {
+		d, err := New(mem.NewProvider())
+		require.NoError(t, err)
+
+		require.Nil(t, d.WrapProvider(nil))
+		require.Nil(t, d.Handler(nil))
+
+		var calls int
+
+		packager := &mockpackager.Packager{}
+
+		prov := d.WrapProvider(&testProvider{
+			packager: packager,
+			handler: func(*transport.Envelope) error {
+				calls++
+
+				return nil
+			},
+		})
+
+		require.Equal(t, packager, prov.Packager())
+		require.Equal(t, "aries-framework-id", prov.AriesFrameworkID())
+
+		envelope := &transport.Envelope{Message: []byte(`{"@id":"1"}`)}
+
+		require.NoError(t, prov.InboundMessageHandler()(envelope))
+		require.NoError(t, prov.InboundMessageHandler()(envelope))
+		require.Equal(t, 1, calls)
+	})
+}
+
+// testStore calls get before each lookup and put before each write, if set, and fails the lookup or write with
+// their error, if any.
+type testStore struct {
+	storage.Store
+	get   func(key string) error
+	put   func(key string) error
+	calls map[string]int
+	lock  sync.Mutex
+}
+
+func (s *testStore) Get(key string) ([]byte, error) {
+	s.lock.Lock()
+
+	if s.calls == nil {
+		s.calls = map[string]int{}
+	}
+
+	s.calls[key]++
+
+	s.lock.Unlock()
+
+	if s.get != nil {
+		if err := s.get(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Store.Get(key)
+}
+
+func (s *testStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	if s.put != nil {
+		if err := s.put(key); err != nil {
+			return err
+		}
+	}
+
+	return s.Store.Put(key, value, tags...)
+}
+
+func (s *testStore) lookups(key string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.calls[key]
+}
+
+type testProvider struct {
+	packager transport.Packager
+	handler  transport.InboundMessageHandler
+}
+
+func (p *testProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return p.handler
+}
+
+func (p *testProvider) Packager() transport.Packager {
+	return p.packager
+}
+
+func (p *testProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
@@ -0,0 +1,14 @@
+// Copyright Scoir Inc. All Rights Reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+module github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/dedup
+
+go 1.17
+
+require (
+	github.com/hyperledger/aries-framework-go v0.1.8
+	github.com/hyperledger/aries-framework-go/component/storageutil v0.0.0-20220330140627-07042d78580c
+	github.com/hyperledger/aries-framework-go/spi v0.0.0-20220330140627-07042d78580c
+	github.com/pkg/errors v0.9.1
+	github.com/stretchr/testify v1.7.0
+)
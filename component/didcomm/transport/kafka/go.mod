@@ -0,0 +1,14 @@
+// Copyright Scoir Inc. All Rights Reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+module github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/kafka
+
+go 1.17
+
+require (
+	github.com/cenkalti/backoff v2.2.1+incompatible
+	github.com/hyperledger/aries-framework-go v0.1.8
+	github.com/pkg/errors v0.9.1
+	github.com/segmentio/kafka-go v0.4.28
+	github.com/stretchr/testify v1.7.0
+)
@@ -0,0 +1,326 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package kafka implements inbound and outbound DIDComm transports for Aries (aries-framework-go).
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// ErrorHeader is the message header holding the error that caused a message to be dead-lettered.
+	ErrorHeader = "x-error"
+	// OriginalTopicHeader is the message header holding the topic a dead-lettered message was consumed from.
+	OriginalTopicHeader = "x-original-topic"
+
+	defaultGroupID              = "aries-framework-go"
+	defaultRetryInitialInterval = 500 * time.Millisecond
+	defaultRetryMaxInterval     = 30 * time.Second
+	defaultMaxRetries           = 3
+	defaultCommitTimeout        = 10 * time.Second
+)
+
+// messageReader is the part of kafka.Reader used by the inbound transport.
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Inbound kafka type.
+// Messages are consumed from a topic as a member of a consumer group, so several agents sharing the group
+// divide the topic's partitions between them. The offset of a message is committed only once the inbound message
+// handler has succeeded or the message was given up after the retry limit, so messages that were not handled are
+// redelivered after a restart or a rebalance.
+type Inbound struct {
+	brokers              []string
+	topic                string
+	groupID              string
+	externalAddr         string
+	tlsConfig            *tls.Config
+	retryInitialInterval time.Duration
+	retryMaxInterval     time.Duration
+	maxRetries           int
+	deadLetterTopic      string
+	reader               messageReader
+	deadLetterWriter     messageWriter
+	packager             transport.Packager
+	msgHandler           transport.InboundMessageHandler
+	logger               *log.Log
+	lock                 sync.Mutex
+	cancel               context.CancelFunc
+	served               chan struct{}
+}
+
+// NewInbound creates a new Kafka inbound transport instance that consumes from the given topic.
+func NewInbound(brokers []string, topic, externalAddr string, opts ...InboundOpt) (*Inbound, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("broker addresses are mandatory")
+	}
+
+	if topic == "" {
+		return nil, errors.New("topic is mandatory")
+	}
+
+	if externalAddr == "" {
+		return nil, errors.New("external address is mandatory")
+	}
+
+	i := &Inbound{
+		brokers:              brokers,
+		topic:                topic,
+		groupID:              defaultGroupID,
+		externalAddr:         externalAddr,
+		retryInitialInterval: defaultRetryInitialInterval,
+		retryMaxInterval:     defaultRetryMaxInterval,
+		maxRetries:           defaultMaxRetries,
+		logger:               log.New("aries-framework/transport/kafka"),
+		served:               make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i, nil
+}
+
+// Start the Kafka message loop.
+func (i *Inbound) Start(prov transport.Provider) error {
+	if prov == nil || prov.InboundMessageHandler() == nil {
+		return errors.New("creation of inbound handler failed")
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.cancel != nil {
+		return errors.New("transport is already started")
+	}
+
+	i.packager = prov.Packager()
+	i.msgHandler = prov.InboundMessageHandler()
+
+	if i.reader == nil {
+		i.reader = kafka.NewReader(i.readerConfig())
+	}
+
+	if i.deadLetterTopic != "" && i.deadLetterWriter == nil {
+		i.deadLetterWriter = i.newDeadLetterWriter()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	i.cancel = cancel
+
+	go i.listenAndServe(ctx)
+
+	return nil
+}
+
+func (i *Inbound) readerConfig() kafka.ReaderConfig {
+	config := kafka.ReaderConfig{
+		Brokers: i.brokers,
+		GroupID: i.groupID,
+		Topic:   i.topic,
+		// Offsets are committed explicitly once a message has been handled.
+		CommitInterval: 0,
+		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
+			i.logger.Warnf(msg, args...)
+		}),
+	}
+
+	if i.tlsConfig != nil {
+		config.Dialer = &kafka.Dialer{
+			Timeout:   kafka.DefaultDialer.Timeout,
+			DualStack: true,
+			TLS:       i.tlsConfig,
+		}
+	}
+
+	return config
+}
+
+func (i *Inbound) newDeadLetterWriter() *kafka.Writer {
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(i.brokers...),
+		Topic:        i.deadLetterTopic,
+		RequiredAcks: kafka.RequireAll,
+		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
+			i.logger.Warnf(msg, args...)
+		}),
+	}
+
+	if i.tlsConfig != nil {
+		w.Transport = &kafka.Transport{TLS: i.tlsConfig}
+	}
+
+	return w
+}
+
+// listenAndServe handles messages one at a time, in partition order, until the transport is stopped.
+func (i *Inbound) listenAndServe(ctx context.Context) {
+	defer close(i.served)
+
+	for {
+		m, err := i.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
+
+			i.logger.Warnf("failed to fetch message from topic %s: %v", i.topic, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(i.retryInitialInterval):
+			}
+
+			continue
+		}
+
+		if !i.handle(ctx, m) {
+			return
+		}
+	}
+}
+
+// handle unpacks a message and hands it to the inbound message handler, retrying with exponential backoff up to
+// the retry limit, and then commits the message's offset. Messages whose handler kept failing, and messages that
+// cannot be unpacked, are dead-lettered before being committed, so that they do not hold up the partition.
+// It returns false if the transport was stopped before the message was handled or dead-lettered, in which case
+// its offset is not committed.
+func (i *Inbound) handle(ctx context.Context, m kafka.Message) bool {
+	unpackMsg, err := i.packager.UnpackMessage(m.Value)
+	if err != nil {
+		i.logger.Errorf("failed to unpack msg from topic %s partition %d offset %d: %v",
+			m.Topic, m.Partition, m.Offset, err)
+
+		return i.deadLetter(ctx, m, fmt.Errorf("failed to unpack msg: %w", err))
+	}
+
+	err = backoff.RetryNotify(func() error {
+		return i.msgHandler(unpackMsg)
+	}, backoff.WithContext(backoff.WithMaxRetries(i.backOff(), uint64(i.maxRetries)), ctx),
+		func(err error, next time.Duration) {
+			i.logger.Errorf("incoming msg processing failed, retrying in %s: %v", next, err)
+		})
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		i.logger.Errorf("incoming msg processing failed %d times, giving up on topic %s partition %d offset %d: %v",
+			i.maxRetries+1, m.Topic, m.Partition, m.Offset, err)
+
+		return i.deadLetter(ctx, m, err)
+	}
+
+	i.commit(m)
+
+	return true
+}
+
+// deadLetter publishes a message that will never be handled to the dead-letter topic, if any, with the error
+// recorded in its headers, and commits its offset. Publishing is retried until it succeeds, since the message
+// would be lost otherwise. It returns false if the transport was stopped before the message was dead-lettered.
+func (i *Inbound) deadLetter(ctx context.Context, m kafka.Message, cause error) bool {
+	if i.deadLetterWriter == nil {
+		i.logger.Warnf("skipping msg at topic %s partition %d offset %d", m.Topic, m.Partition, m.Offset)
+		i.commit(m)
+
+		return true
+	}
+
+	headers := append([]kafka.Header{}, m.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: ErrorHeader, Value: []byte(cause.Error())},
+		kafka.Header{Key: OriginalTopicHeader, Value: []byte(m.Topic)})
+
+	err := backoff.RetryNotify(func() error {
+		return i.deadLetterWriter.WriteMessages(ctx, kafka.Message{Key: m.Key, Value: m.Value, Headers: headers})
+	}, backoff.WithContext(i.backOff(), ctx), func(err error, next time.Duration) {
+		i.logger.Errorf("failed to dead-letter msg, retrying in %s: %v", next, err)
+	})
+	if err != nil {
+		return false
+	}
+
+	i.commit(m)
+
+	return true
+}
+
+// backOff returns the exponential backoff between attempts, without a time limit.
+func (i *Inbound) backOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = i.retryInitialInterval
+	b.MaxInterval = i.retryMaxInterval
+	b.MaxElapsedTime = 0
+	b.Reset()
+
+	return b
+}
+
+func (i *Inbound) commit(m kafka.Message) {
+	// The commit must go through even if the transport is being stopped, since the message has been handled.
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCommitTimeout)
+	defer cancel()
+
+	if err := i.reader.CommitMessages(ctx, m); err != nil {
+		i.logger.Errorf("failed to commit offset %d of topic %s partition %d: %v",
+			m.Offset, m.Topic, m.Partition, err)
+	}
+}
+
+// Stop the Kafka message loop. It waits for the message being handled, if any, and leaves the consumer group.
+// Calling Stop on a transport that was never started or was already stopped does nothing.
+func (i *Inbound) Stop() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.cancel == nil || i.reader == nil {
+		return nil
+	}
+
+	i.cancel()
+	<-i.served
+
+	reader := i.reader
+	i.reader = nil
+
+	if err := reader.Close(); err != nil {
+		return fmt.Errorf("reader shutdown failed: %w", err)
+	}
+
+	if i.deadLetterWriter != nil {
+		writer := i.deadLetterWriter
+		i.deadLetterWriter = nil
+
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("dead-letter writer shutdown failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Endpoint provides the Kafka connection details.
+func (i *Inbound) Endpoint() string {
+	return i.externalAddr
+}
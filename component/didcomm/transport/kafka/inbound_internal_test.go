@@ -0,0 +1,401 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	mockpackager "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/packager"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInboundCommit(t *testing.T) {
+	t.Run("test inbound transport - commit after handler succeeds", func(t *testing.T) {
+		reader := newTestReader(kafka.Message{Topic: "topic", Offset: 1, Value: []byte("data")})
+
+		inbound := newTestInbound(t, reader)
+
+		handled := make(chan struct{}, 1)
+
+		require.NoError(t, inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				require.Empty(t, reader.committedOffsets())
+
+				handled <- struct{}{}
+
+				return nil
+			},
+		}))
+
+		waitFor(t, handled)
+
+		require.Eventually(t, func() bool {
+			return len(reader.committedOffsets()) == 1
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, []int64{1}, reader.committedOffsets())
+
+		require.NoError(t, inbound.Stop())
+		require.True(t, reader.closed)
+		require.NoError(t, inbound.Stop())
+	})
+
+	t.Run("test inbound transport - retry until handler succeeds", func(t *testing.T) {
+		reader := newTestReader(kafka.Message{Topic: "topic", Offset: 7, Value: []byte("data")})
+
+		inbound := newTestInbound(t, reader)
+
+		var attempts int
+
+		handled := make(chan struct{}, 1)
+
+		require.NoError(t, inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				attempts++
+				if attempts < 3 {
+					require.Empty(t, reader.committedOffsets())
+
+					return errors.New("handler failed")
+				}
+
+				handled <- struct{}{}
+
+				return nil
+			},
+		}))
+
+		waitFor(t, handled)
+
+		require.Eventually(t, func() bool {
+			return len(reader.committedOffsets()) == 1
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, []int64{7}, reader.committedOffsets())
+		require.NoError(t, inbound.Stop())
+	})
+
+	t.Run("test inbound transport - no commit when stopped before handler succeeds", func(t *testing.T) {
+		reader := newTestReader(kafka.Message{Topic: "topic", Offset: 1, Value: []byte("data")})
+
+		inbound := newTestInbound(t, reader, WithRetryBackOff(time.Minute, time.Minute))
+
+		failed := make(chan struct{}, 1)
+
+		require.NoError(t, inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				select {
+				case failed <- struct{}{}:
+				default:
+				}
+
+				return errors.New("handler failed")
+			},
+		}))
+
+		waitFor(t, failed)
+
+		require.NoError(t, inbound.Stop())
+		require.Empty(t, reader.committedOffsets())
+	})
+
+	t.Run("test inbound transport - commit unpackable message", func(t *testing.T) {
+		reader := newTestReader(kafka.Message{Topic: "topic", Offset: 3, Value: []byte("data")})
+
+		inbound := newTestInbound(t, reader)
+
+		require.NoError(t, inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackErr: errors.New("unpack failed")},
+			handler: func(*transport.Envelope) error {
+				require.Fail(t, "handler called for an unpackable message")
+
+				return nil
+			},
+		}))
+
+		require.Eventually(t, func() bool {
+			return len(reader.committedOffsets()) == 1
+		}, time.Second, 10*time.Millisecond)
+		require.NoError(t, inbound.Stop())
+	})
+}
+
+func TestInboundDeadLetter(t *testing.T) {
+	t.Run("test inbound transport - skip message whose handler always fails", func(t *testing.T) {
+		reader := newTestReader(
+			kafka.Message{Topic: "topic", Offset: 5, Value: []byte("poison")},
+			kafka.Message{Topic: "topic", Offset: 6, Value: []byte("data")},
+		)
+
+		inbound := newTestInbound(t, reader, WithMaxRetries(2))
+
+		var attempts int32
+
+		require.NoError(t, inbound.Start(&testProvider{
+			packager: &testPackager{},
+			handler: func(envelope *transport.Envelope) error {
+				if string(envelope.Message) == "poison" {
+					atomic.AddInt32(&attempts, 1)
+
+					return errors.New("handler failed")
+				}
+
+				return nil
+			},
+		}))
+
+		require.Eventually(t, func() bool {
+			return len(reader.committedOffsets()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, []int64{5, 6}, reader.committedOffsets())
+		require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+		require.NoError(t, inbound.Stop())
+	})
+
+	t.Run("test inbound transport - dead-letter message whose handler always fails", func(t *testing.T) {
+		reader := newTestReader(kafka.Message{
+			Topic:   "topic",
+			Offset:  2,
+			Key:     []byte("key"),
+			Value:   []byte("data"),
+			Headers: []kafka.Header{{Key: ContentTypeHeader, Value: []byte(commContentType)}},
+		})
+		writer := &testWriter{failures: 1}
+
+		inbound := newTestInbound(t, reader, WithMaxRetries(0), WithDeadLetterTopic("dead-letters"))
+		inbound.deadLetterWriter = writer
+
+		require.NoError(t, inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				return errors.New("handler failed")
+			},
+		}))
+
+		require.Eventually(t, func() bool {
+			return len(reader.committedOffsets()) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		msgs := writer.written()
+		require.Len(t, msgs, 1)
+		require.Equal(t, []byte("key"), msgs[0].Key)
+		require.Equal(t, []byte("data"), msgs[0].Value)
+		require.Equal(t, []kafka.Header{
+			{Key: ContentTypeHeader, Value: []byte(commContentType)},
+			{Key: ErrorHeader, Value: []byte("handler failed")},
+			{Key: OriginalTopicHeader, Value: []byte("topic")},
+		}, msgs[0].Headers)
+
+		require.NoError(t, inbound.Stop())
+		require.True(t, writer.closed)
+	})
+
+	t.Run("test inbound transport - dead-letter unpackable message", func(t *testing.T) {
+		reader := newTestReader(kafka.Message{Topic: "topic", Offset: 4, Value: []byte("data")})
+		writer := &testWriter{}
+
+		inbound := newTestInbound(t, reader, WithDeadLetterTopic("dead-letters"))
+		inbound.deadLetterWriter = writer
+
+		require.NoError(t, inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackErr: errors.New("unpack failed")},
+			handler: func(*transport.Envelope) error {
+				require.Fail(t, "handler called for an unpackable message")
+
+				return nil
+			},
+		}))
+
+		require.Eventually(t, func() bool {
+			return len(reader.committedOffsets()) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		msgs := writer.written()
+		require.Len(t, msgs, 1)
+		require.Equal(t, []kafka.Header{
+			{Key: ErrorHeader, Value: []byte("failed to unpack msg: unpack failed")},
+			{Key: OriginalTopicHeader, Value: []byte("topic")},
+		}, msgs[0].Headers)
+		require.NoError(t, inbound.Stop())
+	})
+
+	t.Run("test inbound transport - no commit when stopped before message is dead-lettered", func(t *testing.T) {
+		reader := newTestReader(kafka.Message{Topic: "topic", Offset: 1, Value: []byte("data")})
+		writer := &testWriter{failures: 1000}
+
+		inbound := newTestInbound(t, reader, WithMaxRetries(0), WithDeadLetterTopic("dead-letters"))
+		inbound.deadLetterWriter = writer
+
+		require.NoError(t, inbound.Start(&testProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				return errors.New("handler failed")
+			},
+		}))
+
+		require.Eventually(t, func() bool {
+			return writer.attempts() > 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, inbound.Stop())
+		require.Empty(t, reader.committedOffsets())
+		require.Empty(t, writer.written())
+	})
+}
+
+func newTestInbound(t *testing.T, reader *testReader, opts ...InboundOpt) *Inbound {
+	t.Helper()
+
+	opts = append([]InboundOpt{WithRetryBackOff(time.Millisecond, 10*time.Millisecond)}, opts...)
+
+	inbound, err := NewInbound([]string{"127.0.0.1:9092"}, "topic", "http://example.com", opts...)
+	require.NoError(t, err)
+
+	inbound.reader = reader
+
+	return inbound
+}
+
+func waitFor(t *testing.T, c <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for message")
+	}
+}
+
+// testReader delivers the given messages once and then blocks until the context is done.
+type testReader struct {
+	msgs      chan kafka.Message
+	committed []int64
+	closed    bool
+	lock      sync.Mutex
+}
+
+func newTestReader(msgs ...kafka.Message) *testReader {
+	r := &testReader{msgs: make(chan kafka.Message, len(msgs))}
+
+	for _, m := range msgs {
+		r.msgs <- m
+	}
+
+	return r
+}
+
+func (r *testReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-r.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *testReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, m := range msgs {
+		r.committed = append(r.committed, m.Offset)
+	}
+
+	return nil
+}
+
+func (r *testReader) committedOffsets() []int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]int64(nil), r.committed...)
+}
+
+func (r *testReader) Close() error {
+	r.closed = true
+
+	return nil
+}
+
+// testWriter records the messages written to it, after failing the given number of writes.
+type testWriter struct {
+	failures int
+	tries    int
+	msgs     []kafka.Message
+	closed   bool
+	lock     sync.Mutex
+}
+
+func (w *testWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.tries++
+
+	if w.tries <= w.failures {
+		return errors.New("write failed")
+	}
+
+	w.msgs = append(w.msgs, msgs...)
+
+	return nil
+}
+
+func (w *testWriter) written() []kafka.Message {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return append([]kafka.Message(nil), w.msgs...)
+}
+
+func (w *testWriter) attempts() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.tries
+}
+
+func (w *testWriter) Close() error {
+	w.closed = true
+
+	return nil
+}
+
+// testPackager unpacks a message into an envelope holding the packed message as is.
+type testPackager struct{}
+
+func (p *testPackager) PackMessage(envelope *transport.Envelope) ([]byte, error) {
+	return envelope.Message, nil
+}
+
+func (p *testPackager) UnpackMessage(encMessage []byte) (*transport.Envelope, error) {
+	return &transport.Envelope{Message: encMessage}, nil
+}
+
+type testProvider struct {
+	packager transport.Packager
+	handler  transport.InboundMessageHandler
+}
+
+func (p *testProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return p.handler
+}
+
+func (p *testProvider) Packager() transport.Packager {
+	return p.packager
+}
+
+func (p *testProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
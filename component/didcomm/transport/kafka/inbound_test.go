@@ -0,0 +1,77 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kafka_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/kafka"
+)
+
+const (
+	externalAddr = "http://example.com"
+	kafkaBroker  = "127.0.0.1:9092"
+)
+
+func TestInboundTransport(t *testing.T) {
+	t.Run("test inbound transport - endpoint", func(t *testing.T) {
+		inbound, err := NewInbound([]string{kafkaBroker}, "topic", externalAddr, WithGroupID("group"))
+		require.NoError(t, err)
+		require.Equal(t, externalAddr, inbound.Endpoint())
+	})
+
+	t.Run("test inbound transport - missing arguments", func(t *testing.T) {
+		_, err := NewInbound(nil, "topic", externalAddr)
+		require.EqualError(t, err, "broker addresses are mandatory")
+
+		_, err = NewInbound([]string{kafkaBroker}, "", externalAddr)
+		require.EqualError(t, err, "topic is mandatory")
+
+		_, err = NewInbound([]string{kafkaBroker}, "topic", "")
+		require.EqualError(t, err, "external address is mandatory")
+	})
+
+	t.Run("test inbound transport - nil context", func(t *testing.T) {
+		inbound, err := NewInbound([]string{kafkaBroker}, "topic", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(nil)
+		require.Error(t, err)
+	})
+
+	t.Run("test inbound transport - stop before start", func(t *testing.T) {
+		inbound, err := NewInbound([]string{kafkaBroker}, "topic", externalAddr)
+		require.NoError(t, err)
+		require.NoError(t, inbound.Stop())
+	})
+}
+
+type mockProvider struct {
+	packagerValue transport.Packager
+}
+
+func (p *mockProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return func(envelope *transport.Envelope) error {
+		if envelope != nil && string(envelope.Message) == "invalid-data" {
+			return errors.New("error")
+		}
+
+		return nil
+	}
+}
+
+func (p *mockProvider) Packager() transport.Packager {
+	return p.packagerValue
+}
+
+func (p *mockProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
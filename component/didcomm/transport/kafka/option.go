@@ -0,0 +1,71 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kafka
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// InboundOpt is an option for the Kafka inbound transport.
+type InboundOpt func(i *Inbound)
+
+// WithGroupID sets the consumer group the inbound transport joins. Agents that share a consumer group divide the
+// partitions of the topic between them, so each message is handled by only one of them.
+// Defaults to aries-framework-go.
+func WithGroupID(groupID string) InboundOpt {
+	return func(i *Inbound) {
+		i.groupID = groupID
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to the Kafka brokers.
+func WithTLSConfig(config *tls.Config) InboundOpt {
+	return func(i *Inbound) {
+		i.tlsConfig = config
+	}
+}
+
+// WithRetryBackOff sets the exponential backoff used to retry a message whose inbound message handler failed.
+// The delay between attempts starts at initial and doubles up to max. Messages are handled one at a time, so
+// later messages wait while a message is retried (see WithMaxRetries).
+// Defaults to an initial delay of 500ms and a maximum delay of 30s.
+func WithRetryBackOff(initial, max time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.retryInitialInterval = initial
+		i.retryMaxInterval = max
+	}
+}
+
+// WithMaxRetries sets how many times a message whose inbound message handler failed is retried before it is
+// given up: it is then dead-lettered (see WithDeadLetterTopic) and its offset is committed. Defaults to 3.
+func WithMaxRetries(maxRetries int) InboundOpt {
+	return func(i *Inbound) {
+		if maxRetries >= 0 {
+			i.maxRetries = maxRetries
+		}
+	}
+}
+
+// WithDeadLetterTopic sets the topic, on the same brokers, that messages whose handler kept failing and messages
+// that cannot be unpacked are published to, with the error recorded in the ErrorHeader header and the topic they
+// were consumed from in the OriginalTopicHeader header. If not set, such messages are logged and skipped.
+func WithDeadLetterTopic(topic string) InboundOpt {
+	return func(i *Inbound) {
+		i.deadLetterTopic = topic
+	}
+}
+
+// OutboundOpt is an option for the Kafka outbound transport.
+type OutboundOpt func(o *Outbound)
+
+// WithOutboundTLSConfig sets the TLS configuration used to connect to Kafka brokers.
+func WithOutboundTLSConfig(config *tls.Config) OutboundOpt {
+	return func(o *Outbound) {
+		o.tlsConfig = config
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	kafkaScheme = "kafka://"
+
+	// ContentTypeHeader is the message header holding the content type of a published message.
+	ContentTypeHeader = "content-type"
+
+	commContentType = "application/didcomm-envelope-enc"
+)
+
+// messageWriter is the part of kafka.Writer used by the outbound transport.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Outbound kafka type.
+// Service endpoints are Kafka URIs naming one or more brokers and the recipient's topic, for example
+// kafka://broker1:9092,broker2:9092/agent. Writers are kept open and shared per set of brokers.
+type Outbound struct {
+	tlsConfig *tls.Config
+	writers   map[string]messageWriter
+	lock      sync.Mutex
+	logger    *log.Log
+}
+
+// NewOutbound creates a new Kafka outbound transport instance.
+func NewOutbound(opts ...OutboundOpt) (*Outbound, error) {
+	o := &Outbound{
+		writers: map[string]messageWriter{},
+		logger:  log.New("aries-framework/transport/kafka"),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o, nil
+}
+
+// Start starts the outbound transport.
+func (o *Outbound) Start(prov transport.Provider) error {
+	return nil
+}
+
+// Send publishes the packed message to the topic named in the destination's service endpoint.
+// It returns once all in-sync replicas of the topic's partition have acknowledged the message.
+func (o *Outbound) Send(data []byte, destination *service.Destination) (string, error) {
+	if destination == nil {
+		return "", errors.New("destination is mandatory")
+	}
+
+	brokers, topic, err := parseEndpoint(destination.ServiceEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	err = o.writer(brokers).WriteMessages(context.Background(), kafka.Message{
+		Topic:   topic,
+		Value:   data,
+		Headers: []kafka.Header{{Key: ContentTypeHeader, Value: []byte(commContentType)}},
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to publish to topic %s", topic)
+	}
+
+	return "", nil
+}
+
+// AcceptRecipient checks if there is a connection for the list of recipient keys. Always returns false.
+func (o *Outbound) AcceptRecipient([]string) bool {
+	return false
+}
+
+// Accept checks whether the given URL is a Kafka endpoint.
+func (o *Outbound) Accept(endpoint string) bool {
+	return strings.HasPrefix(endpoint, kafkaScheme)
+}
+
+// Stop closes all open writers.
+func (o *Outbound) Stop() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for brokers, w := range o.writers {
+		delete(o.writers, brokers)
+
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("writer shutdown failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (o *Outbound) writer(brokers []string) messageWriter {
+	key := strings.Join(brokers, ",")
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if w, ok := o.writers[key]; ok {
+		return w
+	}
+
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.LeastBytes{},
+		RequiredAcks: kafka.RequireAll,
+		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
+			o.logger.Warnf(msg, args...)
+		}),
+	}
+
+	if o.tlsConfig != nil {
+		w.Transport = &kafka.Transport{TLS: o.tlsConfig}
+	}
+
+	o.writers[key] = w
+
+	return w
+}
+
+// parseEndpoint splits a service endpoint into the broker addresses and the topic name.
+func parseEndpoint(endpoint string) ([]string, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "invalid endpoint %s", endpoint)
+	}
+
+	if u.Scheme != "kafka" {
+		return nil, "", fmt.Errorf("unsupported endpoint scheme: %s", endpoint)
+	}
+
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("endpoint %s does not name a broker", endpoint)
+	}
+
+	topic := strings.TrimPrefix(u.Path, "/")
+	if topic == "" || strings.Contains(topic, "/") {
+		return nil, "", fmt.Errorf("endpoint %s does not name a topic", endpoint)
+	}
+
+	return strings.Split(u.Host, ","), topic, nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kafka_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/kafka"
+)
+
+func TestOutboundTransport(t *testing.T) {
+	t.Run("test outbound transport - accept", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+		require.NoError(t, outbound.Start(&mockProvider{}))
+
+		require.True(t, outbound.Accept("kafka://broker:9092/topic"))
+		require.True(t, outbound.Accept("kafka://broker1:9092,broker2:9092/topic"))
+		require.False(t, outbound.Accept("amqp://example.com:5672?queue=queue"))
+		require.False(t, outbound.Accept("http://example.com"))
+		require.False(t, outbound.AcceptRecipient([]string{"key"}))
+	})
+
+	t.Run("test outbound transport - invalid destination", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+
+		_, err = outbound.Send([]byte("data"), nil)
+		require.EqualError(t, err, "destination is mandatory")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "http://example.com/topic"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported endpoint scheme")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "kafka:///topic"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a broker")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "kafka://" + kafkaBroker})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a topic")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "kafka://" + kafkaBroker + "/a/b"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a topic")
+
+		require.NoError(t, outbound.Stop())
+	})
+}
@@ -0,0 +1,89 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// qos is the MQTT quality of service used to subscribe and publish: messages are delivered at least once.
+	qos = 1
+)
+
+// ProtocolVersion is the version of the MQTT protocol spoken with the MQTT broker.
+type ProtocolVersion int
+
+const (
+	// MQTT311 is MQTT 3.1.1. This is the default.
+	MQTT311 ProtocolVersion = 4
+	// MQTT5 is MQTT 5, which is required for return routing over response topics.
+	MQTT5 ProtocolVersion = 5
+)
+
+// message is an MQTT message. The response topic and correlation data are only carried by MQTT 5.
+type message struct {
+	topic           string
+	payload         []byte
+	responseTopic   string
+	correlationData []byte
+}
+
+// client is an MQTT client of either protocol version.
+type client interface {
+	// connect connects to the MQTT broker and, if handler is set, subscribes to topic. The subscription is
+	// restored whenever the client reconnects.
+	connect(ctx context.Context, topic string, handler func(message)) error
+	publish(ctx context.Context, m message) error
+	disconnect(ctx context.Context) error
+}
+
+// connConfig holds the settings used to connect to an MQTT broker.
+type connConfig struct {
+	protocolVersion ProtocolVersion
+	clientID        string
+	tlsConfig       *tls.Config
+}
+
+// newClient creates a client for the MQTT broker at brokerURL, which may carry a username and password.
+func (c *connConfig) newClient(brokerURL string, onError func(error)) (client, error) {
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid MQTT URL %s", brokerURL)
+	}
+
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		u.Scheme = "tcp"
+	case "mqtts", "ssl", "tls":
+		u.Scheme = "ssl"
+	default:
+		return nil, fmt.Errorf("unsupported MQTT URL scheme: %s", brokerURL)
+	}
+
+	// The MQTT broker keeps the session, and so the messages published while the client is offline, only for
+	// a client ID that is reused across connections.
+	clientID, cleanSession := c.clientID, false
+	if clientID == "" {
+		clientID, cleanSession = "aries-mqtt-"+uuid.New().String(), true
+	}
+
+	switch c.protocolVersion {
+	case 0, MQTT311:
+		return newClient311(u, clientID, cleanSession, c.tlsConfig, onError), nil
+	case MQTT5:
+		return newClient5(u, clientID, cleanSession, c.tlsConfig, onError), nil
+	default:
+		return nil, fmt.Errorf("unsupported MQTT protocol version %d", c.protocolVersion)
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright Scoir Inc. All Rights Reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+module github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/mqtt
+
+go 1.17
+
+require (
+	github.com/cenkalti/backoff v2.2.1+incompatible
+	github.com/eclipse/paho.golang v0.10.0
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/google/uuid v1.1.2
+	github.com/hyperledger/aries-framework-go v0.1.8
+	github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/returnroute v0.0.0
+	github.com/ory/dockertest/v3 v3.6.2
+	github.com/pkg/errors v0.9.1
+	github.com/stretchr/testify v1.7.0
+)
+
+replace github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/returnroute => ../returnroute
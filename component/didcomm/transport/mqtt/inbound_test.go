@@ -0,0 +1,328 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mqtt_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/packager"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/packer"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/packer/legacy/authcrypt"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	mockpackager "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/packager"
+	mockprovider "github.com/hyperledger/aries-framework-go/pkg/mock/provider"
+	mockstorage "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	dctest "github.com/ory/dockertest/v3"
+	dc "github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/mqtt"
+)
+
+const (
+	externalAddr         = "http://example.com"
+	dockerMosquittoImage = "eclipse-mosquitto"
+	dockerMosquittoTag   = "2.0.14"
+	mqttAddr             = "mqtt://127.0.0.1:1884"
+	messageTimeout       = 5 * time.Second
+)
+
+func TestMain(m *testing.M) {
+	code := 1
+
+	defer func() { os.Exit(code) }()
+
+	pool, err := dctest.NewPool("")
+	if err != nil {
+		panic(fmt.Sprintf("pool: %v", err))
+	}
+
+	mosquittoResource, err := pool.RunWithOptions(&dctest.RunOptions{
+		Repository: dockerMosquittoImage, Tag: dockerMosquittoTag,
+		Cmd: []string{"mosquitto", "-c", "/mosquitto-no-auth.conf"},
+		PortBindings: map[dc.Port][]dc.PortBinding{
+			"1883/tcp": {{HostIP: "", HostPort: "1884"}},
+		},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("run with options: %v", err))
+	}
+
+	defer func() {
+		if err = pool.Purge(mosquittoResource); err != nil {
+			panic(fmt.Sprintf("purge: %v", err))
+		}
+	}()
+
+	if err := checkMosquitto(); err != nil {
+		panic(fmt.Sprintf("check Mosquitto: %v", err))
+	}
+
+	code = m.Run()
+}
+
+func checkMosquitto() error {
+	const retries = 60
+
+	return backoff.Retry(func() error {
+		conn, err := net.Dial("tcp", "127.0.0.1:1884")
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}, backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), retries))
+}
+
+func TestInboundTransport(t *testing.T) {
+	t.Run("test inbound transport - endpoint", func(t *testing.T) {
+		inbound, err := NewInbound(mqttAddr, "agents/alice", externalAddr)
+		require.NoError(t, err)
+		require.Equal(t, externalAddr, inbound.Endpoint())
+	})
+
+	t.Run("test inbound transport - missing arguments", func(t *testing.T) {
+		_, err := NewInbound("", "agents/alice", externalAddr)
+		require.EqualError(t, err, "MQTT URL is mandatory")
+
+		_, err = NewInbound(mqttAddr, "", externalAddr)
+		require.EqualError(t, err, "topic is mandatory")
+
+		_, err = NewInbound(mqttAddr, "agents/alice", "")
+		require.EqualError(t, err, "external address is mandatory")
+	})
+
+	t.Run("test inbound transport - nil context", func(t *testing.T) {
+		inbound, err := NewInbound(mqttAddr, "agents/alice", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(nil)
+		require.Error(t, err)
+	})
+
+	t.Run("test inbound transport - invalid configuration", func(t *testing.T) {
+		inbound, err := NewInbound("http://127.0.0.1:1884", "agents/alice", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: &mockpackager.Packager{}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported MQTT URL scheme")
+
+		inbound, err = NewInbound(mqttAddr, "agents/alice", externalAddr, WithProtocolVersion(3))
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: &mockpackager.Packager{}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported MQTT protocol version 3")
+		require.NoError(t, inbound.Stop())
+	})
+
+	for _, version := range []ProtocolVersion{MQTT311, MQTT5} {
+		version := version
+
+		t.Run(fmt.Sprintf("test inbound transport - receive with protocol version %d", version), func(t *testing.T) {
+			topic := fmt.Sprintf("agents/receive-%d", version)
+
+			received := make(chan string, 1)
+
+			inbound, err := NewInbound(mqttAddr, topic, externalAddr, WithProtocolVersion(version))
+			require.NoError(t, err)
+
+			err = inbound.Start(&handlerProvider{
+				packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+				handler: func(envelope *transport.Envelope) error {
+					received <- string(envelope.Message)
+
+					return nil
+				},
+			})
+			require.NoError(t, err)
+
+			defer func() { require.NoError(t, inbound.Stop()) }()
+
+			outbound, err := NewOutbound(WithOutboundProtocolVersion(version))
+			require.NoError(t, err)
+
+			defer func() { require.NoError(t, outbound.Stop()) }()
+
+			_, err = outbound.Send([]byte("packed"), &service.Destination{ServiceEndpoint: mqttAddr + "/" + topic})
+			require.NoError(t, err)
+
+			select {
+			case msg := <-received:
+				require.Equal(t, "data", msg)
+			case <-time.After(messageTimeout):
+				require.Fail(t, "timed out waiting for message")
+			}
+		})
+	}
+}
+
+func TestReturnRoute(t *testing.T) {
+	legacyPackager, senderKey, recipientKey := newLegacyPackager(t)
+
+	returnRoute, err := json.Marshal(&decorator.Transport{
+		ReturnRoute: &decorator.ReturnRoute{Value: decorator.TransportReturnRouteAll},
+	})
+	require.NoError(t, err)
+
+	request, err := legacyPackager.PackMessage(&transport.Envelope{
+		MediaTypeProfile: transport.MediaTypeRFC0019EncryptedEnvelope,
+		Message:          returnRoute,
+		FromKey:          []byte(senderKey),
+		ToKeys:           []string{recipientKey},
+	})
+	require.NoError(t, err)
+
+	// The recipient replies over the response topic of the sender's message.
+	recipient, err := NewInbound(mqttAddr, "agents/recipient", externalAddr,
+		WithProtocolVersion(MQTT5), WithReturnRouteTTL(time.Minute))
+	require.NoError(t, err)
+
+	recipientOutbound, err := NewOutbound(WithOutboundProtocolVersion(MQTT5), WithReturnRoute(recipient))
+	require.NoError(t, err)
+
+	err = recipient.Start(&handlerProvider{
+		packager: legacyPackager,
+		handler: func(*transport.Envelope) error {
+			require.True(t, recipientOutbound.AcceptRecipient([]string{"other", senderKey}))
+			require.False(t, recipientOutbound.AcceptRecipient([]string{"other"}))
+
+			_, sendErr := recipientOutbound.Send([]byte("reply"), &service.Destination{
+				RecipientKeys:   []string{senderKey},
+				ServiceEndpoint: "http://unreachable.example.com",
+			})
+
+			return sendErr
+		},
+	})
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, recipient.Stop()) }()
+
+	replies := make(chan struct{}, 1)
+
+	senderInbound, err := NewInbound(mqttAddr, "agents/sender", externalAddr, WithProtocolVersion(MQTT5))
+	require.NoError(t, err)
+
+	err = senderInbound.Start(&handlerProvider{
+		packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("reply")}},
+		handler: func(*transport.Envelope) error {
+			replies <- struct{}{}
+
+			return nil
+		},
+	})
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, senderInbound.Stop()) }()
+
+	senderOutbound, err := NewOutbound(WithOutboundProtocolVersion(MQTT5), WithReturnRoute(senderInbound))
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, senderOutbound.Stop()) }()
+
+	_, err = senderOutbound.Send(request, &service.Destination{
+		ServiceEndpoint: mqttAddr + "/agents/recipient",
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-replies:
+	case <-time.After(messageTimeout):
+		require.Fail(t, "timed out waiting for reply")
+	}
+}
+
+// newLegacyPackager creates a packager with the legacy authcrypt packer used by DIDComm v1 agents, and the did:keys
+// of a sender and a recipient whose private keys it holds.
+func newLegacyPackager(t *testing.T) (transport.Packager, string, string) {
+	t.Helper()
+
+	keyManager, err := localkms.New("local-lock://test/primary/", &mockprovider.Provider{
+		StorageProviderValue: mockstorage.NewMockStoreProvider(),
+		SecretLockValue:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	crypto, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	legacyPacker := authcrypt.New(&mockprovider.Provider{KMSValue: keyManager, CryptoValue: crypto})
+
+	p, err := packager.New(&mockprovider.Provider{
+		PackerList:      []packer.Packer{legacyPacker},
+		PackerValue:     legacyPacker,
+		VDRegistryValue: &mockvdr.MockVDRegistry{},
+	})
+	require.NoError(t, err)
+
+	_, senderKey, err := keyManager.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+
+	_, recipientKey, err := keyManager.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+
+	senderDIDKey, _ := fingerprint.CreateDIDKey(senderKey)
+	recipientDIDKey, _ := fingerprint.CreateDIDKey(recipientKey)
+
+	return p, senderDIDKey, recipientDIDKey
+}
+
+type mockProvider struct {
+	packagerValue transport.Packager
+}
+
+func (p *mockProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return func(envelope *transport.Envelope) error {
+		if envelope != nil && string(envelope.Message) == "invalid-data" {
+			return errors.New("error")
+		}
+
+		return nil
+	}
+}
+
+func (p *mockProvider) Packager() transport.Packager {
+	return p.packagerValue
+}
+
+func (p *mockProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
+
+type handlerProvider struct {
+	packager transport.Packager
+	handler  transport.InboundMessageHandler
+}
+
+func (p *handlerProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return p.handler
+}
+
+func (p *handlerProvider) Packager() transport.Packager {
+	return p.packager
+}
+
+func (p *handlerProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
@@ -0,0 +1,134 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+const (
+	keepAlive         = 30 * time.Second
+	disconnectQuiesce = 250
+)
+
+// client311 is an MQTT 3.1.1 client.
+type client311 struct {
+	client  paho.Client
+	onError func(error)
+	lock    sync.Mutex
+	topic   string
+	handler func(message)
+}
+
+func newClient311(u *url.URL, clientID string, cleanSession bool, tlsConfig *tls.Config,
+	onError func(error)) *client311 {
+	c := &client311{onError: onError}
+
+	opts := paho.NewClientOptions().
+		SetClientID(clientID).
+		SetCleanSession(cleanSession).
+		SetKeepAlive(keepAlive).
+		SetAutoReconnect(true).
+		// Messages are handed to the subscription handler one at a time, in order, and acknowledged once it
+		// returns.
+		SetOrderMatters(true).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			onError(errors.Wrap(err, "connection lost"))
+		})
+
+	if u.User != nil {
+		password, _ := u.User.Password()
+		opts.SetUsername(u.User.Username()).SetPassword(password)
+	}
+
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	broker := *u
+	broker.User = nil
+	opts.AddBroker(broker.String())
+
+	c.client = paho.NewClient(opts)
+
+	return c
+}
+
+func (c *client311) connect(ctx context.Context, topic string, handler func(message)) error {
+	if err := wait(ctx, c.client.Connect()); err != nil {
+		return errors.Wrap(err, "unable to connect to MQTT broker")
+	}
+
+	if handler == nil {
+		return nil
+	}
+
+	if err := wait(ctx, c.client.Subscribe(topic, qos, c.receive(handler))); err != nil {
+		c.client.Disconnect(0)
+
+		return errors.Wrapf(err, "unable to subscribe to topic %s", topic)
+	}
+
+	c.lock.Lock()
+	c.topic, c.handler = topic, handler
+	c.lock.Unlock()
+
+	return nil
+}
+
+// onConnect restores the subscription after the client reconnected.
+func (c *client311) onConnect(client paho.Client) {
+	c.lock.Lock()
+	topic, handler := c.topic, c.handler
+	c.lock.Unlock()
+
+	if handler == nil {
+		return
+	}
+
+	// Tokens must not be waited for in the connect handler.
+	go func() {
+		token := client.Subscribe(topic, qos, c.receive(handler))
+		if token.Wait() && token.Error() != nil {
+			c.onError(errors.Wrapf(token.Error(), "unable to resubscribe to topic %s", topic))
+		}
+	}()
+}
+
+func (c *client311) receive(handler func(message)) paho.MessageHandler {
+	return func(_ paho.Client, m paho.Message) {
+		handler(message{topic: m.Topic(), payload: m.Payload()})
+	}
+}
+
+func (c *client311) publish(ctx context.Context, m message) error {
+	return wait(ctx, c.client.Publish(m.topic, qos, false, m.payload))
+}
+
+func (c *client311) disconnect(context.Context) error {
+	c.client.Disconnect(disconnectQuiesce)
+
+	return nil
+}
+
+// wait waits for an MQTT 3.1.1 operation to complete.
+func wait(ctx context.Context, token paho.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net/url"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/pkg/errors"
+)
+
+// client5 is an MQTT 5 client.
+type client5 struct {
+	config     autopaho.ClientConfig
+	cm         *autopaho.ConnectionManager
+	onError    func(error)
+	topic      string
+	handler    func(message)
+	subscribed chan error
+}
+
+func newClient5(u *url.URL, clientID string, cleanSession bool, tlsConfig *tls.Config,
+	onError func(error)) *client5 {
+	c := &client5{onError: onError, subscribed: make(chan error, 1)}
+
+	broker := *u
+	broker.User = nil
+
+	c.config = autopaho.ClientConfig{
+		BrokerUrls:     []*url.URL{&broker},
+		TlsCfg:         tlsConfig,
+		KeepAlive:      uint16(keepAlive.Seconds()),
+		OnConnectionUp: c.onConnectionUp,
+		OnConnectError: func(err error) {
+			onError(errors.Wrap(err, "unable to connect to MQTT broker"))
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: clientID,
+			Router:   paho.NewSingleHandlerRouter(c.receive),
+			OnClientError: func(err error) {
+				onError(errors.Wrap(err, "connection lost"))
+			},
+		},
+	}
+
+	if u.User != nil {
+		password, _ := u.User.Password()
+		c.config.SetUsernamePassword(u.User.Username(), []byte(password))
+	}
+
+	c.config.SetConnectPacketConfigurator(func(p *paho.Connect) *paho.Connect {
+		p.CleanStart = cleanSession
+
+		if !cleanSession {
+			expiry := uint32(math.MaxUint32)
+			p.Properties = &paho.ConnectProperties{SessionExpiryInterval: &expiry}
+		}
+
+		return p
+	})
+
+	return c
+}
+
+func (c *client5) connect(ctx context.Context, topic string, handler func(message)) error {
+	c.topic, c.handler = topic, handler
+
+	cm, err := autopaho.NewConnection(context.Background(), c.config)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect to MQTT broker")
+	}
+
+	c.cm = cm
+
+	if err = cm.AwaitConnection(ctx); err != nil {
+		c.abort()
+
+		return errors.Wrap(err, "unable to connect to MQTT broker")
+	}
+
+	if handler == nil {
+		return nil
+	}
+
+	select {
+	case err = <-c.subscribed:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		c.abort()
+
+		return errors.Wrapf(err, "unable to subscribe to topic %s", topic)
+	}
+
+	return nil
+}
+
+// abort stops the connection manager after connect failed.
+func (c *client5) abort() {
+	if err := c.cm.Disconnect(context.Background()); err != nil {
+		c.onError(errors.Wrap(err, "disconnect failed"))
+	}
+}
+
+// onConnectionUp subscribes to the topic whenever the client has connected, so that the subscription is
+// restored after a reconnect. The outcome of the first subscription is reported to connect.
+func (c *client5) onConnectionUp(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+	if c.handler == nil {
+		return
+	}
+
+	_, err := cm.Subscribe(context.Background(), &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{c.topic: {QoS: qos}},
+	})
+
+	select {
+	case c.subscribed <- err:
+	default:
+		if err != nil {
+			c.onError(errors.Wrapf(err, "unable to resubscribe to topic %s", c.topic))
+		}
+	}
+}
+
+func (c *client5) receive(p *paho.Publish) {
+	if c.handler == nil {
+		return
+	}
+
+	m := message{topic: p.Topic, payload: p.Payload}
+
+	if p.Properties != nil {
+		m.responseTopic = p.Properties.ResponseTopic
+		m.correlationData = p.Properties.CorrelationData
+	}
+
+	c.handler(m)
+}
+
+func (c *client5) publish(ctx context.Context, m message) error {
+	_, err := c.cm.Publish(ctx, &paho.Publish{
+		QoS:     qos,
+		Topic:   m.topic,
+		Payload: m.payload,
+		Properties: &paho.PublishProperties{
+			ContentType:     commContentType,
+			ResponseTopic:   m.responseTopic,
+			CorrelationData: m.correlationData,
+		},
+	})
+
+	return err
+}
+
+func (c *client5) disconnect(ctx context.Context) error {
+	if err := c.cm.Disconnect(ctx); err != nil {
+		return err
+	}
+
+	select {
+	case <-c.cm.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
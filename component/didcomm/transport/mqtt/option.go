@@ -0,0 +1,78 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mqtt
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// InboundOpt is an option for the MQTT inbound transport.
+type InboundOpt func(i *Inbound)
+
+// WithClientID sets the MQTT client ID. The MQTT broker keeps the session of a client ID across connections, so
+// messages published while the agent is offline are delivered once it reconnects. If not set, a unique client ID
+// is generated and the session is discarded on disconnect.
+func WithClientID(clientID string) InboundOpt {
+	return func(i *Inbound) {
+		i.clientID = clientID
+	}
+}
+
+// WithProtocolVersion sets the MQTT protocol version. Defaults to MQTT311.
+// Return routing (see WithReturnRoute) requires MQTT5.
+func WithProtocolVersion(version ProtocolVersion) InboundOpt {
+	return func(i *Inbound) {
+		i.protocolVersion = version
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to the MQTT broker over an mqtts URL, for example
+// to set a client certificate or the root CAs of a private PKI.
+func WithTLSConfig(config *tls.Config) InboundOpt {
+	return func(i *Inbound) {
+		i.tlsConfig = config
+	}
+}
+
+// WithReturnRouteTTL sets how long the response topic of a sender that asked for return routing is kept after its
+// last message, see WithReturnRoute. Defaults to 10 minutes.
+func WithReturnRouteTTL(ttl time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.returnRouteTTL = ttl
+	}
+}
+
+// OutboundOpt is an option for the MQTT outbound transport.
+type OutboundOpt func(o *Outbound)
+
+// WithOutboundProtocolVersion sets the MQTT protocol version used to connect to MQTT brokers.
+// Defaults to MQTT311.
+func WithOutboundProtocolVersion(version ProtocolVersion) OutboundOpt {
+	return func(o *Outbound) {
+		o.protocolVersion = version
+	}
+}
+
+// WithOutboundTLSConfig sets the TLS configuration used to connect to MQTT brokers over mqtts URLs.
+func WithOutboundTLSConfig(config *tls.Config) OutboundOpt {
+	return func(o *Outbound) {
+		o.tlsConfig = config
+	}
+}
+
+// WithReturnRoute links the outbound transport with an inbound transport to support the DIDComm ~transport
+// return_route decorator over MQTT 5. Messages sent to a sender that asked the inbound transport for return
+// routing are published to the response topic of its message, with its correlation data, rather than to the
+// sender's endpoint, until the route expires (see WithReturnRouteTTL) or the sender asks for a return_route of
+// none. With MQTT5, messages published by the outbound transport carry the inbound transport's topic as their
+// response topic, so recipients can reply to them in the same way.
+func WithReturnRoute(inbound *Inbound) OutboundOpt {
+	return func(o *Outbound) {
+		o.inbound = inbound
+	}
+}
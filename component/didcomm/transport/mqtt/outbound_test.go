@@ -0,0 +1,108 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mqtt_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/mqtt"
+)
+
+func TestOutboundTransport(t *testing.T) {
+	t.Run("test outbound transport - accept", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+		require.NoError(t, outbound.Start(&mockProvider{}))
+
+		require.True(t, outbound.Accept("mqtt://example.com:1883/agents/alice"))
+		require.True(t, outbound.Accept("mqtts://example.com:8883/agents/alice"))
+		require.False(t, outbound.Accept("amqp://example.com:5672?queue=queue"))
+		require.False(t, outbound.Accept("http://example.com"))
+		require.False(t, outbound.AcceptRecipient([]string{"key"}))
+	})
+
+	t.Run("test outbound transport - invalid destination", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+
+		_, err = outbound.Send([]byte("data"), nil)
+		require.EqualError(t, err, "destination is mandatory")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "http://example.com/topic"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported endpoint scheme")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: mqttAddr})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a topic")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: mqttAddr + "/agents/%23"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a topic")
+
+		require.NoError(t, outbound.Stop())
+	})
+
+	t.Run("test outbound transport - unresponsive broker does not block others", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, listener.Close()) }()
+
+		// The listener accepts connections but never answers, so connecting to it only fails on timeout.
+		go func() {
+			var conns []net.Conn
+
+			defer func() {
+				for _, conn := range conns {
+					_ = conn.Close()
+				}
+			}()
+
+			for {
+				conn, errAccept := listener.Accept()
+				if errAccept != nil {
+					return
+				}
+
+				conns = append(conns, conn)
+			}
+		}()
+
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, outbound.Stop()) }()
+
+		go func() {
+			_, _ = outbound.Send([]byte("data"), &service.Destination{
+				ServiceEndpoint: "mqtt://" + listener.Addr().String() + "/agents/unresponsive",
+			})
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+
+		sent := make(chan error, 1)
+
+		go func() {
+			_, errSend := outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: mqttAddr + "/agents/alice"})
+			sent <- errSend
+		}()
+
+		select {
+		case err = <-sent:
+			require.NoError(t, err)
+		case <-time.After(messageTimeout):
+			require.Fail(t, "send was blocked by a connection to another broker")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mqtt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/pkg/errors"
+
+	"github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/returnroute"
+)
+
+// replyRoute is where replies to a sender that asked for return routing are published.
+type replyRoute struct {
+	responseTopic   string
+	correlationData []byte
+}
+
+// addReturnRoute records the MQTT 5 response topic of a message that carries a ~transport return_route
+// decorator, so that messages sent back to its sender are published to that topic instead of the sender's
+// endpoint. A return_route of none removes the sender's route.
+func (i *Inbound) addReturnRoute(m message, envelope *transport.Envelope, trans *decorator.Transport) {
+	if returnroute.Requested(trans) && m.responseTopic == "" {
+		i.logger.Warnf("return route requested but the message has no response topic")
+
+		return
+	}
+
+	route := replyRoute{responseTopic: m.responseTopic, correlationData: m.correlationData}
+
+	if !i.replyRoutes.Update(envelope, trans, route) {
+		i.logger.Warnf("return route requested but the sender key of the message is unknown")
+	}
+}
+
+// returnRoute finds the reply route of the first recipient key that has one.
+func (i *Inbound) returnRoute(keys []string) (replyRoute, bool) {
+	route, ok := i.replyRoutes.Get(keys)
+	if !ok {
+		return replyRoute{}, false
+	}
+
+	r, ok := route.(replyRoute)
+
+	return r, ok
+}
+
+// reply publishes a packed message to the response topic of one of the recipient keys, with the correlation
+// data of the message that asked for return routing.
+func (i *Inbound) reply(data []byte, keys []string) error {
+	route, ok := i.returnRoute(keys)
+	if !ok {
+		return fmt.Errorf("no return route for recipient keys %v", keys)
+	}
+
+	i.lock.Lock()
+	c := i.client
+	i.lock.Unlock()
+
+	if c == nil {
+		return errors.New("inbound transport is not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultConnectTimeout)
+	defer cancel()
+
+	err := c.publish(ctx, message{
+		topic:           route.responseTopic,
+		payload:         data,
+		correlationData: route.correlationData,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to publish to response topic %s", route.responseTopic)
+	}
+
+	return nil
+}
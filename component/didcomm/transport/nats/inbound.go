@@ -0,0 +1,373 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package nats implements inbound and outbound DIDComm transports over NATS JetStream for Aries
+// (aries-framework-go).
+package nats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ErrorHeader is the header of a dead-lettered message holding the reason it was given up.
+	ErrorHeader = "X-Error"
+	// OriginalSubjectHeader is the header of a dead-lettered message holding the subject it was consumed from.
+	OriginalSubjectHeader = "X-Original-Subject"
+
+	defaultDurable           = "aries-framework-go"
+	defaultBatchSize         = 10
+	defaultFetchWait         = 5 * time.Second
+	defaultAckWait           = 30 * time.Second
+	defaultMaxDeliver        = 5
+	defaultRetryInitialDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay     = 30 * time.Second
+	fetchRetryDelay          = 500 * time.Millisecond
+)
+
+// Inbound nats type.
+// Messages are consumed from a subject through a durable JetStream pull consumer with explicit acknowledgement.
+// A message is acknowledged once the inbound message handler succeeds, so messages that were not handled,
+// including those in flight when the agent stopped, are redelivered by the NATS server. Agents that share the
+// durable name share the consumer, so each message is handled by only one of them. A message whose handler fails
+// is redelivered after an exponential backoff, until it has been delivered the maximum number of times (see
+// WithMaxDeliver).
+type Inbound struct {
+	natsURL           string
+	subject           string
+	externalAddr      string
+	natsOpts          []nats.Option
+	stream            string
+	durable           string
+	batchSize         int
+	ackWait           time.Duration
+	maxDeliver        int
+	retryInitialDelay time.Duration
+	retryMaxDelay     time.Duration
+	deadLetterSubject string
+	conn              *nats.Conn
+	js                nats.JetStreamContext
+	sub               *nats.Subscription
+	packager          transport.Packager
+	msgHandler        transport.InboundMessageHandler
+	logger            *log.Log
+	lock              sync.Mutex
+	cancel            context.CancelFunc
+	served            chan struct{}
+}
+
+// NewInbound creates a new NATS JetStream inbound transport instance that consumes from the given subject.
+func NewInbound(natsURL, subject, externalAddr string, opts ...InboundOpt) (*Inbound, error) {
+	if natsURL == "" {
+		return nil, errors.New("NATS URL is mandatory")
+	}
+
+	if subject == "" {
+		return nil, errors.New("subject is mandatory")
+	}
+
+	if externalAddr == "" {
+		return nil, errors.New("external address is mandatory")
+	}
+
+	i := &Inbound{
+		natsURL:           natsURL,
+		subject:           subject,
+		externalAddr:      externalAddr,
+		durable:           defaultDurable,
+		batchSize:         defaultBatchSize,
+		ackWait:           defaultAckWait,
+		maxDeliver:        defaultMaxDeliver,
+		retryInitialDelay: defaultRetryInitialDelay,
+		retryMaxDelay:     defaultRetryMaxDelay,
+		logger:            log.New("aries-framework/transport/nats"),
+		served:            make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i, nil
+}
+
+// Start the NATS message loop.
+// The NATS client reconnects in the background if the connection to the NATS server is lost after Start returns.
+func (i *Inbound) Start(prov transport.Provider) error {
+	if prov == nil || prov.InboundMessageHandler() == nil {
+		return errors.New("creation of inbound handler failed")
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.cancel != nil {
+		return errors.New("transport is already started")
+	}
+
+	i.packager = prov.Packager()
+	i.msgHandler = prov.InboundMessageHandler()
+
+	if err := i.connect(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	i.cancel = cancel
+
+	go i.listenAndServe(ctx)
+
+	return nil
+}
+
+// connect connects to the NATS server, declares the stream if one is configured and creates or binds the
+// durable pull consumer.
+func (i *Inbound) connect() error {
+	opts := append([]nats.Option{nats.MaxReconnects(-1)}, i.natsOpts...)
+
+	conn, err := nats.Connect(i.natsURL, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "unable to connect to NATS server at %s", i.natsURL)
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+
+		return errors.Wrap(err, "unable to get JetStream context")
+	}
+
+	subOpts := []nats.SubOpt{
+		nats.AckExplicit(),
+		nats.AckWait(i.ackWait),
+		nats.MaxDeliver(i.maxDeliver),
+	}
+
+	if i.stream != "" {
+		if err = i.declareStream(js); err != nil {
+			conn.Close()
+
+			return err
+		}
+
+		subOpts = append(subOpts, nats.BindStream(i.stream))
+	}
+
+	sub, err := js.PullSubscribe(i.subject, i.durable, subOpts...)
+	if err != nil {
+		conn.Close()
+
+		return errors.Wrapf(err, "unable to subscribe to subject %s", i.subject)
+	}
+
+	i.conn = conn
+	i.js = js
+	i.sub = sub
+
+	return nil
+}
+
+// declareStream creates the configured stream, capturing the inbound subject, unless it already exists.
+func (i *Inbound) declareStream(js nats.JetStreamContext) error {
+	_, err := js.StreamInfo(i.stream)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return errors.Wrapf(err, "unable to look up stream %s", i.stream)
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     i.stream,
+		Subjects: []string{i.subject},
+		Storage:  nats.FileStorage,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to declare stream %s", i.stream)
+	}
+
+	return nil
+}
+
+// listenAndServe fetches and handles batches of messages until the transport is stopped.
+func (i *Inbound) listenAndServe(ctx context.Context) {
+	defer close(i.served)
+
+	for {
+		msgs, err := i.fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			i.logger.Warnf("failed to fetch messages from subject %s: %v", i.subject, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fetchRetryDelay):
+			}
+
+			continue
+		}
+
+		for _, m := range msgs {
+			// Messages left unacknowledged are redelivered by the NATS server once the ack wait expires.
+			if ctx.Err() != nil {
+				return
+			}
+
+			i.handle(m)
+		}
+	}
+}
+
+// fetch waits for the next batch of messages. It returns no messages and no error if none arrived in time.
+func (i *Inbound) fetch(ctx context.Context) ([]*nats.Msg, error) {
+	// Fetch requires a context with a deadline.
+	fetchCtx, cancel := context.WithTimeout(ctx, defaultFetchWait)
+	defer cancel()
+
+	msgs, err := i.sub.Fetch(i.batchSize, nats.Context(fetchCtx))
+	if err != nil && ctx.Err() == nil &&
+		(errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout)) {
+		return nil, nil
+	}
+
+	return msgs, err
+}
+
+// handle unpacks a message and hands it to the inbound message handler. The message is acknowledged if the
+// handler succeeds and negatively acknowledged, for redelivery after a backoff, if it fails. Messages whose
+// handler failed on their last delivery, and messages that cannot be unpacked, will never be handled, so they are
+// dead-lettered rather than redelivered.
+func (i *Inbound) handle(m *nats.Msg) {
+	unpackMsg, err := i.packager.UnpackMessage(m.Data)
+	if err != nil {
+		i.logger.Errorf("failed to unpack msg: %v", err)
+		i.deadLetter(m, fmt.Errorf("failed to unpack msg: %w", err))
+
+		return
+	}
+
+	err = i.msgHandler(unpackMsg)
+	if err != nil {
+		delivered := deliveries(m)
+
+		if i.maxDeliver > 0 && delivered >= i.maxDeliver {
+			i.logger.Errorf("incoming msg processing failed %d times, giving up: %v", delivered, err)
+			i.deadLetter(m, err)
+
+			return
+		}
+
+		i.logger.Errorf("incoming msg processing failed: %v", err)
+
+		if err = m.NakWithDelay(i.retryDelay(delivered)); err != nil {
+			i.logger.Errorf("failed to nak msg: %v", err)
+		}
+
+		return
+	}
+
+	if err = m.Ack(); err != nil {
+		i.logger.Errorf("failed to ack msg: %v", err)
+	}
+}
+
+// deadLetter publishes a message that will never be handled to the dead-letter subject, if any, with the error
+// recorded in its headers, and terminates it so that it is not redelivered. If the message cannot be published to
+// the dead-letter subject, it is negatively acknowledged instead, and dead-lettered again on its next delivery.
+func (i *Inbound) deadLetter(m *nats.Msg, cause error) {
+	if i.deadLetterSubject == "" {
+		i.logger.Warnf("skipping msg of subject %s", m.Subject)
+	} else {
+		msg := nats.NewMsg(i.deadLetterSubject)
+		msg.Data = m.Data
+
+		for key, values := range m.Header {
+			msg.Header[key] = values
+		}
+
+		msg.Header.Set(ErrorHeader, cause.Error())
+		msg.Header.Set(OriginalSubjectHeader, m.Subject)
+
+		if _, err := i.js.PublishMsg(msg); err != nil {
+			i.logger.Errorf("failed to dead-letter msg: %v", err)
+
+			if err = m.NakWithDelay(i.retryMaxDelay); err != nil {
+				i.logger.Errorf("failed to nak msg: %v", err)
+			}
+
+			return
+		}
+	}
+
+	if err := m.Term(); err != nil {
+		i.logger.Errorf("failed to terminate msg: %v", err)
+	}
+}
+
+// retryDelay returns how long the NATS server waits before redelivering a message whose handler failed after the
+// given number of deliveries: the initial delay, doubled after each delivery, up to the maximum delay.
+func (i *Inbound) retryDelay(delivered int) time.Duration {
+	delay := i.retryInitialDelay
+
+	for n := 1; n < delivered && delay < i.retryMaxDelay; n++ {
+		delay *= 2
+	}
+
+	if delay > i.retryMaxDelay {
+		delay = i.retryMaxDelay
+	}
+
+	return delay
+}
+
+// deliveries returns how many times a message has been delivered, counting this delivery.
+func deliveries(m *nats.Msg) int {
+	meta, err := m.Metadata()
+	if err != nil {
+		return 1
+	}
+
+	return int(meta.NumDelivered)
+}
+
+// Stop the NATS message loop. It waits for the message being handled, if any, and closes the connection.
+// The durable consumer is kept, so messages published while the agent is stopped are delivered once it is
+// started again. Calling Stop on a transport that was never started or was already stopped does nothing.
+func (i *Inbound) Stop() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.cancel == nil || i.conn == nil {
+		return nil
+	}
+
+	i.cancel()
+	<-i.served
+
+	// Closing the connection rather than unsubscribing keeps the durable consumer on the NATS server.
+	i.conn.Close()
+	i.conn = nil
+
+	return nil
+}
+
+// Endpoint provides the NATS connection details.
+func (i *Inbound) Endpoint() string {
+	return i.externalAddr
+}
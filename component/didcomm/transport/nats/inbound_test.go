@@ -0,0 +1,305 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package nats_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	mockpackager "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/packager"
+	"github.com/nats-io/nats.go"
+	dctest "github.com/ory/dockertest/v3"
+	dc "github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/nats"
+)
+
+const (
+	externalAddr     = "http://example.com"
+	dockerNATSImage  = "nats"
+	dockerNATSTag    = "2.7.4"
+	natsAddr         = "nats://127.0.0.1:4223"
+	messageTimeout   = 5 * time.Second
+	redeliveryPeriod = time.Second
+)
+
+func TestMain(m *testing.M) {
+	code := 1
+
+	defer func() { os.Exit(code) }()
+
+	pool, err := dctest.NewPool("")
+	if err != nil {
+		panic(fmt.Sprintf("pool: %v", err))
+	}
+
+	natsResource, err := pool.RunWithOptions(&dctest.RunOptions{
+		Repository: dockerNATSImage, Tag: dockerNATSTag, Cmd: []string{"-js"},
+		PortBindings: map[dc.Port][]dc.PortBinding{
+			"4222/tcp": {{HostIP: "", HostPort: "4223"}},
+		},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("run with options: %v", err))
+	}
+
+	defer func() {
+		if err = pool.Purge(natsResource); err != nil {
+			panic(fmt.Sprintf("purge: %v", err))
+		}
+	}()
+
+	if err := checkNATS(); err != nil {
+		panic(fmt.Sprintf("check NATS: %v", err))
+	}
+
+	code = m.Run()
+}
+
+func checkNATS() error {
+	const retries = 60
+
+	return backoff.Retry(func() error {
+		conn, err := nats.Connect(natsAddr)
+		if err != nil {
+			return err
+		}
+
+		conn.Close()
+
+		return nil
+	}, backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), retries))
+}
+
+func TestInboundTransport(t *testing.T) {
+	t.Run("test inbound transport - endpoint", func(t *testing.T) {
+		inbound, err := NewInbound(natsAddr, "agent.inbox", externalAddr)
+		require.NoError(t, err)
+		require.Equal(t, externalAddr, inbound.Endpoint())
+	})
+
+	t.Run("test inbound transport - missing arguments", func(t *testing.T) {
+		_, err := NewInbound("", "agent.inbox", externalAddr)
+		require.EqualError(t, err, "NATS URL is mandatory")
+
+		_, err = NewInbound(natsAddr, "", externalAddr)
+		require.EqualError(t, err, "subject is mandatory")
+
+		_, err = NewInbound(natsAddr, "agent.inbox", "")
+		require.EqualError(t, err, "external address is mandatory")
+	})
+
+	t.Run("test inbound transport - nil context", func(t *testing.T) {
+		inbound, err := NewInbound(natsAddr, "agent.inbox", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(nil)
+		require.Error(t, err)
+	})
+
+	t.Run("test inbound transport - invalid server", func(t *testing.T) {
+		inbound, err := NewInbound("nats://127.0.0.1:5555", "agent.inbox", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: &mockpackager.Packager{}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to connect to NATS server")
+		require.NoError(t, inbound.Stop())
+	})
+
+	t.Run("test inbound transport - subject without stream", func(t *testing.T) {
+		inbound, err := NewInbound(natsAddr, "no.stream", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: &mockpackager.Packager{}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to subscribe to subject no.stream")
+	})
+
+	t.Run("test inbound transport - receive and redeliver", func(t *testing.T) {
+		subject := "agent.redeliver"
+
+		received := make(chan string, 2)
+		failed := false
+
+		inbound, err := NewInbound(natsAddr, subject, externalAddr, WithStream("redeliver"),
+			WithDurable("redeliver"), WithAckWait(redeliveryPeriod))
+		require.NoError(t, err)
+
+		err = inbound.Start(&handlerProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(envelope *transport.Envelope) error {
+				if !failed {
+					failed = true
+
+					return errors.New("handler failed")
+				}
+
+				received <- string(envelope.Message)
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		send(t, natsAddr+"/"+subject)
+
+		select {
+		case msg := <-received:
+			require.Equal(t, "data", msg)
+		case <-time.After(messageTimeout):
+			require.Fail(t, "timed out waiting for message")
+		}
+	})
+
+	t.Run("test inbound transport - dead-letter after max deliveries", func(t *testing.T) {
+		subject := "agent.deadletter"
+		deadLetterSubject := "agent.dead"
+
+		conn, err := nats.Connect(natsAddr)
+		require.NoError(t, err)
+
+		defer conn.Close()
+
+		js, err := conn.JetStream()
+		require.NoError(t, err)
+
+		_, err = js.AddStream(&nats.StreamConfig{Name: "dead", Subjects: []string{deadLetterSubject}})
+		require.NoError(t, err)
+
+		dead, err := js.SubscribeSync(deadLetterSubject)
+		require.NoError(t, err)
+
+		attempts := make(chan struct{}, 3)
+
+		inbound, err := NewInbound(natsAddr, subject, externalAddr, WithStream("deadletter"),
+			WithDurable("deadletter"), WithMaxDeliver(2), WithRetryBackOff(10*time.Millisecond, time.Second),
+			WithDeadLetterSubject(deadLetterSubject))
+		require.NoError(t, err)
+
+		err = inbound.Start(&handlerProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				attempts <- struct{}{}
+
+				return errors.New("handler failed")
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		send(t, natsAddr+"/"+subject)
+
+		msg, err := dead.NextMsg(messageTimeout)
+		require.NoError(t, err)
+		require.Equal(t, "packed", string(msg.Data))
+		require.Equal(t, "handler failed", msg.Header.Get(ErrorHeader))
+		require.Equal(t, subject, msg.Header.Get(OriginalSubjectHeader))
+
+		time.Sleep(redeliveryPeriod)
+		require.Len(t, attempts, 2)
+	})
+
+	t.Run("test inbound transport - messages survive restart", func(t *testing.T) {
+		subject := "agent.restart"
+		opts := []InboundOpt{WithStream("restart"), WithDurable("restart")}
+
+		inbound, err := NewInbound(natsAddr, subject, externalAddr, opts...)
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: &mockpackager.Packager{}})
+		require.NoError(t, err)
+		require.NoError(t, inbound.Stop())
+		require.NoError(t, inbound.Stop())
+
+		send(t, natsAddr+"/"+subject)
+
+		received := make(chan struct{}, 1)
+
+		inbound, err = NewInbound(natsAddr, subject, externalAddr, opts...)
+		require.NoError(t, err)
+
+		err = inbound.Start(&handlerProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				received <- struct{}{}
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		select {
+		case <-received:
+		case <-time.After(messageTimeout):
+			require.Fail(t, "timed out waiting for message")
+		}
+	})
+}
+
+func send(t *testing.T, endpoint string) {
+	t.Helper()
+
+	outbound, err := NewOutbound()
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, outbound.Stop()) }()
+
+	_, err = outbound.Send([]byte("packed"), &service.Destination{ServiceEndpoint: endpoint})
+	require.NoError(t, err)
+}
+
+type mockProvider struct {
+	packagerValue transport.Packager
+}
+
+func (p *mockProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return func(envelope *transport.Envelope) error {
+		if envelope != nil && string(envelope.Message) == "invalid-data" {
+			return errors.New("error")
+		}
+
+		return nil
+	}
+}
+
+func (p *mockProvider) Packager() transport.Packager {
+	return p.packagerValue
+}
+
+func (p *mockProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
+
+type handlerProvider struct {
+	packager transport.Packager
+	handler  transport.InboundMessageHandler
+}
+
+func (p *handlerProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return p.handler
+}
+
+func (p *handlerProvider) Packager() transport.Packager {
+	return p.packager
+}
+
+func (p *handlerProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
@@ -0,0 +1,95 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package nats
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// InboundOpt is an option for the NATS inbound transport.
+type InboundOpt func(i *Inbound)
+
+// WithNATSOptions sets options of the connection to the NATS server, for example nats.UserCredentials or
+// nats.Secure for TLS.
+func WithNATSOptions(opts ...nats.Option) InboundOpt {
+	return func(i *Inbound) {
+		i.natsOpts = append(i.natsOpts, opts...)
+	}
+}
+
+// WithStream sets the JetStream stream the inbound subject belongs to. The stream is created with file storage
+// if it does not exist. If not set, the stream must already exist and is looked up by subject.
+func WithStream(name string) InboundOpt {
+	return func(i *Inbound) {
+		i.stream = name
+	}
+}
+
+// WithDurable sets the name of the durable pull consumer. Defaults to aries-framework-go.
+func WithDurable(name string) InboundOpt {
+	return func(i *Inbound) {
+		i.durable = name
+	}
+}
+
+// WithBatchSize sets the maximum number of messages fetched from the consumer at once. Defaults to 10.
+func WithBatchSize(size int) InboundOpt {
+	return func(i *Inbound) {
+		if size > 0 {
+			i.batchSize = size
+		}
+	}
+}
+
+// WithAckWait sets how long the NATS server waits for a message to be acknowledged before redelivering it.
+// It must be longer than the inbound message handler takes. Defaults to 30 seconds.
+func WithAckWait(ackWait time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.ackWait = ackWait
+	}
+}
+
+// WithMaxDeliver sets how many times a message is delivered before it is given up: if its handler fails on its
+// last delivery, it is dead-lettered (see WithDeadLetterSubject) and terminated. -1 redelivers messages without
+// limit. Defaults to 5.
+func WithMaxDeliver(maxDeliver int) InboundOpt {
+	return func(i *Inbound) {
+		i.maxDeliver = maxDeliver
+	}
+}
+
+// WithRetryBackOff sets the exponential backoff after which a message whose handler failed is redelivered. The
+// delay starts at initial and doubles with each delivery up to max. Defaults to an initial delay of 500ms and a
+// maximum delay of 30s.
+func WithRetryBackOff(initial, max time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.retryInitialDelay = initial
+		i.retryMaxDelay = max
+	}
+}
+
+// WithDeadLetterSubject sets the subject, which must belong to a JetStream stream, that messages given up after
+// their last delivery and messages that cannot be unpacked are published to, with the error recorded in the
+// ErrorHeader header and the subject they were consumed from in the OriginalSubjectHeader header. If not set, such
+// messages are logged and skipped.
+func WithDeadLetterSubject(subject string) InboundOpt {
+	return func(i *Inbound) {
+		i.deadLetterSubject = subject
+	}
+}
+
+// OutboundOpt is an option for the NATS outbound transport.
+type OutboundOpt func(o *Outbound)
+
+// WithOutboundNATSOptions sets options of the connections to NATS servers. See WithNATSOptions for details.
+func WithOutboundNATSOptions(opts ...nats.Option) OutboundOpt {
+	return func(o *Outbound) {
+		o.natsOpts = append(o.natsOpts, opts...)
+	}
+}
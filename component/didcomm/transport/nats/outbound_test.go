@@ -0,0 +1,65 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package nats_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/nats"
+)
+
+func TestOutboundTransport(t *testing.T) {
+	t.Run("test outbound transport - accept", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+		require.NoError(t, outbound.Start(&mockProvider{}))
+
+		require.True(t, outbound.Accept("nats://example.com:4222/agent.inbox"))
+		require.True(t, outbound.Accept("nats+tls://example.com:4222/agent.inbox"))
+		require.False(t, outbound.Accept("tls://example.com:4222/agent.inbox"))
+		require.False(t, outbound.Accept("amqp://example.com:5672?queue=queue"))
+		require.False(t, outbound.Accept("http://example.com"))
+		require.False(t, outbound.AcceptRecipient([]string{"key"}))
+	})
+
+	t.Run("test outbound transport - invalid destination", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+
+		_, err = outbound.Send([]byte("data"), nil)
+		require.EqualError(t, err, "destination is mandatory")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "http://example.com/subject"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported endpoint scheme")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: natsAddr})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a subject")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: natsAddr + "/agent.*"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a subject")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "nats+tls://127.0.0.1:4223/agent.tls"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to connect to NATS server at tls://127.0.0.1:4223")
+	})
+
+	t.Run("test outbound transport - subject without stream", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: natsAddr + "/no.stream.out"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to publish to subject no.stream.out")
+		require.NoError(t, outbound.Stop())
+	})
+}
@@ -0,0 +1,423 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package redis implements inbound and outbound DIDComm transports over Redis Streams for Aries
+// (aries-framework-go).
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PayloadField is the field of a stream entry holding the packed DIDComm message.
+	PayloadField = "payload"
+	// ContentTypeField is the field of a stream entry holding the content type of the message.
+	ContentTypeField = "content-type"
+	// ErrorField is the field of a dead-lettered entry holding the reason it was given up.
+	ErrorField = "error"
+	// OriginalStreamField is the field of a dead-lettered entry holding the stream it was read from.
+	OriginalStreamField = "original-stream"
+
+	defaultGroup         = "aries-framework-go"
+	defaultBatchSize     = 10
+	defaultBlock         = 2 * time.Second
+	defaultClaimMinIdle  = time.Minute
+	defaultClaimInterval = 30 * time.Second
+	defaultMaxDeliveries = 5
+	readRetryDelay       = 500 * time.Millisecond
+	busyGroupPrefix      = "BUSYGROUP"
+)
+
+// Inbound redis type.
+// Entries are read from a stream as a consumer of a consumer group, so agents sharing the group divide the entries
+// between them. An entry is acknowledged with XACK only once the inbound message handler succeeds. Entries that
+// stay pending for longer than the claim idle time, because their handler failed or because their consumer
+// crashed, are claimed by another consumer of the group, or this one, and handled again, until they have been
+// delivered too many times (see WithMaxDeliveries).
+type Inbound struct {
+	redisURL         string
+	stream           string
+	externalAddr     string
+	group            string
+	consumer         string
+	batchSize        int64
+	claimMinIdle     time.Duration
+	claimInterval    time.Duration
+	maxDeliveries    int64
+	deadLetterStream string
+	client           *redis.Client
+	packager         transport.Packager
+	msgHandler       transport.InboundMessageHandler
+	logger           *log.Log
+	lock             sync.Mutex
+	cancel           context.CancelFunc
+	served           chan struct{}
+}
+
+// NewInbound creates a new Redis Streams inbound transport instance that consumes from the given stream.
+// The Redis URL uses the redis or rediss scheme, for example redis://:password@localhost:6379/0.
+func NewInbound(redisURL, stream, externalAddr string, opts ...InboundOpt) (*Inbound, error) {
+	if redisURL == "" {
+		return nil, errors.New("redis URL is mandatory")
+	}
+
+	if stream == "" {
+		return nil, errors.New("stream is mandatory")
+	}
+
+	if externalAddr == "" {
+		return nil, errors.New("external address is mandatory")
+	}
+
+	i := &Inbound{
+		redisURL:      redisURL,
+		stream:        stream,
+		externalAddr:  externalAddr,
+		group:         defaultGroup,
+		batchSize:     defaultBatchSize,
+		claimMinIdle:  defaultClaimMinIdle,
+		claimInterval: defaultClaimInterval,
+		maxDeliveries: defaultMaxDeliveries,
+		logger:        log.New("aries-framework/transport/redis"),
+		served:        make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	if i.consumer == "" {
+		i.consumer = "aries-redis-" + uuid.New().String()
+	}
+
+	return i, nil
+}
+
+// Start the Redis Streams message loop. The stream and the consumer group are created if they do not exist,
+// in which case the group starts with the entries already in the stream.
+func (i *Inbound) Start(prov transport.Provider) error {
+	if prov == nil || prov.InboundMessageHandler() == nil {
+		return errors.New("creation of inbound handler failed")
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.cancel != nil {
+		return errors.New("transport is already started")
+	}
+
+	i.packager = prov.Packager()
+	i.msgHandler = prov.InboundMessageHandler()
+
+	client, err := i.connect()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	i.client = client
+	i.cancel = cancel
+
+	go i.listenAndServe(ctx)
+
+	return nil
+}
+
+// connect connects to the Redis server and creates the consumer group.
+func (i *Inbound) connect() (*redis.Client, error) {
+	opts, err := redis.ParseURL(i.redisURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid Redis URL %s", i.redisURL)
+	}
+
+	client := redis.NewClient(opts)
+
+	ctx := context.Background()
+
+	if err = client.Ping(ctx).Err(); err != nil {
+		i.closeClient(client)
+
+		return nil, errors.Wrapf(err, "unable to connect to Redis server at %s", opts.Addr)
+	}
+
+	err = client.XGroupCreateMkStream(ctx, i.stream, i.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), busyGroupPrefix) {
+		i.closeClient(client)
+
+		return nil, errors.Wrapf(err, "unable to create consumer group %s", i.group)
+	}
+
+	return client, nil
+}
+
+func (i *Inbound) closeClient(client *redis.Client) {
+	if err := client.Close(); err != nil {
+		i.logger.Warnf("failed to close Redis client: %v", err)
+	}
+}
+
+// listenAndServe reads and handles entries until the transport is stopped, claiming idle pending entries every
+// claim interval.
+func (i *Inbound) listenAndServe(ctx context.Context) {
+	defer close(i.served)
+
+	var lastClaim time.Time
+
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= i.claimInterval {
+			i.claim(ctx)
+
+			lastClaim = time.Now()
+		}
+
+		msgs, err := i.read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			i.logger.Warnf("failed to read from stream %s: %v", i.stream, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(readRetryDelay):
+			}
+
+			continue
+		}
+
+		i.handleAll(ctx, msgs)
+	}
+}
+
+// read waits for new entries. It returns no entries and no error if none arrived in time.
+func (i *Inbound) read(ctx context.Context) ([]redis.XMessage, error) {
+	streams, err := i.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    i.group,
+		Consumer: i.consumer,
+		Streams:  []string{i.stream, ">"},
+		Count:    i.batchSize,
+		Block:    defaultBlock,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []redis.XMessage
+
+	for _, s := range streams {
+		msgs = append(msgs, s.Messages...)
+	}
+
+	return msgs, nil
+}
+
+// claim takes over and handles the entries of the group that have been pending for longer than the claim idle
+// time, for example because the consumer they were delivered to crashed. Entries that have already been
+// delivered the maximum number of times are dead-lettered instead.
+// Idle entries are listed with XPENDING and taken over with XCLAIM rather than with XAUTOCLAIM, whose Redis 7 reply
+// go-redis v8 cannot parse.
+func (i *Inbound) claim(ctx context.Context) {
+	start := "-"
+
+	for ctx.Err() == nil {
+		pending, err := i.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: i.stream,
+			Group:  i.group,
+			Idle:   i.claimMinIdle,
+			Start:  start,
+			End:    "+",
+			Count:  i.batchSize,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				i.logger.Warnf("failed to list pending entries of stream %s: %v", i.stream, err)
+			}
+
+			return
+		}
+
+		if len(pending) == 0 {
+			return
+		}
+
+		i.claimAll(ctx, pending)
+
+		if int64(len(pending)) < i.batchSize {
+			return
+		}
+
+		// The range starts after the last entry listed.
+		start = "(" + pending[len(pending)-1].ID
+	}
+}
+
+// claimAll takes over pending entries that are still idle, and handles or dead-letters them. Entries that another
+// consumer claimed in the meantime are no longer idle, so they are left to that consumer.
+func (i *Inbound) claimAll(ctx context.Context, pending []redis.XPendingExt) {
+	ids := make([]string, len(pending))
+	deliveries := make(map[string]int64, len(pending))
+
+	for j, p := range pending {
+		ids[j] = p.ID
+		deliveries[p.ID] = p.RetryCount
+	}
+
+	msgs, err := i.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   i.stream,
+		Group:    i.group,
+		Consumer: i.consumer,
+		MinIdle:  i.claimMinIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			i.logger.Warnf("failed to claim pending entries of stream %s: %v", i.stream, err)
+		}
+
+		return
+	}
+
+	var deliverable []redis.XMessage
+
+	for _, m := range msgs {
+		if i.maxDeliveries > 0 && deliveries[m.ID] >= i.maxDeliveries {
+			i.deadLetter(m, fmt.Sprintf("given up after %d deliveries", deliveries[m.ID]))
+
+			continue
+		}
+
+		deliverable = append(deliverable, m)
+	}
+
+	i.handleAll(ctx, deliverable)
+}
+
+func (i *Inbound) handleAll(ctx context.Context, msgs []redis.XMessage) {
+	for _, m := range msgs {
+		// Entries left unacknowledged stay pending and are claimed again later.
+		if ctx.Err() != nil {
+			return
+		}
+
+		i.handle(m)
+	}
+}
+
+// handle unpacks an entry and hands it to the inbound message handler. The entry is acknowledged if the handler
+// succeeds, and left pending otherwise. Entries that cannot be unpacked will never be handled, so they are
+// dead-lettered.
+func (i *Inbound) handle(m redis.XMessage) {
+	payload, ok := m.Values[PayloadField].(string)
+	if !ok {
+		i.logger.Errorf("entry %s of stream %s has no %s field", m.ID, i.stream, PayloadField)
+		i.deadLetter(m, "no "+PayloadField+" field")
+
+		return
+	}
+
+	unpackMsg, err := i.packager.UnpackMessage([]byte(payload))
+	if err != nil {
+		i.logger.Errorf("failed to unpack msg: %v", err)
+		i.deadLetter(m, fmt.Sprintf("failed to unpack msg: %v", err))
+
+		return
+	}
+
+	if err = i.msgHandler(unpackMsg); err != nil {
+		i.logger.Errorf("incoming msg processing failed: %v", err)
+
+		return
+	}
+
+	i.ack(m)
+}
+
+// deadLetter adds an entry that will never be handled to the dead-letter stream, if any, with the reason recorded
+// in its ErrorField field, and acknowledges it. If the entry cannot be added to the dead-letter stream, it is left
+// pending and dead-lettered again once it is claimed.
+func (i *Inbound) deadLetter(m redis.XMessage, reason string) {
+	if i.deadLetterStream == "" {
+		i.logger.Warnf("skipping entry %s of stream %s: %s", m.ID, i.stream, reason)
+		i.ack(m)
+
+		return
+	}
+
+	values := make(map[string]interface{}, len(m.Values)+2)
+
+	for k, v := range m.Values {
+		values[k] = v
+	}
+
+	values[ErrorField] = reason
+	values[OriginalStreamField] = i.stream
+
+	err := i.client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: i.deadLetterStream,
+		Values: values,
+	}).Err()
+	if err != nil {
+		i.logger.Errorf("failed to dead-letter entry %s of stream %s: %v", m.ID, i.stream, err)
+
+		return
+	}
+
+	i.ack(m)
+}
+
+func (i *Inbound) ack(m redis.XMessage) {
+	// The entry has been handled, so it is acknowledged even if the transport is being stopped.
+	if err := i.client.XAck(context.Background(), i.stream, i.group, m.ID).Err(); err != nil {
+		i.logger.Errorf("failed to ack entry %s of stream %s: %v", m.ID, i.stream, err)
+	}
+}
+
+// Stop the Redis Streams message loop. It waits for the entry being handled, if any, and closes the client.
+// The consumer group is kept, so entries added while the agent is stopped are handled once it is started again.
+// Calling Stop on a transport that was never started or was already stopped does nothing.
+func (i *Inbound) Stop() error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.cancel == nil || i.client == nil {
+		return nil
+	}
+
+	i.cancel()
+	<-i.served
+
+	client := i.client
+	i.client = nil
+
+	if err := client.Close(); err != nil {
+		return fmt.Errorf("client shutdown failed: %w", err)
+	}
+
+	return nil
+}
+
+// Endpoint provides the Redis connection details.
+func (i *Inbound) Endpoint() string {
+	return i.externalAddr
+}
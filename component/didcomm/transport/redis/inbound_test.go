@@ -0,0 +1,357 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-redis/redis/v8"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	mockpackager "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/packager"
+	dctest "github.com/ory/dockertest/v3"
+	dc "github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/redis"
+)
+
+const (
+	externalAddr     = "http://example.com"
+	dockerRedisImage = "redis"
+	dockerRedisTag   = "7.0.4"
+	redisAddr        = "redis://127.0.0.1:6380"
+	messageTimeout   = 5 * time.Second
+)
+
+func TestMain(m *testing.M) {
+	code := 1
+
+	defer func() { os.Exit(code) }()
+
+	pool, err := dctest.NewPool("")
+	if err != nil {
+		panic(fmt.Sprintf("pool: %v", err))
+	}
+
+	redisResource, err := pool.RunWithOptions(&dctest.RunOptions{
+		Repository: dockerRedisImage, Tag: dockerRedisTag,
+		PortBindings: map[dc.Port][]dc.PortBinding{
+			"6379/tcp": {{HostIP: "", HostPort: "6380"}},
+		},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("run with options: %v", err))
+	}
+
+	defer func() {
+		if err = pool.Purge(redisResource); err != nil {
+			panic(fmt.Sprintf("purge: %v", err))
+		}
+	}()
+
+	if err := checkRedis(); err != nil {
+		panic(fmt.Sprintf("check Redis: %v", err))
+	}
+
+	code = m.Run()
+}
+
+func checkRedis() error {
+	const retries = 60
+
+	opts, err := redis.ParseURL(redisAddr)
+	if err != nil {
+		return err
+	}
+
+	client := redis.NewClient(opts)
+
+	defer client.Close() //nolint:errcheck // test client
+
+	return backoff.Retry(func() error {
+		return client.Ping(context.Background()).Err()
+	}, backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), retries))
+}
+
+func TestInboundTransport(t *testing.T) {
+	t.Run("test inbound transport - endpoint", func(t *testing.T) {
+		inbound, err := NewInbound(redisAddr, "agent", externalAddr)
+		require.NoError(t, err)
+		require.Equal(t, externalAddr, inbound.Endpoint())
+	})
+
+	t.Run("test inbound transport - missing arguments", func(t *testing.T) {
+		_, err := NewInbound("", "agent", externalAddr)
+		require.EqualError(t, err, "redis URL is mandatory")
+
+		_, err = NewInbound(redisAddr, "", externalAddr)
+		require.EqualError(t, err, "stream is mandatory")
+
+		_, err = NewInbound(redisAddr, "agent", "")
+		require.EqualError(t, err, "external address is mandatory")
+	})
+
+	t.Run("test inbound transport - nil context", func(t *testing.T) {
+		inbound, err := NewInbound(redisAddr, "agent", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(nil)
+		require.Error(t, err)
+	})
+
+	t.Run("test inbound transport - invalid server", func(t *testing.T) {
+		inbound, err := NewInbound("http://127.0.0.1:6380", "agent", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: &mockpackager.Packager{}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid Redis URL")
+
+		inbound, err = NewInbound("redis://127.0.0.1:5555", "agent", externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(&mockProvider{packagerValue: &mockpackager.Packager{}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to connect to Redis server")
+		require.NoError(t, inbound.Stop())
+	})
+
+	t.Run("test inbound transport - receive", func(t *testing.T) {
+		stream := "receive"
+
+		received := make(chan string, 1)
+
+		inbound, err := NewInbound(redisAddr+"/1", stream, externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(&handlerProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(envelope *transport.Envelope) error {
+				received <- string(envelope.Message)
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		send(t, redisAddr+"/"+stream+"?db=1")
+
+		select {
+		case msg := <-received:
+			require.Equal(t, "data", msg)
+		case <-time.After(messageTimeout):
+			require.Fail(t, "timed out waiting for message")
+		}
+	})
+
+	t.Run("test inbound transport - claim entries of a crashed consumer", func(t *testing.T) {
+		stream := "claim"
+		failed := make(chan struct{}, 1)
+
+		crashed, err := NewInbound(redisAddr, stream, externalAddr, WithConsumer("crashed"))
+		require.NoError(t, err)
+
+		err = crashed.Start(&handlerProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				failed <- struct{}{}
+
+				return errors.New("handler failed")
+			},
+		})
+		require.NoError(t, err)
+
+		send(t, redisAddr+"/"+stream)
+
+		select {
+		case <-failed:
+		case <-time.After(messageTimeout):
+			require.Fail(t, "timed out waiting for message")
+		}
+
+		require.NoError(t, crashed.Stop())
+		require.NoError(t, crashed.Stop())
+
+		received := make(chan struct{}, 1)
+
+		inbound, err := NewInbound(redisAddr, stream, externalAddr, WithConsumer("survivor"),
+			WithClaim(100*time.Millisecond, 100*time.Millisecond))
+		require.NoError(t, err)
+
+		err = inbound.Start(&handlerProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				received <- struct{}{}
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		select {
+		case <-received:
+		case <-time.After(messageTimeout):
+			require.Fail(t, "timed out waiting for claimed message")
+		}
+	})
+
+	t.Run("test inbound transport - dead-letter entries delivered too many times", func(t *testing.T) {
+		stream := "poison"
+		deadLetters := "poison-dead-letters"
+
+		var attempts int32
+
+		inbound, err := NewInbound(redisAddr, stream, externalAddr, WithMaxDeliveries(2),
+			WithDeadLetterStream(deadLetters), WithClaim(100*time.Millisecond, 100*time.Millisecond))
+		require.NoError(t, err)
+
+		err = inbound.Start(&handlerProvider{
+			packager: &mockpackager.Packager{UnpackValue: &transport.Envelope{Message: []byte("data")}},
+			handler: func(*transport.Envelope) error {
+				atomic.AddInt32(&attempts, 1)
+
+				return errors.New("handler failed")
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		send(t, redisAddr+"/"+stream)
+
+		client := newClient(t)
+
+		var entries []redis.XMessage
+
+		require.Eventually(t, func() bool {
+			entries, err = client.XRange(context.Background(), deadLetters, "-", "+").Result()
+
+			return err == nil && len(entries) == 1
+		}, messageTimeout, 50*time.Millisecond)
+
+		require.Equal(t, "packed", entries[0].Values[PayloadField])
+		require.Equal(t, "given up after 2 deliveries", entries[0].Values[ErrorField])
+		require.Equal(t, stream, entries[0].Values[OriginalStreamField])
+		require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+
+		require.Eventually(t, func() bool {
+			pending, errPending := client.XPending(context.Background(), stream, "aries-framework-go").Result()
+
+			return errPending == nil && pending.Count == 0
+		}, messageTimeout, 50*time.Millisecond)
+	})
+
+	t.Run("test inbound transport - skip unpackable entries", func(t *testing.T) {
+		stream := "unpackable"
+
+		inbound, err := NewInbound(redisAddr, stream, externalAddr)
+		require.NoError(t, err)
+
+		err = inbound.Start(&handlerProvider{
+			packager: &mockpackager.Packager{UnpackErr: errors.New("unpack failed")},
+			handler: func(*transport.Envelope) error {
+				require.Fail(t, "handler called for an unpackable entry")
+
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		defer func() { require.NoError(t, inbound.Stop()) }()
+
+		send(t, redisAddr+"/"+stream)
+
+		client := newClient(t)
+
+		require.Eventually(t, func() bool {
+			pending, errPending := client.XPending(context.Background(), stream, "aries-framework-go").Result()
+			if errPending != nil {
+				return false
+			}
+
+			length, errLen := client.XLen(context.Background(), stream).Result()
+
+			return errLen == nil && length == 1 && pending.Count == 0
+		}, messageTimeout, 50*time.Millisecond)
+	})
+}
+
+func newClient(t *testing.T) *redis.Client {
+	t.Helper()
+
+	opts, err := redis.ParseURL(redisAddr)
+	require.NoError(t, err)
+
+	client := redis.NewClient(opts)
+
+	t.Cleanup(func() { require.NoError(t, client.Close()) })
+
+	return client
+}
+
+func send(t *testing.T, endpoint string) {
+	t.Helper()
+
+	outbound, err := NewOutbound()
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, outbound.Stop()) }()
+
+	_, err = outbound.Send([]byte("packed"), &service.Destination{ServiceEndpoint: endpoint})
+	require.NoError(t, err)
+}
+
+type mockProvider struct {
+	packagerValue transport.Packager
+}
+
+func (p *mockProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return func(envelope *transport.Envelope) error {
+		if envelope != nil && string(envelope.Message) == "invalid-data" {
+			return errors.New("error")
+		}
+
+		return nil
+	}
+}
+
+func (p *mockProvider) Packager() transport.Packager {
+	return p.packagerValue
+}
+
+func (p *mockProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
+
+type handlerProvider struct {
+	packager transport.Packager
+	handler  transport.InboundMessageHandler
+}
+
+func (p *handlerProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return p.handler
+}
+
+func (p *handlerProvider) Packager() transport.Packager {
+	return p.packager
+}
+
+func (p *handlerProvider) AriesFrameworkID() string {
+	return "aries-framework-id"
+}
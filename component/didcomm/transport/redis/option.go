@@ -0,0 +1,69 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package redis
+
+import (
+	"time"
+)
+
+// InboundOpt is an option for the Redis Streams inbound transport.
+type InboundOpt func(i *Inbound)
+
+// WithGroup sets the consumer group the inbound transport reads with. Agents that share a consumer group divide
+// the entries of the stream between them, so each entry is handled by only one of them.
+// Defaults to aries-framework-go.
+func WithGroup(group string) InboundOpt {
+	return func(i *Inbound) {
+		i.group = group
+	}
+}
+
+// WithConsumer sets the name of the consumer within the group. Entries delivered to a consumer stay pending
+// under its name until they are acknowledged, so a stable name lets a restarted agent find its own pending
+// entries. If not set, a unique name is generated.
+func WithConsumer(consumer string) InboundOpt {
+	return func(i *Inbound) {
+		i.consumer = consumer
+	}
+}
+
+// WithBatchSize sets the maximum number of entries read from the stream at once. Defaults to 10.
+func WithBatchSize(size int64) InboundOpt {
+	return func(i *Inbound) {
+		if size > 0 {
+			i.batchSize = size
+		}
+	}
+}
+
+// WithClaim sets how long an entry must have been pending before it is claimed and handled again (minIdle), and
+// how often pending entries are looked for (interval). minIdle must be longer than the inbound message handler
+// takes. Defaults to a minimum idle time of one minute and an interval of 30 seconds.
+func WithClaim(minIdle, interval time.Duration) InboundOpt {
+	return func(i *Inbound) {
+		i.claimMinIdle = minIdle
+		i.claimInterval = interval
+	}
+}
+
+// WithMaxDeliveries sets how many times an entry is delivered, counting claims, before it is given up: it is then
+// dead-lettered (see WithDeadLetterStream) and acknowledged. Zero or less delivers entries without limit.
+// Defaults to 5.
+func WithMaxDeliveries(maxDeliveries int64) InboundOpt {
+	return func(i *Inbound) {
+		i.maxDeliveries = maxDeliveries
+	}
+}
+
+// WithDeadLetterStream sets the stream that entries delivered too many times (see WithMaxDeliveries) and entries
+// that cannot be unpacked are added to, with the reason recorded in the ErrorField field and the stream they were
+// read from in the OriginalStreamField field. If not set, such entries are logged and skipped.
+func WithDeadLetterStream(stream string) InboundOpt {
+	return func(i *Inbound) {
+		i.deadLetterStream = stream
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/pkg/errors"
+)
+
+const (
+	redisScheme  = "redis://"
+	redissScheme = "rediss://"
+
+	// dbParam is the query parameter of a service endpoint that selects the Redis database.
+	dbParam = "db"
+
+	commContentType = "application/didcomm-envelope-enc"
+)
+
+// Outbound redis type.
+// Service endpoints are Redis URLs with the recipient's stream as their path, for example
+// redis://:password@localhost:6379/agent, with an optional db query parameter selecting the database.
+// Clients are kept open and shared per Redis server and database.
+type Outbound struct {
+	clients map[string]*redis.Client
+	lock    sync.Mutex
+	logger  *log.Log
+}
+
+// NewOutbound creates a new Redis Streams outbound transport instance.
+func NewOutbound() (*Outbound, error) {
+	return &Outbound{
+		clients: map[string]*redis.Client{},
+		logger:  log.New("aries-framework/transport/redis"),
+	}, nil
+}
+
+// Start starts the outbound transport.
+func (o *Outbound) Start(prov transport.Provider) error {
+	return nil
+}
+
+// Send adds the packed message as an entry to the stream named in the destination's service endpoint.
+// The stream is created if it does not exist.
+func (o *Outbound) Send(data []byte, destination *service.Destination) (string, error) {
+	if destination == nil {
+		return "", errors.New("destination is mandatory")
+	}
+
+	addr, stream, err := parseEndpoint(destination.ServiceEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := o.client(addr)
+	if err != nil {
+		return "", err
+	}
+
+	err = client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}{
+			PayloadField:     data,
+			ContentTypeField: commContentType,
+		},
+	}).Err()
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to add to stream %s", stream)
+	}
+
+	return "", nil
+}
+
+// AcceptRecipient checks if there is a connection for the list of recipient keys. Always returns false.
+func (o *Outbound) AcceptRecipient([]string) bool {
+	return false
+}
+
+// Accept checks whether the given URL is a Redis endpoint.
+func (o *Outbound) Accept(endpoint string) bool {
+	return strings.HasPrefix(endpoint, redisScheme) || strings.HasPrefix(endpoint, redissScheme)
+}
+
+// Stop closes all open Redis clients.
+func (o *Outbound) Stop() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for addr, client := range o.clients {
+		delete(o.clients, addr)
+
+		if err := client.Close(); err != nil {
+			return fmt.Errorf("client shutdown failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (o *Outbound) client(addr string) (*redis.Client, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if client, ok := o.clients[addr]; ok {
+		return client, nil
+	}
+
+	opts, err := redis.ParseURL(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid Redis URL %s", addr)
+	}
+
+	client := redis.NewClient(opts)
+	o.clients[addr] = client
+
+	return client, nil
+}
+
+// parseEndpoint splits a service endpoint into the Redis URL, with the database as its path, and the stream name.
+func parseEndpoint(endpoint string) (string, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid endpoint %s", endpoint)
+	}
+
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return "", "", fmt.Errorf("unsupported endpoint scheme: %s", endpoint)
+	}
+
+	stream := strings.TrimPrefix(u.Path, "/")
+	if stream == "" || strings.Contains(stream, "/") {
+		return "", "", fmt.Errorf("endpoint %s does not name a stream", endpoint)
+	}
+
+	query := u.Query()
+
+	u.Path = ""
+	u.RawPath = ""
+
+	if db := query.Get(dbParam); db != "" {
+		u.Path = "/" + db
+	}
+
+	query.Del(dbParam)
+	u.RawQuery = query.Encode()
+
+	return u.String(), stream, nil
+}
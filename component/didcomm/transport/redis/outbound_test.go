@@ -0,0 +1,52 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package redis_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/redis"
+)
+
+func TestOutboundTransport(t *testing.T) {
+	t.Run("test outbound transport - accept", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+		require.NoError(t, outbound.Start(&mockProvider{}))
+
+		require.True(t, outbound.Accept("redis://example.com:6379/agent"))
+		require.True(t, outbound.Accept("rediss://example.com:6379/agent?db=1"))
+		require.False(t, outbound.Accept("amqp://example.com:5672?queue=queue"))
+		require.False(t, outbound.Accept("http://example.com"))
+		require.False(t, outbound.AcceptRecipient([]string{"key"}))
+	})
+
+	t.Run("test outbound transport - invalid destination", func(t *testing.T) {
+		outbound, err := NewOutbound()
+		require.NoError(t, err)
+
+		_, err = outbound.Send([]byte("data"), nil)
+		require.EqualError(t, err, "destination is mandatory")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: "http://example.com/agent"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported endpoint scheme")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: redisAddr})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "does not name a stream")
+
+		_, err = outbound.Send([]byte("data"), &service.Destination{ServiceEndpoint: redisAddr + "/agent?db=x"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid Redis URL")
+
+		require.NoError(t, outbound.Stop())
+	})
+}
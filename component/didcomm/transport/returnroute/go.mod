@@ -0,0 +1,11 @@
+// Copyright Scoir Inc. All Rights Reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+module github.com/hyperledger/aries-framework-go-ext/component/didcomm/transport/returnroute
+
+go 1.17
+
+require (
+	github.com/hyperledger/aries-framework-go v0.1.8
+	github.com/stretchr/testify v1.7.0
+)
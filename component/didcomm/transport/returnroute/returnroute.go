@@ -0,0 +1,183 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package returnroute keeps the return routes of DIDComm transports for Aries (aries-framework-go) that reply over
+// their message broker, such as the AMQP and MQTT transports.
+//
+// A sender asks for return routing with the ~transport return_route decorator of its message. The inbound
+// transport then records where the sender expects replies, for example a reply queue, under the sender's key, and
+// the outbound transport sends messages addressed to that key over the recorded route instead of to the sender's
+// service endpoint. Sender keys are derived from unpacked messages the same way as by the websocket transport of
+// Aries, so that they match the recipient keys of replies.
+package returnroute
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	cryptoapi "github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+)
+
+const (
+	// DefaultTTL is how long a return route is kept by default after the last message asking for it.
+	DefaultTTL = 10 * time.Minute
+
+	// legacyKeyLen is the length of the raw Ed25519 sender keys of messages packed with the legacy packers.
+	legacyKeyLen = 32
+)
+
+// Routes holds the return routes of senders by sender key. A route expires once its time to live has passed since
+// the last message asking for it, so that routes of senders that went away are eventually dropped.
+type Routes struct {
+	ttl       time.Duration
+	routes    map[string]entry
+	lastPrune time.Time
+	now       func() time.Time
+	lock      sync.Mutex
+}
+
+type entry struct {
+	route  interface{}
+	expiry time.Time
+}
+
+// New creates an empty set of return routes whose routes expire after ttl, or after DefaultTTL if ttl is not
+// greater than zero.
+func New(ttl time.Duration) *Routes {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Routes{
+		ttl:       ttl,
+		routes:    map[string]entry{},
+		lastPrune: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Requested checks whether the ~transport decorator of a message asks for replies over the return route, with a
+// return_route of all or thread.
+func Requested(trans *decorator.Transport) bool {
+	if trans == nil || trans.ReturnRoute == nil {
+		return false
+	}
+
+	switch trans.ReturnRoute.Value {
+	case decorator.TransportReturnRouteAll, decorator.TransportReturnRouteThread:
+		return true
+	default:
+		return false
+	}
+}
+
+// SenderKey returns the key that replies to the sender of an unpacked message are addressed to: the did:key of a
+// legacy Ed25519 sender key, or the KID of a JSON-encoded public key. It returns false if the sender is anonymous
+// or its key has neither form.
+func SenderKey(envelope *transport.Envelope) (string, bool) {
+	if envelope == nil || len(envelope.FromKey) == 0 {
+		return "", false
+	}
+
+	if len(envelope.FromKey) == legacyKeyLen {
+		didKey, _ := fingerprint.CreateDIDKey(envelope.FromKey)
+
+		return didKey, true
+	}
+
+	pubKey := &cryptoapi.PublicKey{}
+
+	if err := json.Unmarshal(envelope.FromKey, pubKey); err != nil || pubKey.KID == "" {
+		return "", false
+	}
+
+	return pubKey.KID, true
+}
+
+// Update records route as the return route of the sender of an unpacked message if its ~transport decorator asks
+// for one, and removes the sender's route if the decorator has a return_route of none. It returns false if the
+// message asks for a return route but its sender key is unknown, in which case replies cannot be routed back.
+func (r *Routes) Update(envelope *transport.Envelope, trans *decorator.Transport, route interface{}) bool {
+	if trans == nil || trans.ReturnRoute == nil {
+		return true
+	}
+
+	key, ok := SenderKey(envelope)
+	if !ok {
+		return !Requested(trans)
+	}
+
+	if Requested(trans) {
+		r.Add(key, route)
+	} else {
+		r.Remove(key)
+	}
+
+	return true
+}
+
+// Add records route as the return route of a sender key, replacing any route it already had.
+func (r *Routes) Add(key string, route interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+
+	r.prune(now)
+
+	r.routes[key] = entry{route: route, expiry: now.Add(r.ttl)}
+}
+
+// Remove removes the return route of a sender key, if any.
+func (r *Routes) Remove(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.routes, key)
+}
+
+// Get returns the return route of the first of the given keys that has an unexpired one.
+func (r *Routes) Get(keys []string) (interface{}, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+
+	for _, key := range keys {
+		if e, ok := r.routes[key]; ok && now.Before(e.expiry) {
+			return e.route, true
+		}
+	}
+
+	return nil, false
+}
+
+// Len returns the number of routes held, including expired routes that were not removed yet.
+func (r *Routes) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.routes)
+}
+
+// prune removes the expired routes, at most once per time to live so that adding a route stays cheap.
+func (r *Routes) prune(now time.Time) {
+	if now.Sub(r.lastPrune) < r.ttl {
+		return
+	}
+
+	for key, e := range r.routes {
+		if !now.Before(e.expiry) {
+			delete(r.routes, key)
+		}
+	}
+
+	r.lastPrune = now
+}
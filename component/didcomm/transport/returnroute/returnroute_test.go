@@ -0,0 +1,170 @@
+/*
+Copyright Scoir, Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package returnroute
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	cryptoapi "github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/packer/legacy/authcrypt"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	mockprovider "github.com/hyperledger/aries-framework-go/pkg/mock/provider"
+	mockstorage "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSenderKey(t *testing.T) {
+	t.Run("test sender key - legacy packed message", func(t *testing.T) {
+		envelope, senderKey := packedEnvelope(t)
+
+		key, ok := SenderKey(envelope)
+		require.True(t, ok)
+		require.Equal(t, senderKey, key)
+	})
+
+	t.Run("test sender key - public key", func(t *testing.T) {
+		fromKey, err := json.Marshal(&cryptoapi.PublicKey{KID: "did:example:sender#key-1"})
+		require.NoError(t, err)
+
+		key, ok := SenderKey(&transport.Envelope{FromKey: fromKey})
+		require.True(t, ok)
+		require.Equal(t, "did:example:sender#key-1", key)
+	})
+
+	t.Run("test sender key - unknown sender", func(t *testing.T) {
+		_, ok := SenderKey(nil)
+		require.False(t, ok)
+
+		_, ok = SenderKey(&transport.Envelope{})
+		require.False(t, ok)
+
+		_, ok = SenderKey(&transport.Envelope{FromKey: []byte("sender-key")})
+		require.False(t, ok)
+
+		_, ok = SenderKey(&transport.Envelope{FromKey: []byte("{}")})
+		require.False(t, ok)
+	})
+}
+
+func TestRoutes(t *testing.T) {
+	envelope, senderKey := packedEnvelope(t)
+
+	all := &decorator.Transport{ReturnRoute: &decorator.ReturnRoute{Value: decorator.TransportReturnRouteAll}}
+	thread := &decorator.Transport{ReturnRoute: &decorator.ReturnRoute{Value: decorator.TransportReturnRouteThread}}
+	none := &decorator.Transport{ReturnRoute: &decorator.ReturnRoute{Value: decorator.TransportReturnRouteNone}}
+
+	t.Run("test routes - requested", func(t *testing.T) {
+		require.True(t, Requested(all))
+		require.True(t, Requested(thread))
+		require.False(t, Requested(none))
+		require.False(t, Requested(&decorator.Transport{}))
+		require.False(t, Requested(nil))
+	})
+
+	t.Run("test routes - update", func(t *testing.T) {
+		routes := New(0)
+		require.Equal(t, DefaultTTL, routes.ttl)
+
+		require.True(t, routes.Update(envelope, &decorator.Transport{}, "ignored"))
+		require.Zero(t, routes.Len())
+
+		require.True(t, routes.Update(envelope, all, "route"))
+
+		route, ok := routes.Get([]string{"other", senderKey})
+		require.True(t, ok)
+		require.Equal(t, "route", route)
+
+		_, ok = routes.Get([]string{"other"})
+		require.False(t, ok)
+
+		require.True(t, routes.Update(envelope, thread, "thread route"))
+
+		route, ok = routes.Get([]string{senderKey})
+		require.True(t, ok)
+		require.Equal(t, "thread route", route)
+
+		require.True(t, routes.Update(envelope, none, nil))
+
+		_, ok = routes.Get([]string{senderKey})
+		require.False(t, ok)
+		require.Zero(t, routes.Len())
+	})
+
+	t.Run("test routes - unknown sender", func(t *testing.T) {
+		routes := New(time.Minute)
+
+		require.False(t, routes.Update(&transport.Envelope{}, all, "route"))
+		require.True(t, routes.Update(&transport.Envelope{}, none, nil))
+		require.Zero(t, routes.Len())
+	})
+
+	t.Run("test routes - expiry", func(t *testing.T) {
+		now := time.Now()
+
+		routes := New(time.Minute)
+		routes.now = func() time.Time { return now }
+		routes.lastPrune = now
+
+		routes.Add("a", "route a")
+
+		now = now.Add(30 * time.Second)
+		routes.Add("b", "route b")
+
+		now = now.Add(45 * time.Second)
+
+		_, ok := routes.Get([]string{"a"})
+		require.False(t, ok)
+
+		_, ok = routes.Get([]string{"b"})
+		require.True(t, ok)
+
+		// Expired routes are removed once a time to live has passed since they were last pruned.
+		routes.Add("c", "route c")
+		require.Equal(t, 2, routes.Len())
+	})
+}
+
+// packedEnvelope packs a message with the legacy authcrypt packer and unpacks it, as the packager of an agent does
+// for an inbound message, and returns the unpacked envelope along with the did:key of its sender.
+func packedEnvelope(t *testing.T) (*transport.Envelope, string) {
+	t.Helper()
+
+	keyManager, err := localkms.New("local-lock://test/primary/", &mockprovider.Provider{
+		StorageProviderValue: mockstorage.NewMockStoreProvider(),
+		SecretLockValue:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	crypto, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	packer := authcrypt.New(&mockprovider.Provider{KMSValue: keyManager, CryptoValue: crypto})
+
+	_, senderKey, err := keyManager.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+
+	_, recipientKey, err := keyManager.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+
+	packed, err := packer.Pack("", []byte(`{"@id":"1"}`), senderKey, [][]byte{recipientKey})
+	require.NoError(t, err)
+
+	envelope, err := packer.Unpack(packed)
+	require.NoError(t, err)
+
+	didKey, _ := fingerprint.CreateDIDKey(senderKey)
+
+	return envelope, didKey
+}
@@ -17,6 +17,11 @@ const (
 	failureWhileExecutingInsertStatementErrMsg = "failure while executing insert statement on table %s: %w"
 	failureWhileQueryingRowErrMsg              = "failure while querying row: %w"
 	failureWhileExecutingBatchStatementErrMsg  = "failure while executing batch upsert on table %s: %w"
+	failureWhileDeletingTagsErrMsg             = "failure while deleting tags from table %s: %w"
+	failureWhileInsertingTagsErrMsg            = "failure while inserting tags into table %s: %w"
+	failureWhileQueryingTableErrMsg            = "failure while querying table %s: %w"
+	failureWhileBeginningTransactionErrMsg     = "failure while beginning transaction: %w"
+	failureWhileCommittingTransactionErrMsg    = "failure while committing transaction: %w"
 	// Error messages returned from MySQL that we directly check for.
 	valueNotFoundErrMsgFromMySQL = "no rows"
 )
@@ -8,30 +8,67 @@ SPDX-License-Identifier: Apache-2.0
 package mysql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	// Add as per the documentation - https://github.com/go-sql-driver/mysql
-	_ "github.com/go-sql-driver/mysql" //nolint:gci // False positive, seemingly caused by the MySQL driver comment.
-
+	"github.com/cenkalti/backoff/v4"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 )
 
 const (
 	createDBQuery  = "CREATE DATABASE IF NOT EXISTS `%s`"
-	tagMapKey      = "TagMap"
 	storeConfigKey = "StoreConfig"
 
+	defaultTimeout            = 10 * time.Second
+	defaultMaxRetries         = 3
+	defaultTimeBetweenRetries = 500 * time.Millisecond
+
+	// Numbers of the MySQL errors caused by concurrent transactions, after which a transaction can be retried.
+	lockWaitTimeoutErrorNumber = 1205
+	deadlockErrorNumber        = 1213
+
+	// tagMapKey is the key under which tags were mapped to keys before the tag table was introduced.
+	tagMapKey = "TagMap"
+	// createTagTableStmt creates the table holding the tags of the keys of a store, so that queries can use its
+	// index. Rows are deleted along with the key they belong to. Only the first 512 characters of tag values are
+	// indexed, to keep the index within the maximum key length of InnoDB.
+	createTagTableStmt = "CREATE TABLE IF NOT EXISTS %s (`key` varchar(255) NOT NULL, " +
+		"`tag_name` varchar(255) NOT NULL, `tag_value` text NOT NULL, PRIMARY KEY (`key`, `tag_name`), " +
+		"INDEX `tag` (`tag_name`, `tag_value`(512)), " +
+		"FOREIGN KEY (`key`) REFERENCES %s (`key`) ON DELETE CASCADE)"
+	// maxStatementParameters is the maximum number of parameters a MySQL prepared statement can have.
+	maxStatementParameters = 65535
+	// maxStatementSize is the maximum number of bytes of the values of a statement executed by Batch. It is kept well
+	// below the default max_allowed_packet of MySQL, which is 64 MiB.
+	maxStatementSize = 16 << 20
+	// maxIntegerTagValueDigits is the number of digits up to which tag values are sorted as integers.
+	maxIntegerTagValueDigits = 65
+
+	defaultPageSize = 25
+
 	expressionTagNameOnlyLength     = 1
 	expressionTagNameAndValueLength = 2
+	rangeExpressionLength           = 2
 	invalidQueryExpressionFormat    = `"%s" is not in a valid expression format. ` +
-		"it must be in the following format: TagName:TagValue"
-	invalidTagName  = `"%s" is an invalid tag name since it contains one or more ':' characters`
-	invalidTagValue = `"%s" is an invalid tag value since it contains one or more ':' characters`
+		"it must be in the following format: TagName:TagValue or TagName1:TagValue1&&TagName2:TagValue2. " +
+		"Tag values are optional. If using tag values, <=, <, >=, or > may be used in place of the : " +
+		"to match a range of tag values"
+	invalidTagName = `"%s" is an invalid tag name since it contains one or more of the following substrings: ` +
+		`":", "<=", "<", ">=", ">"`
+	invalidTagValue = `"%s" is an invalid tag value since it contains one or more of the following substrings: ` +
+		`":", "<=", "<", ">=", ">"`
+	// reservedTagCharacters are the characters that tag names and values can't contain, since query expressions
+	// use them as operators.
+	reservedTagCharacters = ":<>"
 )
 
 // TODO (#67): Fully implement all methods.
@@ -43,6 +80,10 @@ type closer func(storeName string)
 
 type tagMapping map[string]map[string]struct{} // map[TagName](Set of database Keys)
 
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 type dbEntry struct {
 	Value []byte        `json:"value,omitempty"`
 	Tags  []storage.Tag `json:"tags,omitempty"`
@@ -54,7 +95,11 @@ type Provider struct {
 	db       *sql.DB
 	dbs      map[string]*store
 	dbPrefix string
-	lock     sync.RWMutex
+	callOptions
+	maxOpenConnections    int
+	maxIdleConnections    int
+	connectionMaxLifetime time.Duration
+	lock                  sync.RWMutex
 }
 
 // Option configures the couchdb provider.
@@ -67,35 +112,85 @@ func WithDBPrefix(dbPrefix string) Option {
 	}
 }
 
+// WithTimeout is an option for specifying the timeout for each call to MySQL, including each attempt of a call that
+// is retried. A transaction, such as the one of a Batch, is a single call.
+// The timeout is 10 seconds by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *Provider) {
+		opts.timeout = timeout
+	}
+}
+
+// WithMaxRetries is an option for specifying how many times a call to MySQL is retried after a transient error:
+// a deadlock or lock wait timeout caused by concurrent transactions, or a broken connection.
+// Zero disables retries. If not set, it will default to 3.
+func WithMaxRetries(maxRetries uint64) Option {
+	return func(opts *Provider) {
+		opts.maxRetries = maxRetries
+	}
+}
+
+// WithTimeBetweenRetries is an option for specifying how long to wait before retrying a call to MySQL after a
+// transient error. Defaults to half a second if not set.
+func WithTimeBetweenRetries(timeBetweenRetries time.Duration) Option {
+	return func(opts *Provider) {
+		opts.timeBetweenRetries = timeBetweenRetries
+	}
+}
+
+// WithMaxOpenConnections is an option for limiting the number of open connections to MySQL.
+// All stores of the Provider share its connection pool, so the limit applies to all of them together.
+// There is no limit by default.
+func WithMaxOpenConnections(maxOpenConnections int) Option {
+	return func(opts *Provider) {
+		opts.maxOpenConnections = maxOpenConnections
+	}
+}
+
+// WithMaxIdleConnections is an option for specifying how many idle connections to MySQL are kept open in the
+// connection pool shared by the Provider and its stores. Defaults to 2, as for any sql.DB.
+func WithMaxIdleConnections(maxIdleConnections int) Option {
+	return func(opts *Provider) {
+		opts.maxIdleConnections = maxIdleConnections
+	}
+}
+
+// WithConnectionMaxLifetime is an option for specifying how long a connection to MySQL may be reused. Connections
+// that are older are closed rather than reused, which is useful if MySQL, or a proxy in front of it, closes idle
+// connections after some time. Connections are reused forever by default.
+func WithConnectionMaxLifetime(connectionMaxLifetime time.Duration) Option {
+	return func(opts *Provider) {
+		opts.connectionMaxLifetime = connectionMaxLifetime
+	}
+}
+
 // NewProvider instantiates Provider.
-// Example DB Path root:my-secret-pw@tcp(127.0.0.1:3306)/?interpolateParams=true&multiStatements=true
+// Example DB Path root:my-secret-pw@tcp(127.0.0.1:3306)/
 // This provider's CreateStore(name) implementation creates stores that are backed by a table under a schema
 // with the same name as the table. The fully qualified name of the table is thus `name.name`. The fully qualified
 // name of the table needs to be used with the store's `Query()` method.
-// Use of `Batch()` has additional considerations - please read the docs for Batch() accordingly..
 func NewProvider(dbPath string, opts ...Option) (*Provider, error) {
 	if dbPath == "" {
 		return nil, errBlankDBPath
 	}
 
-	db, err := sql.Open("mysql", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf(failureWhileOpeningMySQLConnectionErrMsg, dbPath, err)
+	p := &Provider{
+		dbURL: dbPath,
+		dbs:   map[string]*store{},
 	}
 
-	err = db.Ping()
+	setOptions(opts, p)
+
+	db, err := p.openDB()
 	if err != nil {
-		return nil, fmt.Errorf(failureWhilePingingMySQLErrMsg, dbPath, err)
+		return nil, err
 	}
 
-	p := &Provider{
-		dbURL: dbPath,
-		db:    db,
-		dbs:   map[string]*store{},
-	}
+	p.db = db
 
-	for _, opt := range opts {
-		opt(p)
+	err = p.Ping()
+	if err != nil {
+		return nil, fmt.Errorf(failureWhilePingingMySQLErrMsg, dbPath, err)
 	}
 
 	return p, nil
@@ -103,7 +198,8 @@ func NewProvider(dbPath string, opts ...Option) (*Provider, error) {
 
 // OpenStore opens a store with the given name and returns a handle.
 // If the store has never been opened before, then it is created.
-// Store names are not case-sensitive. If name is blank, then an error will be returned.
+// Store names are not case-sensitive. If name is blank, then an error will be returned. Since the store's tag table
+// is named after the store with a _tags suffix, store names, including the prefix, must be at most 59 characters long.
 // WARNING: This method will create a database and table based on the given name. Those database calls may be
 // vulnerable to an SQL injection attack. Be very careful if you use a user-provided string in the store name!
 func (p *Provider) OpenStore(name string) (storage.Store, error) {
@@ -127,7 +223,7 @@ func (p *Provider) OpenStore(name string) (storage.Store, error) {
 	}
 
 	// creating the database
-	_, err := p.db.Exec(fmt.Sprintf(createDBQuery, name))
+	err := p.exec(fmt.Sprintf(createDBQuery, name))
 	if err != nil {
 		return nil, fmt.Errorf(failureWhileCreatingDBErrMsg, name, err)
 	}
@@ -136,22 +232,32 @@ func (p *Provider) OpenStore(name string) (storage.Store, error) {
 		"`value` MEDIUMBLOB, PRIMARY KEY (`key`))", name, name)
 
 	// creating key-value table inside the database
-	_, err = p.db.Exec(createTableStmt)
+	err = p.exec(createTableStmt)
 	if err != nil {
 		return nil, fmt.Errorf(failureWhileCreatingTableErrMsg, name, err)
 	}
 
-	// Opening new DB connection
-	storeDB, err := sql.Open("mysql", p.dbURL)
+	tableName := fmt.Sprintf("`%s`.`%s`", name, name)
+	// The tag table is named after the store, since a fixed name would be the name of the table of some store.
+	tagTableName := fmt.Sprintf("`%s`.`%s_tags`", name, name)
+
+	err = p.exec(fmt.Sprintf(createTagTableStmt, tagTableName, tableName))
 	if err != nil {
-		return nil, fmt.Errorf(failureWhileOpeningMySQLConnectionErrMsg, p.dbURL, err)
+		return nil, fmt.Errorf(failureWhileCreatingTableErrMsg, tagTableName, err)
 	}
 
 	store := &store{
-		db:        storeDB,
-		name:      name,
-		tableName: fmt.Sprintf("`%s`.`%s`", name, name),
-		close:     p.removeStore,
+		db:           p.db,
+		name:         name,
+		tableName:    tableName,
+		tagTableName: tagTableName,
+		callOptions:  p.callOptions,
+		close:        p.removeStore,
+	}
+
+	err = store.migrateTagMap()
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate tag map: %w", err)
 	}
 
 	p.dbs[name] = store
@@ -159,12 +265,11 @@ func (p *Provider) OpenStore(name string) (storage.Store, error) {
 	return store, nil
 }
 
-// SetStoreConfig sets the configuration on a store. This must be done before storing any data in order to make use
-// of the Query method.
-// TODO (#67): Use proper MySQL indexing instead of the "Tag Map".
+// SetStoreConfig sets the configuration on a store.
+// All tags are indexed in the store's tag table, regardless of the tag names in the configuration.
 func (p *Provider) SetStoreConfig(name string, config storage.StoreConfiguration) error {
 	for _, tagName := range config.TagNames {
-		if strings.Contains(tagName, ":") {
+		if strings.ContainsAny(tagName, reservedTagCharacters) {
 			return fmt.Errorf(invalidTagName, tagName)
 		}
 	}
@@ -228,7 +333,7 @@ func (p *Provider) GetOpenStores() []storage.Store {
 	panic("not implemented")
 }
 
-// Close closes all stores created under this store provider.
+// Close closes all stores created under this store provider, and the connection pool they share.
 func (p *Provider) Close() error {
 	p.lock.RLock()
 
@@ -249,9 +354,54 @@ func (p *Provider) Close() error {
 		}
 	}
 
+	err := p.db.Close()
+	if err != nil {
+		return fmt.Errorf(failureWhileClosingMySQLConnection, err)
+	}
+
 	return nil
 }
 
+// Ping verifies whether the MySQL client can successfully connect to the MySQL server specified by the DB path used
+// in the NewProvider call.
+func (p *Provider) Ping() error {
+	ctx, cancel := p.newContext()
+	defer cancel()
+
+	return p.db.PingContext(ctx)
+}
+
+// openDB opens a connection pool to MySQL with the connection pool options of the Provider.
+func (p *Provider) openDB() (*sql.DB, error) {
+	db, err := sql.Open("mysql", p.dbURL)
+	if err != nil {
+		return nil, fmt.Errorf(failureWhileOpeningMySQLConnectionErrMsg, p.dbURL, err)
+	}
+
+	if p.maxOpenConnections > 0 {
+		db.SetMaxOpenConns(p.maxOpenConnections)
+	}
+
+	if p.maxIdleConnections > 0 {
+		db.SetMaxIdleConns(p.maxIdleConnections)
+	}
+
+	if p.connectionMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.connectionMaxLifetime)
+	}
+
+	return db, nil
+}
+
+// exec executes a statement that doesn't return rows on the Provider's connection pool.
+func (p *Provider) exec(statement string) error {
+	return p.call(func(ctx context.Context) error {
+		_, err := p.db.ExecContext(ctx, statement)
+
+		return err
+	})
+}
+
 func (p *Provider) removeStore(name string) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -266,10 +416,15 @@ type store struct {
 	db        *sql.DB
 	name      string
 	tableName string
-	close     closer
-	lock      sync.RWMutex
+	// tagTableName is the table holding the tags of each key, one row per tag name.
+	tagTableName string
+	callOptions
+	close closer
 }
 
+// Put stores the key + value pair along with the (optional) tags.
+// The value and the tags are written in a single transaction. When overwriting an existing key-value pair, its tags
+// are replaced by the given ones.
 func (s *store) Put(key string, value []byte, tags ...storage.Tag) error {
 	errInputValidation := validatePutInput(key, value, tags)
 	if errInputValidation != nil {
@@ -281,11 +436,6 @@ func (s *store) Put(key string, value []byte, tags ...storage.Tag) error {
 
 	if len(tags) > 0 {
 		newDBEntry.Tags = tags
-
-		err := s.updateTagMap(key, tags)
-		if err != nil {
-			return fmt.Errorf("failed to update tag map: %w", err)
-		}
 	}
 
 	entryBytes, err := json.Marshal(newDBEntry)
@@ -295,13 +445,16 @@ func (s *store) Put(key string, value []byte, tags ...storage.Tag) error {
 
 	// create upsert query to insert the record, checking whether the key is already mapped to a value in the store.
 	insertStmt := "INSERT INTO " + s.tableName + " VALUES (?, ?) ON DUPLICATE KEY UPDATE value=?"
-	// executing the prepared insert statement
-	_, err = s.db.Exec(insertStmt, key, entryBytes, entryBytes)
-	if err != nil {
-		return fmt.Errorf(failureWhileExecutingInsertStatementErrMsg, s.tableName, err)
-	}
 
-	return nil
+	return s.inTransaction(func(ctx context.Context, tx *sql.Tx) error {
+		// executing the prepared insert statement
+		_, errExec := tx.ExecContext(ctx, insertStmt, key, entryBytes, entryBytes)
+		if errExec != nil {
+			return fmt.Errorf(failureWhileExecutingInsertStatementErrMsg, s.tableName, errExec)
+		}
+
+		return s.replaceTags(ctx, tx, []storage.Operation{{Key: key, Value: value, Tags: tags}})
+	})
 }
 
 func (s *store) Get(k string) ([]byte, error) {
@@ -322,114 +475,168 @@ func (s *store) GetTags(key string) ([]storage.Tag, error) {
 	return retrievedDBEntry.Tags, nil
 }
 
-func (s *store) GetBulk(...string) ([][]byte, error) {
-	return nil, errors.New("not implemented")
-}
+// GetBulk fetches the values associated with the given keys in a single query.
+// If a key doesn't exist, then its value is nil. The values are returned in the same order as the keys.
+func (s *store) GetBulk(keys ...string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keys slice must contain at least one key")
+	}
 
-// This provider doesn't currently support any of the current query options.
-// spi.WithPageSize will simply be ignored since it only relates to performance and not the actual end result.
-// spi.WithInitialPageNum and spi.WithSortOrder will result in an error being returned since those options do
-// affect the results that the Iterator returns.
-func (s *store) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
-	err := checkForUnsupportedQueryOptions(options)
+	placeholders := make([]string, len(keys))
+	arguments := make([]interface{}, len(keys))
+
+	for i, key := range keys {
+		if key == "" {
+			return nil, errors.New("key cannot be empty")
+		}
+
+		placeholders[i] = "?"
+		arguments[i] = key
+	}
+
+	selectStmt := "SELECT `key`, `value` FROM " + s.tableName + " WHERE `key` IN (" + strings.Join(placeholders, ", ") +
+		")"
+
+	var valuesByKey map[string][]byte
+
+	err := s.call(func(ctx context.Context) error {
+		valuesByKey = make(map[string][]byte, len(keys))
+
+		return s.queryRows(ctx, func(rows *sql.Rows) error {
+			retrievedEntry, errScan := scanEntry(rows)
+			if errScan != nil {
+				return errScan
+			}
+
+			valuesByKey[retrievedEntry.key] = retrievedEntry.value
+
+			return nil
+		}, selectStmt, arguments...)
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf(failureWhileQueryingTableErrMsg, s.tableName, err)
+	}
+
+	values := make([][]byte, len(keys))
+
+	for i, key := range keys {
+		values[i] = valuesByKey[key]
 	}
 
+	return values, nil
+}
+
+// Query returns the entries matching the expression, as found in the store's tag table.
+// The expression format is TagName:TagValue, where the tag value is optional. Several expressions can be joined with
+// && to match the entries matching all of them. In place of the :, <=, <, >= or > may be used to match the entries
+// whose tag value is an integer in the given range.
+// Results are fetched from MySQL one page at a time, using the page size set with storage.WithPageSize (25 by
+// default), starting from the page set with storage.WithInitialPageNum.
+// If storage.WithSortOrder is used, then results are sorted by the value of the given tag: values that are integers
+// are sorted numerically and come first, followed by the other values, sorted as strings, followed by the entries
+// that don't have the tag. Entries with equal tag values are sorted by key.
+func (s *store) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
 	if expression == "" {
 		return nil, fmt.Errorf(invalidQueryExpressionFormat, expression)
 	}
 
-	expressionSplit := strings.Split(expression, ":")
+	filter, arguments, err := s.prepareFilter(expression)
+	if err != nil {
+		return nil, err
+	}
 
-	var expressionTagName string
+	queryOptions := getQueryOptions(options)
 
-	var expressionTagValue string
+	join, joinArguments, orderBy := s.prepareOrderBy(queryOptions.SortOptions)
 
-	switch len(expressionSplit) {
-	case expressionTagNameOnlyLength:
-		expressionTagName = expressionSplit[0]
-	case expressionTagNameAndValueLength:
-		expressionTagName = expressionSplit[0]
-		expressionTagValue = expressionSplit[1]
-	default:
-		return nil, fmt.Errorf(invalidQueryExpressionFormat, expression)
+	newIterator := &iterator{
+		store:         s,
+		join:          join,
+		joinArguments: joinArguments,
+		filter:        filter,
+		arguments:     arguments,
+		orderBy:       orderBy,
+		pageSize:      queryOptions.PageSize,
+		offset:        queryOptions.InitialPageNum * queryOptions.PageSize,
 	}
 
-	matchingDatabaseKeys, err := s.getDatabaseKeysMatchingQuery(expressionTagName, expressionTagValue)
+	err = newIterator.fetchPage()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get database keys matching query: %w", err)
+		return nil, err
 	}
 
-	return &iterator{keys: matchingDatabaseKeys, store: s}, nil
+	return newIterator, nil
 }
 
-// Delete will delete record with k key.
 func (s *store) Delete(k string) error {
 	if k == "" {
 		return ErrKeyRequired
 	}
 
 	// delete query to delete the record by key
-	_, err := s.db.Exec("DELETE FROM "+s.tableName+" WHERE `key`= ?", k)
-	if err != nil {
-		return fmt.Errorf(storage.ErrDataNotFound.Error(), err)
-	}
+	err := s.call(func(ctx context.Context) error {
+		_, errExec := s.db.ExecContext(ctx, "DELETE FROM "+s.tableName+" WHERE `key`= ?", k)
 
-	err = s.removeFromTagMap(k)
+		return errExec
+	})
 	if err != nil {
-		return fmt.Errorf("failed to remove key from tag map: %w", err)
+		return fmt.Errorf(storage.ErrDataNotFound.Error(), err)
 	}
 
 	return nil
 }
 
-// Batch performs batch upserts and deletions preserving the batch's ordering.
-// Batch is a no-op if the batch is empty.
-// Batch needs both `interpolateParams` and `multiStatements` enabled in the dataSourceName
-// - see the following guide: https://github.com/go-sql-driver/mysql#parameters.
-// All operations in the batch are executed in a single multi-statement query due to the ordering
-// requirements. This means we cannot optimize INSERT statements as per
-// https://dev.mysql.com/doc/refman/8.0/en/insert-optimization.html.
-// Executing a single multi-statement query requires O(N) space for the query string and an additional
-// slice with O(2*N) space holding the values for the query. Callers should take care of this additional
-// memory usage by limiting the size of the batch.
-func (s *store) Batch(batch []storage.Operation) error { // nolint:gocyclo
-	if len(batch) == 0 {
-		return errors.New("batch requires at least one operation")
+// Batch performs multiple Put and/or Delete operations in order. An operation with an empty value is a Delete.
+// All operations are done within a single transaction, so either all of them are applied, values and tags alike,
+// or, if any of them fails, none are. Consecutive Put operations are combined into multi-row upserts and consecutive
+// Delete operations into a single statement, split into several statements for very large batches. If an operation
+// on a key is followed by other operations on the same key, the last one wins. As with Put, overwriting an existing
+// key-value pair replaces its tags.
+// Batch uses prepared statements, so neither `interpolateParams` nor `multiStatements` need to be enabled in the
+// dataSourceName.
+func (s *store) Batch(operations []storage.Operation) error {
+	err := validateBatchInput(operations)
+	if err != nil {
+		return err
 	}
 
-	var (
-		query  string
-		values []interface{}
-	)
-
-	for i := range batch {
-		b := batch[i]
+	return s.inTransaction(func(ctx context.Context, tx *sql.Tx) error {
+		for start := 0; start < len(operations); {
+			isDelete := len(operations[start].Value) == 0
 
-		if b.Key == "" {
-			return errors.New("key cannot be empty")
-		}
+			end := start + 1
+			for end < len(operations) && (len(operations[end].Value) == 0) == isDelete {
+				end++
+			}
 
-		err := s.bulkAppendToQuery(b, &query, &values)
-		if err != nil {
-			return fmt.Errorf(failureWhileExecutingBatchStatementErrMsg, s.tableName, err)
-		}
+			var errExec error
 
-		if len(b.Value) > 0 {
-			err = s.updateTagMap(b.Key, b.Tags)
-			if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
-				return fmt.Errorf("failed to update tag map: %w", err)
+			if isDelete {
+				errExec = s.batchDelete(ctx, tx, operations[start:end])
+			} else {
+				errExec = s.batchPut(ctx, tx, operations[start:end])
 			}
-		} else {
-			err = s.removeFromTagMap(b.Key)
-			if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
-				return fmt.Errorf("failed to remove key from tag map: %w", err)
+
+			if errExec != nil {
+				return errExec
 			}
+
+			start = end
 		}
+
+		return nil
+	})
+}
+
+// batchDelete deletes the keys of the operations. Their tags are deleted along with them.
+func (s *store) batchDelete(ctx context.Context, tx execer, operations []storage.Operation) error {
+	rows := make([][]interface{}, len(operations))
+
+	for i, operation := range operations {
+		rows[i] = []interface{}{operation.Key}
 	}
 
-	_, err := s.db.Exec(query, values...)
+	err := execRows(ctx, tx, "DELETE FROM "+s.tableName+" WHERE `key` IN (", "?", ")", rows)
 	if err != nil {
 		return fmt.Errorf(failureWhileExecutingBatchStatementErrMsg, s.tableName, err)
 	}
@@ -437,28 +644,31 @@ func (s *store) Batch(batch []storage.Operation) error { // nolint:gocyclo
 	return nil
 }
 
-func (s *store) bulkAppendToQuery(b storage.Operation, query *string, values *[]interface{}) error {
-	if len(b.Value) > 0 {
-		value, err := json.Marshal(dbEntry{
-			Value: b.Value,
-			Tags:  b.Tags,
+// batchPut upserts the key-value pairs of the operations and replaces their tags.
+func (s *store) batchPut(ctx context.Context, tx execer, operations []storage.Operation) error {
+	operations = lastOperationPerKey(operations)
+
+	rows := make([][]interface{}, len(operations))
+
+	for i, operation := range operations {
+		entryBytes, err := json.Marshal(dbEntry{
+			Value: operation.Value,
+			Tags:  operation.Tags,
 		})
 		if err != nil {
 			return fmt.Errorf("failed to marshal dbEntry: %w", err)
 		}
 
-		*query += fmt.Sprintf(
-			"INSERT INTO %s (`key`, `value`) VALUES (?, ?) ON DUPLICATE KEY UPDATE value=VALUES(value);\n",
-			s.tableName,
-		)
+		rows[i] = []interface{}{operation.Key, entryBytes}
+	}
 
-		*values = append(*values, b.Key, value)
-	} else {
-		*query += fmt.Sprintf("DELETE FROM %s WHERE `KEY` = ?;\n", s.tableName)
-		*values = append(*values, b.Key)
+	err := execRows(ctx, tx, "INSERT INTO "+s.tableName+" (`key`, `value`) VALUES ", "(?, ?)",
+		" ON DUPLICATE KEY UPDATE `value` = VALUES(`value`)", rows)
+	if err != nil {
+		return fmt.Errorf(failureWhileExecutingBatchStatementErrMsg, s.tableName, err)
 	}
 
-	return nil
+	return s.replaceTags(ctx, tx, operations)
 }
 
 // SQL store doesn't queue values, so there's never anything to flush.
@@ -466,235 +676,552 @@ func (s *store) Flush() error {
 	return nil
 }
 
+// Close removes the store from the open stores of the Provider. The connection pool it shares with the Provider's
+// other stores is closed by Provider.Close.
 func (s *store) Close() error {
 	s.close(s.name)
 
-	err := s.db.Close()
-	if err != nil {
-		return fmt.Errorf(failureWhileClosingMySQLConnection, err)
-	}
-
 	return nil
 }
 
-func (s *store) updateTagMap(key string, tags []storage.Tag) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-
-	tagMap, err := s.getTagMap(true)
-	if err != nil {
-		return fmt.Errorf("failed to get tag map: %w", err)
+func (s *store) getDBEntry(key string) (dbEntry, error) {
+	if key == "" {
+		return dbEntry{}, ErrKeyRequired
 	}
 
-	for _, tag := range tags {
-		if tagMap[tag.Name] == nil {
-			tagMap[tag.Name] = make(map[string]struct{})
+	var retrievedDBEntryBytes []byte
+
+	// select query to fetch the record by key
+	err := s.call(func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, "SELECT `value` FROM "+s.tableName+" "+
+			" WHERE `key` = ?", key).Scan(&retrievedDBEntryBytes)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), valueNotFoundErrMsgFromMySQL) {
+			return dbEntry{}, storage.ErrDataNotFound
 		}
 
-		tagMap[tag.Name][key] = struct{}{}
+		return dbEntry{}, fmt.Errorf(failureWhileQueryingRowErrMsg, err)
 	}
 
-	tagMapBytes, err := json.Marshal(tagMap)
-	if err != nil {
-		return fmt.Errorf("failed to marshal updated tag map: %w", err)
-	}
+	var retrievedDBEntry dbEntry
 
-	err = s.Put(tagMapKey, tagMapBytes)
+	err = json.Unmarshal(retrievedDBEntryBytes, &retrievedDBEntry)
 	if err != nil {
-		return fmt.Errorf("failed to put updated tag map back into the store: %w", err)
+		return dbEntry{}, fmt.Errorf("failed to unmarshaled retrieved DB entry: %w", err)
 	}
 
-	return nil
+	return retrievedDBEntry, nil
 }
 
-func (s *store) getTagMap(createIfDoesNotExist bool) (tagMapping, error) {
-	tagMapBytes, err := s.Get(tagMapKey)
-	if err != nil {
-		if createIfDoesNotExist && errors.Is(err, storage.ErrDataNotFound) {
-			// Create the tag map if it has never been created before.
-			err = s.Put(tagMapKey, []byte("{}"))
-			if err != nil {
-				return nil, fmt.Errorf(`failed to create tag map for "%s": %w`, s.name, err)
-			}
+// replaceTags replaces the tags of the keys of the operations in the tag table.
+// If the same tag name is given more than once for a key, then the last tag value is stored.
+func (s *store) replaceTags(ctx context.Context, tx execer, operations []storage.Operation) error {
+	keyRows := make([][]interface{}, len(operations))
 
-			tagMapBytes = []byte("{}")
-		} else {
-			return nil, fmt.Errorf("failed to get data: %w", err)
-		}
+	var tagRows [][]interface{}
+
+	for i, operation := range operations {
+		keyRows[i] = []interface{}{operation.Key}
+		tagRows = append(tagRows, tagRowsOf(operation.Key, operation.Tags)...)
 	}
 
-	var tagMap tagMapping
+	err := execRows(ctx, tx, "DELETE FROM "+s.tagTableName+" WHERE `key` IN (", "?", ")", keyRows)
+	if err != nil {
+		return fmt.Errorf(failureWhileDeletingTagsErrMsg, s.tagTableName, err)
+	}
 
-	err = json.Unmarshal(tagMapBytes, &tagMap)
+	err = execRows(ctx, tx, "INSERT INTO "+s.tagTableName+" (`key`, `tag_name`, `tag_value`) VALUES ", "(?, ?, ?)",
+		"", tagRows)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal tag map bytes: %w", err)
+		return fmt.Errorf(failureWhileInsertingTagsErrMsg, s.tagTableName, err)
 	}
 
-	return tagMap, nil
+	return nil
 }
 
-func (s *store) getDBEntry(key string) (dbEntry, error) {
-	if key == "" {
-		return dbEntry{}, ErrKeyRequired
-	}
+// prepareFilter converts a query expression into the condition of a WHERE clause matching the entries, from the
+// table aliased as t, that match all of its operands, along with its arguments.
+func (s *store) prepareFilter(expression string) (string, []interface{}, error) {
+	operands := strings.Split(expression, "&&")
+	conditions := make([]string, len(operands))
 
-	var retrievedDBEntryBytes []byte
+	var arguments []interface{}
 
-	// select query to fetch the record by key
-	err := s.db.QueryRow("SELECT `value` FROM "+s.tableName+" "+
-		" WHERE `key` = ?", key).Scan(&retrievedDBEntryBytes)
-	if err != nil {
-		if strings.Contains(err.Error(), valueNotFoundErrMsgFromMySQL) {
-			return dbEntry{}, storage.ErrDataNotFound
+	for i, operand := range operands {
+		tagName, operator, tagValue, ok := splitOperand(operand)
+		if !ok {
+			return "", nil, fmt.Errorf(invalidQueryExpressionFormat, expression)
 		}
 
-		return dbEntry{}, fmt.Errorf(failureWhileQueryingRowErrMsg, err)
-	}
+		var tagCondition string
+
+		switch operator {
+		case "":
+			tagCondition = "tg.`tag_name` = ?"
+			arguments = append(arguments, tagName)
+		case "=":
+			tagCondition = "tg.`tag_name` = ? AND tg.`tag_value` = ?"
+			arguments = append(arguments, tagName, tagValue)
+		default:
+			integerValue, err := strconv.ParseInt(tagValue, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid query format. when using any one of the <=, <, >=, > "+
+					"operators, the immediate value on the right side side must be a valid integer: %w", err)
+			}
 
-	var retrievedDBEntry dbEntry
+			tagCondition = fmt.Sprintf("tg.`tag_name` = ? AND %s %s ?", integerTagValue("tg"), operator)
+			arguments = append(arguments, tagName, integerValue)
+		}
 
-	err = json.Unmarshal(retrievedDBEntryBytes, &retrievedDBEntry)
-	if err != nil {
-		return dbEntry{}, fmt.Errorf("failed to unmarshaled retrieved DB entry: %w", err)
+		conditions[i] = "EXISTS (SELECT 1 FROM " + s.tagTableName + " AS tg WHERE tg.`key` = t.`key` AND " +
+			tagCondition + ")"
 	}
 
-	return retrievedDBEntry, nil
+	return strings.Join(conditions, " AND "), arguments, nil
 }
 
-func (s *store) removeFromTagMap(keyToRemove string) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-
-	tagMap, err := s.getTagMap(false)
-	if err != nil {
-		// If there's no tag map, then this means that tags have never been used. This means that there's no tag map
-		// to update. This isn't a problem.
-		if errors.Is(err, storage.ErrDataNotFound) {
-			return nil
-		}
-
-		return fmt.Errorf("failed to get tag map: %w", err)
+// prepareOrderBy returns the join with the tag table needed to sort by the given tag, if any, along with its
+// arguments, and the ORDER BY clause. MySQL sorts NULL values first in ascending order, so the IS NULL conditions
+// keep the entries without an integer value, or without the tag, last.
+func (s *store) prepareOrderBy(sortOptions *storage.SortOptions) (string, []interface{}, string) {
+	if sortOptions == nil {
+		return "", nil, "t.`key`"
 	}
 
-	for _, tagNameToKeys := range tagMap {
-		delete(tagNameToKeys, keyToRemove)
+	direction := "ASC"
+	if sortOptions.Order == storage.SortDescending {
+		direction = "DESC"
 	}
 
-	tagMapBytes, err := json.Marshal(tagMap)
+	integerValue := integerTagValue("st")
+
+	join := " LEFT JOIN " + s.tagTableName + " AS st ON st.`key` = t.`key` AND st.`tag_name` = ?"
+
+	orderBy := fmt.Sprintf("%s IS NULL, %s %s, st.`tag_value` IS NULL, st.`tag_value` %s, t.`key`",
+		integerValue, integerValue, direction, direction)
+
+	return join, []interface{}{sortOptions.TagName}, orderBy
+}
+
+// queryRows runs a query and calls scan for each row of its result.
+func (s *store) queryRows(ctx context.Context, scan func(rows *sql.Rows) error, query string,
+	arguments ...interface{}) (err error) {
+	rows, err := s.db.QueryContext(ctx, query, arguments...)
 	if err != nil {
-		return fmt.Errorf("failed to marshal updated tag map: %w", err)
+		return err
 	}
 
-	err = s.Put(tagMapKey, tagMapBytes)
-	if err != nil {
-		return fmt.Errorf("failed to put updated tag map back into the store: %w", err)
+	defer func() {
+		errClose := rows.Close()
+		if errClose != nil && err == nil {
+			err = errClose
+		}
+	}()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return rows.Err()
 }
 
-func (s *store) getDatabaseKeysMatchingQuery(expressionTagName, expressionTagValue string) ([]string, error) {
-	tagMap, err := s.getTagMap(false)
-	if err != nil {
-		// If there's no tag map, then this means that tags have never been used, and therefore no matching results.
-		if errors.Is(err, storage.ErrDataNotFound) {
-			return nil, nil
+// The whole transaction is retried after a transient error.
+func (s *store) inTransaction(execute func(ctx context.Context, tx *sql.Tx) error) error {
+	return s.call(func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf(failureWhileBeginningTransactionErrMsg, err)
+		}
+
+		err = execute(ctx, tx)
+		if err != nil {
+			errRollback := tx.Rollback()
+			if errRollback != nil {
+				return fmt.Errorf("%w (failed to roll back transaction: %s)", err, errRollback.Error())
+			}
+
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf(failureWhileCommittingTransactionErrMsg, err)
 		}
 
-		return nil, fmt.Errorf("failed to get tag map: %w", err)
+		return nil
+	})
+}
+
+// migrateTagMap moves the tags of a store created before the tag table was introduced from the tag map, which was
+// stored under the TagMap key, to the tag table. The tag map is deleted afterwards.
+func (s *store) migrateTagMap() error {
+	tagMapEntry, err := s.getDBEntry(tagMapKey)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil
 	}
 
-	if expressionTagValue == "" {
-		return getDatabaseKeysMatchingTagName(tagMap, expressionTagName), nil
+	if err != nil {
+		return fmt.Errorf("failed to get tag map: %w", err)
 	}
 
-	matchingDatabaseKeys, err := s.getDatabaseKeysMatchingTagNameAndValue(tagMap, expressionTagName, expressionTagValue)
+	var tagMap tagMapping
+
+	err = json.Unmarshal(tagMapEntry.Value, &tagMap)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get database keys matching tag name and value: %w", err)
+		return fmt.Errorf("failed to unmarshal tag map bytes: %w", err)
 	}
 
-	return matchingDatabaseKeys, nil
-}
+	migratedKeys := make(map[string]struct{})
+
+	var operations []storage.Operation
 
-func (s *store) getDatabaseKeysMatchingTagNameAndValue(tagMap tagMapping,
-	expressionTagName, expressionTagValue string) ([]string, error) {
-	var matchingDatabaseKeys []string
+	for _, databaseKeysSet := range tagMap {
+		for databaseKey := range databaseKeysSet {
+			if _, done := migratedKeys[databaseKey]; done {
+				continue
+			}
 
-	for tagName, databaseKeysSet := range tagMap {
-		if tagName == expressionTagName {
-			for databaseKey := range databaseKeysSet {
-				tags, err := s.GetTags(databaseKey)
-				if err != nil {
-					return nil, fmt.Errorf("failed to get tags: %w", err)
-				}
+			migratedKeys[databaseKey] = struct{}{}
 
-				for _, tag := range tags {
-					if tag.Name == expressionTagName && tag.Value == expressionTagValue {
-						matchingDatabaseKeys = append(matchingDatabaseKeys, databaseKey)
+			taggedEntry, errGet := s.getDBEntry(databaseKey)
+			if errors.Is(errGet, storage.ErrDataNotFound) {
+				continue
+			}
 
-						break
-					}
-				}
+			if errGet != nil {
+				return fmt.Errorf("failed to get DB entry: %w", errGet)
 			}
 
-			break
+			operations = append(operations, storage.Operation{Key: databaseKey, Tags: taggedEntry.Tags})
 		}
 	}
 
-	return matchingDatabaseKeys, nil
+	return s.inTransaction(func(ctx context.Context, tx *sql.Tx) error {
+		errReplace := s.replaceTags(ctx, tx, operations)
+		if errReplace != nil {
+			return errReplace
+		}
+
+		_, errDelete := tx.ExecContext(ctx, "DELETE FROM "+s.tableName+" WHERE `key` = ?", tagMapKey)
+		if errDelete != nil {
+			return fmt.Errorf("failed to delete tag map: %w", errDelete)
+		}
+
+		return nil
+	})
+}
+
+type entry struct {
+	key   string
+	value []byte
+	tags  []storage.Tag
 }
 
 type iterator struct {
-	keys         []string
-	currentIndex int
-	currentKey   string
-	store        *store
+	store *store
+	// join is the join with the tag table needed to sort the results, if any.
+	join          string
+	joinArguments []interface{}
+	filter        string
+	arguments     []interface{}
+	orderBy       string
+	pageSize      int
+	// offset is the number of results before the next page to fetch.
+	offset   int
+	page     []entry
+	current  int
+	lastPage bool
 }
 
+// Next moves the pointer to the next entry in the iterator, fetching the next page from MySQL if needed.
+// Note that it must be called before accessing the first entry.
+// It returns false if the iterator is exhausted - this is not considered an error.
 func (i *iterator) Next() (bool, error) {
-	if len(i.keys) == i.currentIndex || len(i.keys) == 0 {
-		if len(i.keys) == i.currentIndex || len(i.keys) == 0 {
-			return false, nil
+	if i.current+1 >= len(i.page) && !i.lastPage {
+		err := i.fetchPage()
+		if err != nil {
+			return false, err
 		}
 	}
 
-	i.currentKey = i.keys[i.currentIndex]
+	if i.current+1 >= len(i.page) {
+		return false, nil
+	}
 
-	i.currentIndex++
+	i.current++
 
 	return true, nil
 }
 
 func (i *iterator) Key() (string, error) {
-	return i.currentKey, nil
+	currentEntry, err := i.currentEntry()
+	if err != nil {
+		return "", err
+	}
+
+	return currentEntry.key, nil
 }
 
 func (i *iterator) Value() ([]byte, error) {
-	value, err := i.store.Get(i.currentKey)
+	currentEntry, err := i.currentEntry()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get value from store: %w", err)
+		return nil, err
 	}
 
-	return value, nil
+	return currentEntry.value, nil
 }
 
 func (i *iterator) Tags() ([]storage.Tag, error) {
-	tags, err := i.store.GetTags(i.currentKey)
+	currentEntry, err := i.currentEntry()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get tags from store: %w", err)
+		return nil, err
 	}
 
-	return tags, nil
+	return currentEntry.tags, nil
 }
 
+// TotalItems returns a count of the number of entries matched by the query that generated this iterator.
+// This count is not affected by the page settings used. This runs a separate query on MySQL, so the count
+// reflects the current state of the database, which may have changed since this iterator was created.
 func (i *iterator) TotalItems() (int, error) {
-	return len(i.keys), nil
+	var totalItems int
+
+	err := i.store.call(func(ctx context.Context) error {
+		return i.store.db.QueryRowContext(ctx,
+			fmt.Sprintf("SELECT COUNT(*) FROM %s AS t WHERE %s", i.store.tableName, i.filter),
+			i.arguments...).Scan(&totalItems)
+	})
+	if err != nil {
+		return -1, fmt.Errorf(failureWhileQueryingTableErrMsg, i.store.tableName, err)
+	}
+
+	return totalItems, nil
 }
 
+// Close releases the current page. Since each page is read completely when fetched, no database resources are held.
 func (i *iterator) Close() error {
+	i.page = nil
+	i.current = -1
+	i.lastPage = true
+
+	return nil
+}
+
+// fetchPage reads the next page of results. The iterator points before its first entry.
+func (i *iterator) fetchPage() error {
+	selectStmt := fmt.Sprintf("SELECT t.`key`, t.`value` FROM %s AS t%s WHERE %s ORDER BY %s LIMIT %d OFFSET %d",
+		i.store.tableName, i.join, i.filter, i.orderBy, i.pageSize, i.offset)
+
+	arguments := make([]interface{}, 0, len(i.joinArguments)+len(i.arguments))
+	arguments = append(arguments, i.joinArguments...)
+	arguments = append(arguments, i.arguments...)
+
+	var page []entry
+
+	err := i.store.call(func(ctx context.Context) error {
+		page = make([]entry, 0, i.pageSize)
+
+		return i.store.queryRows(ctx, func(rows *sql.Rows) error {
+			retrievedEntry, errScan := scanEntry(rows)
+			if errScan != nil {
+				return errScan
+			}
+
+			page = append(page, retrievedEntry)
+
+			return nil
+		}, selectStmt, arguments...)
+	})
+	if err != nil {
+		return fmt.Errorf(failureWhileQueryingTableErrMsg, i.store.tableName, err)
+	}
+
+	i.page = page
+	i.current = -1
+	i.lastPage = len(page) < i.pageSize
+	i.offset += len(page)
+
+	return nil
+}
+
+func (i *iterator) currentEntry() (entry, error) {
+	if i.current < 0 || i.current >= len(i.page) {
+		return entry{}, errors.New("iterator has no current entry")
+	}
+
+	return i.page[i.current], nil
+}
+
+// splitOperand splits an operand of a query expression into a tag name, an operator and a tag value.
+// The operator is empty if only a tag name is given, = if a tag value is given after a :, or a range operator.
+func splitOperand(operand string) (tagName, operator, tagValue string, ok bool) {
+	for _, rangeOperator := range []string{"<=", "<", ">=", ">"} {
+		operandSplit := strings.Split(operand, rangeOperator)
+		if len(operandSplit) == rangeExpressionLength {
+			return operandSplit[0], rangeOperator, operandSplit[1], true
+		}
+	}
+
+	operandSplit := strings.Split(operand, ":")
+
+	switch len(operandSplit) {
+	case expressionTagNameOnlyLength:
+		return operandSplit[0], "", "", true
+	case expressionTagNameAndValueLength:
+		return operandSplit[0], "=", operandSplit[1], true
+	default:
+		return "", "", "", false
+	}
+}
+
+// integerTagValue returns the value, as a number, of the tag in the tag table aliased as alias if it is an integer,
+// or NULL otherwise.
+func integerTagValue(alias string) string {
+	return fmt.Sprintf("(CASE WHEN %s.`tag_value` REGEXP '^-?[0-9]{1,%d}$' THEN CAST(%s.`tag_value` AS DECIMAL(%d)) END)",
+		alias, maxIntegerTagValueDigits, alias, maxIntegerTagValueDigits)
+}
+
+// execRows executes a statement made of prefix, followed by one row placeholder per row, separated by commas,
+// followed by suffix. The rows are split across as many statements as needed to stay within the maximum number of
+// parameters of a MySQL statement and within maxStatementSize. Nothing is executed if there are no rows.
+func execRows(ctx context.Context, tx execer, prefix, rowPlaceholder, suffix string, rows [][]interface{}) error {
+	for start := 0; start < len(rows); {
+		end := start + 1
+		parameters := len(rows[start])
+		size := rowSize(rows[start])
+
+		for end < len(rows) && parameters+len(rows[end]) <= maxStatementParameters &&
+			size+rowSize(rows[end]) <= maxStatementSize {
+			parameters += len(rows[end])
+			size += rowSize(rows[end])
+			end++
+		}
+
+		placeholders := make([]string, end-start)
+		values := make([]interface{}, 0, parameters)
+
+		for i, row := range rows[start:end] {
+			placeholders[i] = rowPlaceholder
+			values = append(values, row...)
+		}
+
+		_, err := tx.ExecContext(ctx, prefix+strings.Join(placeholders, ", ")+suffix, values...)
+		if err != nil {
+			return err
+		}
+
+		start = end
+	}
+
+	return nil
+}
+
+// rowSize returns the number of bytes of the strings and byte slices of a row.
+func rowSize(row []interface{}) int {
+	var size int
+
+	for _, value := range row {
+		switch v := value.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		}
+	}
+
+	return size
+}
+
+// tagRowsOf returns the rows of the tag table holding the given tags of the key, keeping the last tag value given for
+// each tag name.
+func tagRowsOf(key string, tags []storage.Tag) [][]interface{} {
+	tagValues := make(map[string]string, len(tags))
+	tagNames := make([]string, 0, len(tags))
+
+	for _, tag := range tags {
+		if _, exists := tagValues[tag.Name]; !exists {
+			tagNames = append(tagNames, tag.Name)
+		}
+
+		tagValues[tag.Name] = tag.Value
+	}
+
+	rows := make([][]interface{}, len(tagNames))
+
+	for i, tagName := range tagNames {
+		rows[i] = []interface{}{key, tagName, tagValues[tagName]}
+	}
+
+	return rows
+}
+
+func validateBatchInput(operations []storage.Operation) error {
+	if len(operations) == 0 {
+		return errors.New("batch requires at least one operation")
+	}
+
+	for _, operation := range operations {
+		if operation.Key == "" {
+			return errors.New("key cannot be empty")
+		}
+
+		err := validateTags(operation.Tags)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// lastOperationPerKey removes the operations that are followed by another operation on the same key.
+func lastOperationPerKey(operations []storage.Operation) []storage.Operation {
+	lastIndexes := make(map[string]int, len(operations))
+
+	for i, operation := range operations {
+		lastIndexes[operation.Key] = i
+	}
+
+	if len(lastIndexes) == len(operations) {
+		return operations
+	}
+
+	remaining := make([]storage.Operation, 0, len(lastIndexes))
+
+	for i, operation := range operations {
+		if lastIndexes[operation.Key] == i {
+			remaining = append(remaining, operation)
+		}
+	}
+
+	return remaining
+}
+
+// scanEntry reads an entry from a row made of the key and value columns of a store's table.
+func scanEntry(rows *sql.Rows) (entry, error) {
+	var (
+		key              string
+		entryBytes       []byte
+		retrievedDBEntry dbEntry
+	)
+
+	err := rows.Scan(&key, &entryBytes)
+	if err != nil {
+		return entry{}, err
+	}
+
+	err = json.Unmarshal(entryBytes, &retrievedDBEntry)
+	if err != nil {
+		return entry{}, fmt.Errorf("failed to unmarshal retrieved DB entry: %w", err)
+	}
+
+	return entry{key: key, value: retrievedDBEntry.Value, tags: retrievedDBEntry.Tags}, nil
+}
+
 func validatePutInput(key string, value []byte, tags []storage.Tag) error {
 	if key == "" {
 		return errors.New("key cannot be empty")
@@ -704,12 +1231,16 @@ func validatePutInput(key string, value []byte, tags []storage.Tag) error {
 		return errors.New("value cannot be nil")
 	}
 
+	return validateTags(tags)
+}
+
+func validateTags(tags []storage.Tag) error {
 	for _, tag := range tags {
-		if strings.Contains(tag.Name, ":") {
+		if strings.ContainsAny(tag.Name, reservedTagCharacters) {
 			return fmt.Errorf(invalidTagName, tag.Name)
 		}
 
-		if strings.Contains(tag.Value, ":") {
+		if strings.ContainsAny(tag.Value, reservedTagCharacters) {
 			return fmt.Errorf(invalidTagValue, tag.Value)
 		}
 	}
@@ -717,43 +1248,81 @@ func validatePutInput(key string, value []byte, tags []storage.Tag) error {
 	return nil
 }
 
-func checkForUnsupportedQueryOptions(options []storage.QueryOption) error {
-	querySettings := getQueryOptions(options)
+func getQueryOptions(options []storage.QueryOption) storage.QueryOptions {
+	var queryOptions storage.QueryOptions
 
-	if querySettings.InitialPageNum != 0 {
-		return errors.New("mySQL provider does not currently support " +
-			"setting the initial page number of query results")
+	for _, option := range options {
+		if option != nil {
+			option(&queryOptions)
+		}
 	}
 
-	if querySettings.SortOptions != nil {
-		return errors.New("mySQL provider does not currently support custom sort options for query results")
+	if queryOptions.PageSize < 1 {
+		queryOptions.PageSize = defaultPageSize
 	}
 
-	return nil
+	if queryOptions.InitialPageNum < 0 {
+		queryOptions.InitialPageNum = 0
+	}
+
+	return queryOptions
 }
 
-func getQueryOptions(options []storage.QueryOption) storage.QueryOptions {
-	var queryOptions storage.QueryOptions
+// callOptions holds the settings that apply to each call to MySQL.
+type callOptions struct {
+	timeout            time.Duration
+	maxRetries         uint64
+	timeBetweenRetries time.Duration
+}
 
-	for _, option := range options {
-		option(&queryOptions)
+// newContext returns a context that is done once the timeout has passed. There is no timeout if it isn't set.
+func (c *callOptions) newContext() (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(context.Background())
 	}
 
-	return queryOptions
+	return context.WithTimeout(context.Background(), c.timeout)
 }
 
-func getDatabaseKeysMatchingTagName(tagMap tagMapping, expressionTagName string) []string {
-	var matchingDatabaseKeys []string
-
-	for tagName, databaseKeysSet := range tagMap {
-		if tagName == expressionTagName {
-			for databaseKey := range databaseKeysSet {
-				matchingDatabaseKeys = append(matchingDatabaseKeys, databaseKey)
-			}
+// call runs operation with a context that is done once the timeout has passed. If operation fails with a transient
+// error, it is run again, with a new context, up to maxRetries times.
+func (c *callOptions) call(operation func(ctx context.Context) error) error {
+	return backoff.Retry(func() error {
+		ctx, cancel := c.newContext()
+		defer cancel()
 
-			break
+		err := operation(ctx)
+		if err != nil && !isTransientError(err) {
+			return backoff.Permanent(err)
 		}
+
+		return err
+	}, backoff.WithMaxRetries(backoff.NewConstantBackOff(c.timeBetweenRetries), c.maxRetries))
+}
+
+// isTransientError checks whether an error may not happen again if the call that caused it is retried.
+func isTransientError(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == lockWaitTimeoutErrorNumber || mysqlErr.Number == deadlockErrorNumber
 	}
 
-	return matchingDatabaseKeys
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysqldriver.ErrInvalidConn)
+}
+
+func setOptions(opts []Option, p *Provider) {
+	// Zero is a valid number of retries, so the default is replaced by the option rather than replacing zero.
+	p.maxRetries = defaultMaxRetries
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.timeout == 0 {
+		p.timeout = defaultTimeout
+	}
+
+	if p.timeBetweenRetries == 0 {
+		p.timeBetweenRetries = defaultTimeBetweenRetries
+	}
 }
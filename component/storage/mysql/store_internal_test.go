@@ -7,9 +7,14 @@ SPDX-License-Identifier: Apache-2.0
 package mysql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"testing"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,3 +29,66 @@ func TestStore_OpenStore_Internal(t *testing.T) {
 		"Access denied for user 'root'@'172.17.0.1' (using password: YES)")
 	require.Nil(t, store)
 }
+
+func TestIsTransientError(t *testing.T) {
+	require.True(t, isTransientError(&mysqldriver.MySQLError{Number: deadlockErrorNumber}))
+	require.True(t, isTransientError(fmt.Errorf("failure while beginning transaction: %w",
+		&mysqldriver.MySQLError{Number: lockWaitTimeoutErrorNumber})))
+	require.True(t, isTransientError(driver.ErrBadConn))
+	require.True(t, isTransientError(mysqldriver.ErrInvalidConn))
+
+	require.False(t, isTransientError(&mysqldriver.MySQLError{Number: 1045}))
+	require.False(t, isTransientError(errors.New("some error")))
+}
+
+func TestSetOptions(t *testing.T) {
+	t.Run("Default retries", func(t *testing.T) {
+		p := &Provider{}
+		setOptions(nil, p)
+
+		require.Equal(t, uint64(defaultMaxRetries), p.maxRetries)
+	})
+	t.Run("Retries disabled", func(t *testing.T) {
+		p := &Provider{}
+		setOptions([]Option{WithMaxRetries(0)}, p)
+
+		require.Zero(t, p.maxRetries)
+
+		var attempts int
+
+		err := p.call(func(context.Context) error {
+			attempts++
+
+			return driver.ErrBadConn
+		})
+		require.Equal(t, driver.ErrBadConn, err)
+		require.Equal(t, 1, attempts)
+	})
+}
+
+func TestCallOptions_Call(t *testing.T) {
+	options := callOptions{maxRetries: 2}
+
+	t.Run("Transient errors are retried", func(t *testing.T) {
+		var attempts int
+
+		err := options.call(func(context.Context) error {
+			attempts++
+
+			return driver.ErrBadConn
+		})
+		require.Equal(t, driver.ErrBadConn, err)
+		require.Equal(t, 3, attempts)
+	})
+	t.Run("Other errors are not retried", func(t *testing.T) {
+		var attempts int
+
+		err := options.call(func(context.Context) error {
+			attempts++
+
+			return errors.New("some error")
+		})
+		require.EqualError(t, err, "some error")
+		require.Equal(t, 1, attempts)
+	})
+}
@@ -6,11 +6,15 @@ SPDX-License-Identifier: Apache-2.0
 package mysql_test
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,7 +38,7 @@ func (*mysqlLogger) Print(...interface{}) {}
 const (
 	dockerMySQLImage = "mysql"
 	dockerMySQLTag   = "8.0.20"
-	sqlStoreDBURL    = "root:my-secret-pw@tcp(127.0.0.1:3301)/?interpolateParams=true&multiStatements=true"
+	sqlStoreDBURL    = "root:my-secret-pw@tcp(127.0.0.1:3301)/"
 )
 
 func TestMain(m *testing.M) {
@@ -163,6 +167,29 @@ func TestSQLDBStore(t *testing.T) {
 		err = prov.Close()
 		require.NoError(t, err)
 	})
+	t.Run("Stores share the connection pool of the provider", func(t *testing.T) {
+		prov, err := NewProvider(sqlStoreDBURL)
+		require.NoError(t, err)
+
+		store1, err := prov.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		store2, err := prov.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		// Closing a store must not close the connection pool shared with the other stores.
+		err = store1.Close()
+		require.NoError(t, err)
+
+		err = store2.Put("key", []byte("value"))
+		require.NoError(t, err)
+
+		err = prov.Close()
+		require.NoError(t, err)
+
+		_, err = store2.Get("key")
+		require.EqualError(t, err, "failed to get DB entry: failure while querying row: sql: database is closed")
+	})
 	t.Run("Flush", func(t *testing.T) {
 		prov, err := NewProvider(sqlStoreDBURL)
 		require.NoError(t, err)
@@ -183,12 +210,6 @@ func TestNotImplementedMethods(t *testing.T) {
 		require.Panics(t, func() {
 			prov.GetOpenStores()
 		})
-
-		store, err := prov.OpenStore("storename")
-		require.NoError(t, err)
-
-		_, err = store.GetBulk()
-		require.EqualError(t, err, "not implemented")
 	})
 }
 
@@ -210,69 +231,198 @@ func TestSqlDBProvider_GetStoreConfig(t *testing.T) {
 	})
 }
 
+func TestSqlDBStore_GetBulk(t *testing.T) {
+	t.Run("Values are returned in the order of the keys", func(t *testing.T) {
+		testStore := newStore(t, randomStoreName())
+
+		err := testStore.Put("key1", []byte("value1"))
+		require.NoError(t, err)
+
+		err = testStore.Put("key2", []byte(`{"field":"value2"}`))
+		require.NoError(t, err)
+
+		values, err := testStore.GetBulk("key2", "missingKey", "key1", "key2")
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte(`{"field":"value2"}`), nil, []byte("value1"), []byte(`{"field":"value2"}`)},
+			values)
+	})
+	t.Run("Invalid keys", func(t *testing.T) {
+		testStore := newStore(t, randomStoreName())
+
+		values, err := testStore.GetBulk()
+		require.EqualError(t, err, "keys slice must contain at least one key")
+		require.Nil(t, values)
+
+		values, err = testStore.GetBulk("key1", "")
+		require.EqualError(t, err, "key cannot be empty")
+		require.Nil(t, values)
+	})
+}
+
 func TestSqlDBStore_Put(t *testing.T) {
-	t.Run("Fail to update tag map since the DB connection was closed", func(t *testing.T) {
+	t.Run("Fail to put since the DB connection was closed", func(t *testing.T) {
 		provider, err := NewProvider(sqlStoreDBURL)
 		require.NoError(t, err)
 
 		testStore, err := provider.OpenStore(randomStoreName())
 		require.NoError(t, err)
 
-		err = testStore.Close()
+		err = provider.Close()
 		require.NoError(t, err)
 
 		err = testStore.Put("key", []byte("value"), storage.Tag{})
-		require.EqualError(t, err, "failed to update tag map: failed to get tag map: failed to get data: "+
-			"failed to get DB entry: failure while querying row: sql: database is closed")
+		require.EqualError(t, err, "failure while beginning transaction: sql: database is closed")
 	})
-	t.Run("Fail to unmarshal tag map bytes", func(t *testing.T) {
-		provider, err := NewProvider(sqlStoreDBURL)
+	t.Run("Tags with reserved characters", func(t *testing.T) {
+		testStore := newStore(t, randomStoreName())
+
+		err := testStore.Put("key", []byte("value"), storage.Tag{Name: "tag<Name"})
+		require.EqualError(t, err, `"tag<Name" is an invalid tag name since it contains one or more of the `+
+			`following substrings: ":", "<=", "<", ">=", ">"`)
+
+		err = testStore.Put("key", []byte("value"), storage.Tag{Name: "tagName", Value: "tag>Value"})
+		require.EqualError(t, err, `"tag>Value" is an invalid tag value since it contains one or more of the `+
+			`following substrings: ":", "<=", "<", ">=", ">"`)
+	})
+	t.Run("Tags are replaced when a key is overwritten", func(t *testing.T) {
+		testStore := newStore(t, randomStoreName())
+
+		err := testStore.Put("key", []byte("value"),
+			storage.Tag{Name: "tagName1", Value: "tagValue1"}, storage.Tag{Name: "tagName2", Value: "tagValue2"})
 		require.NoError(t, err)
 
-		testStore, err := provider.OpenStore(randomStoreName())
+		err = testStore.Put("key", []byte("value"), storage.Tag{Name: "tagName2", Value: "newTagValue2"})
 		require.NoError(t, err)
 
-		err = testStore.Put("TagMap", []byte("Not a proper tag map"))
+		verifyQueryKeys(t, testStore, "tagName1")
+		verifyQueryKeys(t, testStore, "tagName2:tagValue2")
+		verifyQueryKeys(t, testStore, "tagName2:newTagValue2", "key")
+
+		err = testStore.Delete("key")
 		require.NoError(t, err)
 
-		err = testStore.Put("key", []byte("value"), storage.Tag{})
-		require.EqualError(t, err, "failed to update tag map: failed to get tag map: "+
-			"failed to unmarshal tag map bytes: invalid character 'N' looking for beginning of value")
+		verifyQueryKeys(t, testStore, "tagName2")
+	})
+	t.Run("Store named tags", func(t *testing.T) {
+		testStore := newStore(t, "tags")
+
+		err := testStore.Put("key", []byte("value"), storage.Tag{Name: "tagName1", Value: "tagValue1"})
+		require.NoError(t, err)
+
+		value, err := testStore.Get("key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+
+		verifyQueryKeys(t, testStore, "tagName1:tagValue1", "key")
+
+		err = testStore.Delete("key")
+		require.NoError(t, err)
+
+		verifyQueryKeys(t, testStore, "tagName1")
 	})
 }
 
 func TestSqlDBStore_Query(t *testing.T) {
-	t.Run("Fail to get tag map since the DB connection was closed", func(t *testing.T) {
+	t.Run("Fail to query tags since the DB connection was closed", func(t *testing.T) {
 		provider, err := NewProvider(sqlStoreDBURL)
 		require.NoError(t, err)
 
-		testStore, err := provider.OpenStore(randomStoreName())
+		storeName := randomStoreName()
+
+		testStore, err := provider.OpenStore(storeName)
 		require.NoError(t, err)
 
-		err = testStore.Close()
+		err = provider.Close()
 		require.NoError(t, err)
 
 		itr, err := testStore.Query("expression")
-		require.EqualError(t, err, "failed to get database keys matching query: failed to get tag map: "+
-			"failed to get data: failed to get DB entry: failure while querying row: sql: database is closed")
+		require.EqualError(t, err, fmt.Sprintf(
+			"failure while querying table `%s`.`%s`: sql: database is closed", storeName, storeName))
 		require.Nil(t, itr)
 	})
-	t.Run("Not supported options", func(t *testing.T) {
-		provider, err := NewProvider(sqlStoreDBURL)
+	t.Run("Compound and range queries", func(t *testing.T) {
+		testStore := newStore(t, randomStoreName())
+
+		entries := map[string][]storage.Tag{
+			"key1": {{Name: "type", Value: "a"}, {Name: "count", Value: "1"}},
+			"key2": {{Name: "type", Value: "a"}, {Name: "count", Value: "20"}},
+			"key3": {{Name: "type", Value: "b"}, {Name: "count", Value: "-5"}},
+			"key4": {{Name: "type", Value: "a"}, {Name: "count", Value: "ten"}},
+			"key5": {{Name: "type", Value: "a"}},
+		}
+
+		for key, tags := range entries {
+			err := testStore.Put(key, []byte("value"), tags...)
+			require.NoError(t, err)
+		}
+
+		verifyQueryKeys(t, testStore, "type:a&&count", "key1", "key2", "key4")
+		verifyQueryKeys(t, testStore, "type:a&&count:20", "key2")
+		verifyQueryKeys(t, testStore, "type:b&&count:20")
+		verifyQueryKeys(t, testStore, "count<1", "key3")
+		verifyQueryKeys(t, testStore, "count<=1", "key1", "key3")
+		verifyQueryKeys(t, testStore, "count>1", "key2")
+		verifyQueryKeys(t, testStore, "count>=-5", "key1", "key2", "key3")
+		verifyQueryKeys(t, testStore, "type:a&&count>=1&&count<20", "key1")
+	})
+	t.Run("Invalid expressions", func(t *testing.T) {
+		testStore := newStore(t, randomStoreName())
+
+		for _, expression := range []string{"", "name:value:value", "type:a&&name:value:value"} {
+			iterator, err := testStore.Query(expression)
+			require.EqualError(t, err, fmt.Sprintf(`"%s" is not in a valid expression format. `+
+				"it must be in the following format: TagName:TagValue or TagName1:TagValue1&&TagName2:TagValue2. "+
+				"Tag values are optional. If using tag values, <=, <, >=, or > may be used in place of the : "+
+				"to match a range of tag values", expression))
+			require.Nil(t, iterator)
+		}
+
+		iterator, err := testStore.Query("count<ten")
+		require.EqualError(t, err, "invalid query format. when using any one of the <=, <, >=, > operators, "+
+			`the immediate value on the right side side must be a valid integer: strconv.ParseInt: parsing "ten": `+
+			"invalid syntax")
+		require.Nil(t, iterator)
+	})
+	t.Run("Paging and sorting", func(t *testing.T) {
+		testStore := newStore(t, randomStoreName())
+
+		tagValues := map[string]string{
+			"key1": "10", "key2": "9", "key3": "-1", "key4": "b", "key5": "a", "key6": "9",
+		}
+
+		for key, tagValue := range tagValues {
+			err := testStore.Put(key, []byte("value"),
+				storage.Tag{Name: "tagName1"}, storage.Tag{Name: "sortTag", Value: tagValue})
+			require.NoError(t, err)
+		}
+
+		err := testStore.Put("key7", []byte("value"), storage.Tag{Name: "tagName1"})
 		require.NoError(t, err)
 
-		store, err := provider.OpenStore("TestStore")
+		// Without sort options, results are sorted by key.
+		iterator, err := testStore.Query("tagName1", storage.WithPageSize(2))
 		require.NoError(t, err)
+		verifyIteratorKeys(t, iterator, "key1", "key2", "key3", "key4", "key5", "key6", "key7")
 
-		iterator, err := store.Query("TagName:TagValue", storage.WithInitialPageNum(1))
-		require.EqualError(t, err, "mySQL provider does not currently support "+
-			"setting the initial page number of query results")
-		require.Nil(t, iterator)
+		// Integer values come first, sorted numerically, then other values, then entries without the tag.
+		iterator, err = testStore.Query("tagName1", storage.WithPageSize(2),
+			storage.WithSortOrder(&storage.SortOptions{Order: storage.SortAscending, TagName: "sortTag"}))
+		require.NoError(t, err)
+		verifyIteratorKeys(t, iterator, "key3", "key2", "key6", "key1", "key5", "key4", "key7")
 
-		iterator, err = store.Query("TagName:TagValue", storage.WithSortOrder(&storage.SortOptions{}))
-		require.EqualError(t, err, "mySQL provider does not currently support custom sort options for "+
-			"query results")
-		require.Nil(t, iterator)
+		iterator, err = testStore.Query("tagName1", storage.WithPageSize(2),
+			storage.WithSortOrder(&storage.SortOptions{Order: storage.SortDescending, TagName: "sortTag"}))
+		require.NoError(t, err)
+		verifyIteratorKeys(t, iterator, "key1", "key2", "key6", "key3", "key4", "key5", "key7")
+
+		iterator, err = testStore.Query("tagName1", storage.WithPageSize(2), storage.WithInitialPageNum(1),
+			storage.WithSortOrder(&storage.SortOptions{Order: storage.SortAscending, TagName: "sortTag"}))
+		require.NoError(t, err)
+		verifyIteratorKeys(t, iterator, "key6", "key1", "key5", "key4", "key7")
+
+		totalItems, err := iterator.TotalItems()
+		require.NoError(t, err)
+		require.Equal(t, 7, totalItems)
 	})
 }
 
@@ -291,14 +441,14 @@ func TestSqlDBIterator(t *testing.T) {
 	itr, err := testStore.Query("expression")
 	require.NoError(t, err)
 
-	t.Run("Fail to get value from store", func(t *testing.T) {
+	t.Run("Fail to get value before calling Next", func(t *testing.T) {
 		value, errValue := itr.Value()
-		require.EqualError(t, errValue, "failed to get value from store: failed to get DB entry: key is mandatory")
+		require.EqualError(t, errValue, "iterator has no current entry")
 		require.Nil(t, value)
 	})
-	t.Run("Fail to get tags from store", func(t *testing.T) {
+	t.Run("Fail to get tags before calling Next", func(t *testing.T) {
 		tags, errGetTags := itr.Tags()
-		require.EqualError(t, errGetTags, "failed to get tags from store: failed to get DB entry: key is mandatory")
+		require.EqualError(t, errGetTags, "iterator has no current entry")
 		require.Nil(t, tags)
 	})
 }
@@ -311,11 +461,13 @@ func TestSqlDBStore_Common(t *testing.T) {
 		commontest.TestProviderOpenStoreSetGetConfig(t, provider)
 		commontest.TestPutGet(t, provider)
 		commontest.TestStoreGetTags(t, provider)
+		commontest.TestStoreGetBulk(t, provider)
 		commontest.TestStoreQuery(t, provider)
+		commontest.TestStoreQueryWithSortingAndInitialPageOptions(t, provider)
 		commontest.TestStoreDelete(t, provider)
 		commontest.TestStoreClose(t, provider)
-		commontest.TestProviderClose(t, provider)
 		commontest.TestStoreBatch(t, provider)
+		commontest.TestProviderClose(t, provider)
 	})
 	t.Run("With prefix", func(t *testing.T) {
 		provider, err := NewProvider(sqlStoreDBURL, WithDBPrefix("db-prefix-"))
@@ -324,11 +476,46 @@ func TestSqlDBStore_Common(t *testing.T) {
 		commontest.TestProviderOpenStoreSetGetConfig(t, provider)
 		commontest.TestPutGet(t, provider)
 		commontest.TestStoreGetTags(t, provider)
+		commontest.TestStoreGetBulk(t, provider)
 		commontest.TestStoreQuery(t, provider)
+		commontest.TestStoreQueryWithSortingAndInitialPageOptions(t, provider)
 		commontest.TestStoreDelete(t, provider)
 		commontest.TestStoreClose(t, provider)
+		commontest.TestStoreBatch(t, provider)
 		commontest.TestProviderClose(t, provider)
+	})
+	t.Run("With connection pool, timeout and retry options", func(t *testing.T) {
+		provider, err := NewProvider(sqlStoreDBURL, WithMaxOpenConnections(5), WithMaxIdleConnections(2),
+			WithConnectionMaxLifetime(time.Minute), WithTimeout(5*time.Second), WithMaxRetries(2),
+			WithTimeBetweenRetries(100*time.Millisecond))
+		require.NoError(t, err)
+
+		commontest.TestProviderOpenStoreSetGetConfig(t, provider)
+		commontest.TestPutGet(t, provider)
+		commontest.TestStoreGetTags(t, provider)
+		commontest.TestStoreGetBulk(t, provider)
+		commontest.TestStoreQuery(t, provider)
+		commontest.TestStoreQueryWithSortingAndInitialPageOptions(t, provider)
+		commontest.TestStoreDelete(t, provider)
+		commontest.TestStoreClose(t, provider)
 		commontest.TestStoreBatch(t, provider)
+		commontest.TestProviderClose(t, provider)
+	})
+}
+
+func TestSqlDBProvider_Ping(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		provider, err := NewProvider(sqlStoreDBURL)
+		require.NoError(t, err)
+
+		require.NoError(t, provider.Ping())
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		provider, err := NewProvider(sqlStoreDBURL, WithTimeout(time.Nanosecond))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failure while pinging MySQL")
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Nil(t, provider)
 	})
 }
 
@@ -340,95 +527,133 @@ func TestSqlDBStore_Batch(t *testing.T) {
 		require.Equal(t, err.Error(), "key cannot be empty")
 	})
 
-	t.Run("error removing key from tagMap", func(t *testing.T) {
-		storeName := randomStoreName()
-		s := newStore(t, storeName)
+	t.Run("Invalid input", func(t *testing.T) {
+		s := newStore(t, randomStoreName())
 
-		db, err := sql.Open("mysql", sqlStoreDBURL)
-		require.NoError(t, err)
+		err := s.Batch(nil)
+		require.EqualError(t, err, "batch requires at least one operation")
+
+		err = s.Batch([]storage.Operation{
+			{Key: "key1", Value: []byte("value1"), Tags: []storage.Tag{{Name: "tag:Name"}}},
+		})
+		require.EqualError(t, err, `"tag:Name" is an invalid tag name since it contains one or more of the `+
+			`following substrings: ":", "<=", "<", ">=", ">"`)
+	})
+	t.Run("Failed batch is rolled back", func(t *testing.T) {
+		s := newStore(t, randomStoreName())
 
-		_, err = db.Exec(
-			fmt.Sprintf("INSERT INTO `%s`.`%s` VALUES (?,?)", storeName, storeName),
-			"TagMap", []byte("{"),
-		)
+		err := s.Put("key0", []byte("value0"), storage.Tag{Name: "tagName1"})
 		require.NoError(t, err)
 
-		err = s.Batch([]storage.Operation{{
-			Key:   "test",
-			Value: nil,
-			Tags: []storage.Tag{{
-				Name:  "test",
-				Value: "value",
-			}},
-		}})
+		// The last key is too long for the key column, which fails the batch after the first operations succeeded.
+		err = s.Batch([]storage.Operation{
+			{Key: "key1", Value: []byte("value1"), Tags: []storage.Tag{{Name: "tagName1"}}},
+			{Key: "key0"},
+			{Key: strings.Repeat("k", 300), Value: []byte("value2")},
+		})
 		require.Error(t, err)
-		require.Contains(t, err.Error(), "failed to remove key from tag map")
+
+		value, err := s.Get("key1")
+		require.True(t, errors.Is(err, storage.ErrDataNotFound), "unexpected error or no error")
+		require.Nil(t, value)
+
+		value, err = s.Get("key0")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value0"), value)
+
+		verifyQueryKeys(t, s, "tagName1", "key0")
 	})
+	t.Run("Large batch is split into several statements", func(t *testing.T) {
+		s := newStore(t, randomStoreName())
 
-	t.Run("error removing key from tagMap", func(t *testing.T) {
-		storeName := randomStoreName()
-		s := newStore(t, storeName)
+		const numberOfOperations = 40000
 
-		db, err := sql.Open("mysql", sqlStoreDBURL)
+		operations := make([]storage.Operation, numberOfOperations)
+
+		for i := range operations {
+			operations[i] = storage.Operation{
+				Key:   fmt.Sprintf("key%05d", i),
+				Value: []byte(fmt.Sprintf("value%d", i)),
+				Tags:  []storage.Tag{{Name: "tagName1", Value: strconv.Itoa(i)}},
+			}
+		}
+
+		err := s.Batch(operations)
 		require.NoError(t, err)
 
-		_, err = db.Exec(
-			fmt.Sprintf("INSERT INTO `%s`.`%s` VALUES (?,?)", storeName, storeName),
-			"TagMap", []byte("{"),
-		)
+		values, err := s.GetBulk("key00000", "key32766", "key32767", "key39999")
 		require.NoError(t, err)
+		require.Equal(t, [][]byte{
+			[]byte("value0"), []byte("value32766"), []byte("value32767"), []byte("value39999"),
+		}, values)
 
-		err = s.Batch([]storage.Operation{{
-			Key:   "test",
-			Value: []byte("value"),
-			Tags: []storage.Tag{{
-				Name:  "test",
-				Value: "value",
-			}},
-		}})
-		require.Error(t, err)
-		require.Contains(t, err.Error(), "failed to update tag map")
+		iterator, err := s.Query("tagName1>=21845")
+		require.NoError(t, err)
+
+		totalItems, err := iterator.TotalItems()
+		require.NoError(t, err)
+		require.Equal(t, numberOfOperations-21845, totalItems)
+	})
+	t.Run("Tags are updated by the operations", func(t *testing.T) {
+		s := newStore(t, randomStoreName())
+
+		err := s.Put("key1", []byte("value1"), storage.Tag{Name: "tagName1", Value: "tagValue1"})
+		require.NoError(t, err)
+
+		err = s.Batch([]storage.Operation{
+			{Key: "key1"},
+			{Key: "key2", Value: []byte("value2"), Tags: []storage.Tag{{Name: "tagName1", Value: "tagValue1"}}},
+			{Key: "key3", Value: []byte("value3"), Tags: []storage.Tag{{Name: "tagName1", Value: "tagValue3"}}},
+			{Key: "key3", Value: []byte("value3"), Tags: []storage.Tag{{Name: "tagName2", Value: "tagValue3"}}},
+		})
+		require.NoError(t, err)
+
+		verifyQueryKeys(t, s, "tagName1", "key2")
+		verifyQueryKeys(t, s, "tagName2:tagValue3", "key3")
 	})
 }
 
-func TestEnsureTagMapIsOnlyCreatedWhenNeeded(t *testing.T) {
-	provider, err := NewProvider(sqlStoreDBURL)
-	require.NoError(t, err)
+func TestTagMapMigration(t *testing.T) {
+	storeName := randomStoreName()
 
-	// We defer creating the tag map entry until we actually have to. This saves on space if a client does not need
-	// to use tags + querying. The only thing that should cause the tag map entry to be created is if a Put is done
-	// with tags.
+	newStore(t, storeName)
 
-	testStore, err := provider.OpenStore("TestStore")
+	db, err := sql.Open("mysql", sqlStoreDBURL)
 	require.NoError(t, err)
 
-	err = provider.SetStoreConfig("TestStore", storage.StoreConfiguration{TagNames: []string{"TagName1"}})
-	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
 
-	value, err := testStore.Get("TagMap")
-	require.True(t, errors.Is(err, storage.ErrDataNotFound), "unexpected error or no error")
-	require.Nil(t, value)
+	// Before the tag table was introduced, tags were mapped to keys by a JSON value stored under the TagMap key.
+	type dbEntry struct {
+		Value []byte        `json:"value,omitempty"`
+		Tags  []storage.Tag `json:"tags,omitempty"`
+	}
 
-	err = testStore.Put("Key", []byte("value"))
-	require.NoError(t, err)
+	entries := map[string]dbEntry{
+		"key1":   {Value: []byte("value1"), Tags: []storage.Tag{{Name: "tagName1", Value: "tagValue1"}}},
+		"key2":   {Value: []byte("value2"), Tags: []storage.Tag{{Name: "tagName1", Value: "tagValue2"}}},
+		"TagMap": {Value: []byte(`{"tagName1":{"key1":{},"key2":{},"deletedKey":{}}}`)},
+	}
 
-	value, err = testStore.Get("TagMap")
-	require.True(t, errors.Is(err, storage.ErrDataNotFound))
-	require.Nil(t, value)
+	for key, entry := range entries {
+		entryBytes, errMarshal := json.Marshal(entry)
+		require.NoError(t, errMarshal)
 
-	err = testStore.Delete("Key")
-	require.NoError(t, err)
+		_, err = db.Exec(fmt.Sprintf("INSERT INTO `%s`.`%s` VALUES (?, ?)", storeName, storeName), key, entryBytes)
+		require.NoError(t, err)
+	}
 
-	value, err = testStore.Get("TagMap")
-	require.True(t, errors.Is(err, storage.ErrDataNotFound), "unexpected error or no error")
-	require.Nil(t, value)
+	// The tag map is migrated when a provider opens the store for the first time.
+	s := newStore(t, storeName)
 
-	err = testStore.Put("Key", []byte("value"), storage.Tag{Name: "TagName1"})
-	require.NoError(t, err)
+	verifyQueryKeys(t, s, "tagName1", "key1", "key2")
+	verifyQueryKeys(t, s, "tagName1:tagValue2", "key2")
 
-	value, err = testStore.Get("TagMap")
-	require.NoError(t, err)
-	require.Equal(t, `{"TagName1":{"Key":{}}}`, string(value))
+	value, err := s.Get("TagMap")
+	require.True(t, errors.Is(err, storage.ErrDataNotFound), "unexpected error or no error")
+	require.Nil(t, value)
 }
 
 func TestStoreLargeData(t *testing.T) {
@@ -447,6 +672,37 @@ func randomStoreName() string {
 	return "store-" + uuid.New().String()
 }
 
+func verifyQueryKeys(t *testing.T, s storage.Store, expression string, expectedKeys ...string) {
+	t.Helper()
+
+	iterator, err := s.Query(expression)
+	require.NoError(t, err)
+
+	verifyIteratorKeys(t, iterator, expectedKeys...)
+}
+
+func verifyIteratorKeys(t *testing.T, iterator storage.Iterator, expectedKeys ...string) {
+	t.Helper()
+
+	var keys []string
+
+	for {
+		more, errNext := iterator.Next()
+		require.NoError(t, errNext)
+
+		if !more {
+			break
+		}
+
+		key, errKey := iterator.Key()
+		require.NoError(t, errKey)
+
+		keys = append(keys, key)
+	}
+
+	require.Equal(t, expectedKeys, keys)
+}
+
 func newStore(t *testing.T, name string) storage.Store {
 	t.Helper()
 
@@ -0,0 +1,277 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package postgresql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// notifyFunction is the trigger function that notifies changes to the tables of stores.
+	notifyFunction = "aries_notify_change"
+	// maxIdentifierLength is the maximum length, in bytes, of a PostgreSQL identifier such as a channel name.
+	maxIdentifierLength = 63
+)
+
+// Operation is the kind of change made to a key of a store.
+type Operation string
+
+const (
+	// OperationPut means that a key was stored or overwritten.
+	OperationPut Operation = "put"
+	// OperationDelete means that a key was deleted.
+	OperationDelete Operation = "delete"
+)
+
+// Change is a change made to a key of a store.
+type Change struct {
+	Key       string
+	Operation Operation
+	// Tags are the tags of the key after a put and before a delete. They are nil if they were too large to fit in a
+	// PostgreSQL notification, in which case they can be retrieved with GetTags.
+	Tags []storage.Tag
+}
+
+// ChangeSubscriber is implemented by the stores of a Provider created with WithChangeNotifications.
+// Use a type assertion on a store returned by OpenStore to get it.
+type ChangeSubscriber interface {
+	// Subscribe streams the changes made to the store, by this or any other process, from now on until ctx is done.
+	Subscribe(ctx context.Context) (<-chan Change, error)
+}
+
+// WithChangeNotifications is an option for notifying changes to stores. When it is used, OpenStore adds a trigger
+// to the table of the store that sends a PostgreSQL notification whenever a key is put or deleted, and stores
+// implement ChangeSubscriber. Notifications are sent when the transaction making the change commits.
+// Changes to keys of nearly 8000 bytes or more aren't notified, since they don't fit in a notification.
+func WithChangeNotifications() Option {
+	return func(opts *Provider) {
+		opts.notifyChanges = true
+	}
+}
+
+// Subscribe streams the changes made to the store, by this or any other process, from now on. The returned channel
+// is closed once ctx is done, the store is closed or the connection to PostgreSQL is lost. Each subscription holds
+// a connection from the connection pool, so the channel must be drained to avoid changes queueing up in PostgreSQL.
+// Subscribe returns an error if the Provider wasn't created with WithChangeNotifications.
+func (s *store) Subscribe(ctx context.Context) (<-chan Change, error) {
+	if !s.notifyChanges {
+		return nil, errors.New("change notifications are not enabled")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	select {
+	case <-s.closed:
+		return nil, errors.New("store is closed")
+	default:
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	conn, err := s.connectionPoolToDatabase.Acquire(ctxWithTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	_, err = conn.Exec(ctxWithTimeout, "LISTEN "+pgx.Identifier{s.channel}.Sanitize())
+	if err != nil {
+		s.release(conn)
+
+		return nil, fmt.Errorf("failed to listen for changes: %w", err)
+	}
+
+	changes := make(chan Change)
+
+	s.subscriptions.Add(1)
+
+	go s.forwardChanges(ctx, conn, changes)
+
+	return changes, nil
+}
+
+// forwardChanges sends the changes notified on the connection to the channel until ctx is done, the store is closed
+// or the connection is lost.
+func (s *store) forwardChanges(ctx context.Context, conn *pgxpool.Conn, changes chan<- Change) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	defer func() {
+		cancel()
+		s.release(conn)
+		close(changes)
+		s.subscriptions.Done()
+	}()
+
+	go func() {
+		select {
+		case <-s.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return
+		}
+
+		change, err := parseChange(notification.Payload)
+		if err != nil {
+			continue
+		}
+
+		select {
+		case changes <- change:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// release returns the connection of a subscription to the pool. If it can't stop listening, then it is closed, so
+// that the pool discards it rather than handing out a connection that receives notifications.
+func (s *store) release(conn *pgxpool.Conn) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := conn.Exec(ctxWithTimeout, "UNLISTEN *")
+	if err != nil {
+		conn.Conn().Close(ctxWithTimeout) //nolint:errcheck // The connection is discarded either way.
+	}
+
+	conn.Release()
+}
+
+// stopSubscriptions ends all subscriptions to the store and waits for their connections to be released.
+func (s *store) stopSubscriptions() {
+	s.lock.Lock()
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	s.lock.Unlock()
+
+	s.subscriptions.Wait()
+}
+
+// createChangeTrigger creates the trigger that notifies changes to the table of a store on the store's channel.
+// The trigger function is shared by all stores in the same database and schema.
+func (p *Provider) createChangeTrigger(newStore *store) error {
+	function := pgx.Identifier{notifyFunction}
+	if p.schema != "" {
+		function = pgx.Identifier{p.schema, notifyFunction}
+	}
+
+	// A notification payload must be shorter than 8000 bytes. Tags are left out of the payload if they don't fit.
+	createFunctionStmt := fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$
+DECLARE
+	changed record;
+	operation text := 'put';
+	payload text;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		changed := OLD;
+		operation := 'delete';
+	ELSE
+		changed := NEW;
+	END IF;
+
+	payload := json_build_object('key', changed.key, 'operation', operation, 'tags', changed.tags)::text;
+
+	IF octet_length(payload) >= 8000 THEN
+		payload := json_build_object('key', changed.key, 'operation', operation)::text;
+	END IF;
+
+	IF octet_length(payload) < 8000 THEN
+		PERFORM pg_notify(TG_ARGV[0], payload);
+	END IF;
+
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`, function.Sanitize())
+
+	trigger := pgx.Identifier{newStore.name + "_changes"}.Sanitize()
+
+	dropTriggerStmt := fmt.Sprintf(`DROP TRIGGER IF EXISTS %s ON %s`, trigger, newStore.table)
+
+	createTriggerStmt := fmt.Sprintf(`CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s `+
+		`FOR EACH ROW EXECUTE PROCEDURE %s('%s')`,
+		trigger, newStore.table, function.Sanitize(), strings.ReplaceAll(newStore.channel, "'", "''"))
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	// The statements are serialized across processes, since concurrently replacing a function or trigger fails.
+	err := newStore.connectionPoolToDatabase.BeginFunc(ctxWithTimeout, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctxWithTimeout, `SELECT pg_advisory_xact_lock(hashtext($1))`, notifyFunction)
+		if err != nil {
+			return err
+		}
+
+		for _, stmt := range []string{createFunctionStmt, dropTriggerStmt, createTriggerStmt} {
+			_, err = tx.Exec(ctxWithTimeout, stmt)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create change trigger: %w", err)
+	}
+
+	return nil
+}
+
+// parseChange parses the payload of a notification sent by the trigger function.
+func parseChange(payload string) (Change, error) {
+	var notification struct {
+		Key       string          `json:"key"`
+		Operation Operation       `json:"operation"`
+		Tags      json.RawMessage `json:"tags"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &notification)
+	if err != nil {
+		return Change{}, fmt.Errorf("failed to unmarshal notification: %w", err)
+	}
+
+	change := Change{Key: notification.Key, Operation: notification.Operation}
+
+	if notification.Tags != nil {
+		change.Tags, err = unmarshalTags(notification.Tags)
+		if err != nil {
+			return Change{}, err
+		}
+	}
+
+	return change, nil
+}
+
+// truncateIdentifier truncates name to the maximum length of a PostgreSQL identifier, without splitting a character.
+func truncateIdentifier(name string) string {
+	if len(name) <= maxIdentifierLength {
+		return name
+	}
+
+	end := maxIdentifierLength
+	for end > 0 && !utf8.RuneStart(name[end]) {
+		end--
+	}
+
+	return name[:end]
+}
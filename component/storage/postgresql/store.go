@@ -9,8 +9,11 @@ package postgresql
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,21 +29,42 @@ const (
 	defaultTimeout = time.Second * 10
 	invalidTag     = `"%s" is an invalid tag %s since it contains one or more of the ` +
 		`following substrings: ":", "<=", "<", ">=", ">"`
+
+	// maxStatementParameters is the maximum number of parameters a PostgreSQL statement can have.
+	maxStatementParameters = 65535
+	// columnsPerRow is the number of columns set by an upsert: key, doc, bin and tags.
+	columnsPerRow = 4
+
+	defaultPageSize = 25
+
+	// storeConfigurationsTable is the table in the database named in the connection string that holds the
+	// configurations of all stores.
+	storeConfigurationsTable = "aries_store_configurations"
+
+	equalsExpressionTagNameOnlyLength     = 1
+	equalsExpressionTagNameAndValueLength = 2
+	rangeExpressionLength                 = 2
 )
 
+var errInvalidQueryExpressionFormat = errors.New("invalid expression format. " +
+	"It must be in the following format: " +
+	"TagName:TagValue or TagName1:TagValue1&&TagName2:TagValue2. Tag values are optional. If using tag values, " +
+	"<=, <, >=, or > may be used in place of the : to match a range of tag values")
+
 type closer func(storeName string)
 
 // Provider represents a PostgreSQL implementation of the storage.Provider interface.
 // This implementation is not complete. Check each method's documentation for details on current limitations.
-// WARNING: Certain inputs could be used for an SQL injection attack. While prepared statements are used whenever
-// possible to prevent this, some inputs cannot be used in a prepared statement. Be very careful when using
-// user-supplied data as inputs to the methods specified in this file.
-// See the documentation above each method for details.
+// Keys, values and tags are always passed to PostgreSQL as parameters of prepared statements, and store names are
+// quoted as identifiers, so the inputs to the methods in this file cannot be used for an SQL injection attack.
 type Provider struct {
 	connectionPool   *pgxpool.Pool
 	connectionString string
 	openStores       map[string]*store
 	dbPrefix         string
+	singleDatabase   bool
+	schema           string
+	notifyChanges    bool
 	timeout          time.Duration
 	lock             sync.RWMutex
 }
@@ -48,10 +72,32 @@ type Provider struct {
 // Option represents an option for a PostgreSQL Provider.
 type Option func(opts *Provider)
 
-// WithDBPrefix is an option for adding a prefix to all created database names.
+// WithDBPrefix is an option for adding a prefix to all created database names, or to all created table names if
+// all stores are kept in a single database. Like store names, the prefix is not case-sensitive: it is lower-cased,
+// which is also how PostgreSQL folds the unquoted names used by earlier versions of this Provider.
 func WithDBPrefix(dbPrefix string) Option {
 	return func(opts *Provider) {
-		opts.dbPrefix = dbPrefix
+		opts.dbPrefix = strings.ToLower(dbPrefix)
+	}
+}
+
+// WithSingleDatabase is an option for keeping all stores as tables in the database named in the connection string,
+// rather than creating a database for each store. All stores then share the Provider's connection pool, and the
+// PostgreSQL user doesn't need the privilege to create databases, only to create tables.
+// The tables are created in the first schema of the search path, which is usually public, unless WithSchema is used.
+func WithSingleDatabase() Option {
+	return func(opts *Provider) {
+		opts.singleDatabase = true
+	}
+}
+
+// WithSchema is an option for keeping the tables of all stores in the given schema of the database named in the
+// connection string. The schema is created if it doesn't exist. Like store names, the schema name is not
+// case-sensitive. This option implies WithSingleDatabase.
+func WithSchema(schema string) Option {
+	return func(opts *Provider) {
+		opts.singleDatabase = true
+		opts.schema = strings.ToLower(schema)
 	}
 }
 
@@ -67,10 +113,6 @@ func WithTimeout(timeout time.Duration) Option {
 // connectionString can take one of several forms - see the pgxpool.Connect method for details.
 // This PostgreSQL provider implementation is not yet complete. Check each method's documentation for details on
 // current limitations.
-// WARNING: Certain inputs to the various Provider and store functions could be used for an SQL injection attack.
-// While prepared statements are used whenever possible to prevent this, some inputs cannot be used in a
-// prepared statement. Be very careful when using  user-supplied data as inputs to the methods specified in this file.
-// See the documentation above each method for details.
 func NewProvider(connectionString string, opts ...Option) (*Provider, error) {
 	provider := &Provider{openStores: map[string]*store{}}
 
@@ -87,15 +129,30 @@ func NewProvider(connectionString string, opts ...Option) (*Provider, error) {
 	provider.connectionPool = connectionPool
 	provider.connectionString = connectionString
 
+	if provider.schema != "" {
+		err = provider.createSchema()
+		if err != nil {
+			connectionPool.Close()
+
+			return nil, err
+		}
+	}
+
+	err = provider.createStoreConfigurationsTable()
+	if err != nil {
+		connectionPool.Close()
+
+		return nil, err
+	}
+
 	return provider, nil
 }
 
 // OpenStore opens a Store with the given name and returns a handle.
 // If the underlying database and table for the given name has never been created before, then it is created.
+// If all stores are kept in a single database, then only the table is created.
+// The table holds the tags of each key in a jsonb column with a GIN index.
 // Store names are not case-sensitive. If name is blank, then an error will be returned.
-// WARNING: This method will create a database and table based on the given name. Those database calls may be
-// vulnerable to an SQL injection attack as prepared statements cannot be used. Be very careful if you use a
-// user-provided string in the store name!
 func (p *Provider) OpenStore(name string) (storage.Store, error) {
 	if name == "" {
 		return nil, errors.New("store name cannot be empty")
@@ -103,69 +160,53 @@ func (p *Provider) OpenStore(name string) (storage.Store, error) {
 
 	name = p.dbPrefix + strings.ToLower(name)
 
-	err := p.createDatabase(name)
-	if err != nil {
-		return nil, err
-	}
-
-	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
-	defer cancel()
-
-	c, err := pgconn.ParseConfig(p.connectionString)
-	if err != nil {
-		return nil, err
+	newStore := &store{
+		name:          name,
+		table:         pgx.Identifier{name}.Sanitize(),
+		channel:       truncateIdentifier(name),
+		notifyChanges: p.notifyChanges,
+		closed:        make(chan struct{}),
+		timeout:       p.timeout,
+		close:         p.removeStore,
 	}
 
-	connectString := strings.ReplaceAll(p.connectionString, c.Database, name)
-
-	if c.Database == "" {
-		split := strings.Split(p.connectionString, "?")
-
-		connectString = fmt.Sprintf("%s/%s", p.connectionString, name)
+	if p.singleDatabase {
+		if p.schema != "" {
+			newStore.table = pgx.Identifier{p.schema, name}.Sanitize()
+			newStore.channel = truncateIdentifier(p.schema + "." + name)
+		}
 
-		if len(split) > 1 {
-			connectString = fmt.Sprintf("%s/%s?%s", split[0], name, split[1])
+		newStore.connectionPoolToDatabase = p.connectionPool
+	} else {
+		connectionPoolToDatabase, err := p.connectToStoreDatabase(name)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	connectionPoolToDatabase, err := pgxpool.Connect(ctxWithTimeout,
-		connectString)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		newStore.connectionPoolToDatabase = connectionPoolToDatabase
+		newStore.ownsConnectionPool = true
 	}
 
-	err = p.createTable(name, connectionPoolToDatabase)
+	err := p.setUpStore(newStore)
 	if err != nil {
-		return nil, err
-	}
+		if newStore.ownsConnectionPool {
+			newStore.connectionPoolToDatabase.Close()
+		}
 
-	newStore := &store{
-		name:                     name,
-		connectionPoolToDatabase: connectionPoolToDatabase,
-		timeout:                  p.timeout,
-		close:                    p.removeStore,
+		return nil, err
 	}
 
+	p.lock.Lock()
 	p.openStores[name] = newStore
+	p.lock.Unlock()
 
 	return newStore, nil
 }
 
-// SetStoreConfig uses the given tag names in the storage.StoreConfiguration passed in here to create columns
-// in the table used by the store referred to by storeName. These columns have indexes created on them. This method
-// must be called before attempting to store data using those tag names and also before trying to do a query using
-// those tag names.
-// WARNING: This method will create columns in the table based on the given tag names. Those database calls may be
-// vulnerable to an SQL injection attack as prepared statements cannot be used here. Be very careful if you use any
-// user-provided strings in the tag names!
-// TODO (#229): Proper conformance to the requirements specified by the interface. This implementation only works as
-//            expected if being called either a. the first time or b. on a store with the same config. See the issue
-//            for details.
-// TODO (#229): In this implementation, tag names are case-insensitive. For other storage provider implementations,
-//            they are case-sensitive. Either this implementation should allow them to be case-sensitive or the
-//            interface should specify that they should be case-insensitive in order to ensure consistency among
-//            implementations.
-// TODO (#229): SetStoreConfig is supposed to be optional for querying.
+// SetStoreConfig sets the configuration of the store referred to by storeName, which must be open. The configuration
+// is kept in a table of the database named in the connection string, so that it can be retrieved using
+// GetStoreConfig, including by other Providers. Since the tags of all keys share a single index, nothing needs to be
+// set up for specific tag names, so calling this method is optional for storing data and querying.
 func (p *Provider) SetStoreConfig(storeName string, config storage.StoreConfiguration) error {
 	err := validateTagNames(config.TagNames)
 	if err != nil {
@@ -174,46 +215,61 @@ func (p *Provider) SetStoreConfig(storeName string, config storage.StoreConfigur
 
 	storeName = strings.ToLower(p.dbPrefix + storeName)
 
-	openStore, found := p.openStores[storeName]
+	p.lock.RLock()
+	_, found := p.openStores[storeName]
+	p.lock.RUnlock()
+
 	if !found {
 		return storage.ErrStoreNotFound
 	}
 
-	if len(config.TagNames) == 0 {
-		return nil
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal store configuration: %w", err)
 	}
 
-	alterTableStatement := fmt.Sprintf(`ALTER TABLE %s`, openStore.name)
-
-	for i := 0; i < len(config.TagNames); i++ {
-		alterTableStatement += fmt.Sprintf(` ADD COLUMN %s text DEFAULT NULL`, config.TagNames[i])
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
 
-		if i != len(config.TagNames)-1 {
-			alterTableStatement += ","
-		}
+	_, err = p.connectionPool.Exec(ctxWithTimeout, fmt.Sprintf("INSERT INTO %s (name, config) VALUES ($1, $2::jsonb) "+
+		"ON CONFLICT (name) DO UPDATE SET config = excluded.config", p.storeConfigurationsTable()),
+		storeName, string(configBytes))
+	if err != nil {
+		return fmt.Errorf("failed to store store configuration: %w", err)
 	}
 
+	return nil
+}
+
+// GetStoreConfig gets the current configuration of the store referred to by name, as last set by SetStoreConfig.
+// If the store has never been opened by a call to OpenStore, then ErrStoreNotFound is returned. This method will not
+// open a store in the Provider.
+func (p *Provider) GetStoreConfig(name string) (storage.StoreConfiguration, error) {
+	name = strings.ToLower(p.dbPrefix + name)
+
+	var configBytes []byte
+
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
 	defer cancel()
 
-	_, err = openStore.connectionPoolToDatabase.Exec(ctxWithTimeout, alterTableStatement)
-	if err != nil && !strings.Contains(err.Error(), "already exists") {
-		return fmt.Errorf("failed to alter table: %w", err)
-	}
-
-	for i := 0; i < len(config.TagNames); i++ {
-		err = p.createIndex(openStore, config.TagNames[i])
-		if err != nil {
-			return err
+	err := p.connectionPool.QueryRow(ctxWithTimeout,
+		fmt.Sprintf("SELECT config FROM %s WHERE name = $1", p.storeConfigurationsTable()), name).Scan(&configBytes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.StoreConfiguration{}, storage.ErrStoreNotFound
 		}
+
+		return storage.StoreConfiguration{}, fmt.Errorf("failed to get store configuration: %w", err)
 	}
 
-	return nil
-}
+	var config storage.StoreConfiguration
+
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		return storage.StoreConfiguration{}, fmt.Errorf("failed to unmarshal store configuration: %w", err)
+	}
 
-// GetStoreConfig is not implemented.
-func (p *Provider) GetStoreConfig(string) (storage.StoreConfiguration, error) {
-	return storage.StoreConfiguration{}, errors.New("not implemented")
+	return config, nil
 }
 
 // GetOpenStores is not implemented.
@@ -266,11 +322,118 @@ func (p *Provider) removeStore(name string) {
 	}
 }
 
+// setUpStore creates the table of a store, if needed, and registers the store.
+func (p *Provider) setUpStore(newStore *store) error {
+	err := p.createTable(newStore)
+	if err != nil {
+		return err
+	}
+
+	err = p.registerStore(newStore.name)
+	if err != nil {
+		return err
+	}
+
+	if p.notifyChanges {
+		return p.createChangeTrigger(newStore)
+	}
+
+	return nil
+}
+
+// connectToStoreDatabase creates the database for the store with the given name, unless it already exists, and
+// connects to it.
+func (p *Provider) connectToStoreDatabase(name string) (*pgxpool.Pool, error) {
+	err := p.createDatabase(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	c, err := pgconn.ParseConfig(p.connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	connectString := strings.ReplaceAll(p.connectionString, c.Database, name)
+
+	if c.Database == "" {
+		split := strings.Split(p.connectionString, "?")
+
+		connectString = fmt.Sprintf("%s/%s", p.connectionString, name)
+
+		if len(split) > 1 {
+			connectString = fmt.Sprintf("%s/%s?%s", split[0], name, split[1])
+		}
+	}
+
+	connectionPoolToDatabase, err := pgxpool.Connect(ctxWithTimeout,
+		connectString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return connectionPoolToDatabase, nil
+}
+
+// storeConfigurationsTable returns the name of the table of store configurations, quoted for use in SQL statements.
+func (p *Provider) storeConfigurationsTable() string {
+	if p.schema != "" {
+		return pgx.Identifier{p.schema, storeConfigurationsTable}.Sanitize()
+	}
+
+	return pgx.Identifier{storeConfigurationsTable}.Sanitize()
+}
+
+func (p *Provider) createStoreConfigurationsTable() error {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err := p.connectionPool.Exec(ctxWithTimeout, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (name text PRIMARY KEY, config jsonb NOT NULL)`, p.storeConfigurationsTable()))
+	if err != nil {
+		return fmt.Errorf("failed to create store configurations table: %w", err)
+	}
+
+	return nil
+}
+
+// registerStore records that the store with the given name exists, with an empty configuration unless it already
+// has one.
+func (p *Provider) registerStore(name string) error {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err := p.connectionPool.Exec(ctxWithTimeout, fmt.Sprintf(
+		`INSERT INTO %s (name, config) VALUES ($1, '{}') ON CONFLICT (name) DO NOTHING`, p.storeConfigurationsTable()),
+		name)
+	if err != nil {
+		return fmt.Errorf("failed to register store: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Provider) createSchema() error {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err := p.connectionPool.Exec(ctxWithTimeout,
+		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, pgx.Identifier{p.schema}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	return nil
+}
+
 func (p *Provider) createDatabase(name string) error {
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
 	defer cancel()
 
-	createDatabaseStatement := fmt.Sprintf(`CREATE DATABASE %s`, name)
+	createDatabaseStatement := fmt.Sprintf(`CREATE DATABASE %s`, pgx.Identifier{name}.Sanitize())
 
 	_, err := p.connectionPool.Exec(ctxWithTimeout, createDatabaseStatement)
 	if err != nil && !strings.Contains(err.Error(), "already exists (SQLSTATE 42P04)") {
@@ -280,110 +443,195 @@ func (p *Provider) createDatabase(name string) error {
 	return nil
 }
 
-func (p *Provider) createTable(name string, connectionToDatabase *pgxpool.Pool) error {
-	createTableStmt :=
-		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key text PRIMARY KEY, doc jsonb, bin bytea)`, name)
+func (p *Provider) createTable(newStore *store) error {
+	createTableStmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s `+
+		`(key text PRIMARY KEY, doc jsonb, bin bytea, tags jsonb NOT NULL DEFAULT '{}')`, newStore.table)
+
+	// Tables created before tags were kept in a jsonb column don't have one. Their tag columns are migrated below.
+	addTagsColumnStmt := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS tags jsonb NOT NULL DEFAULT '{}'`,
+		newStore.table)
+
+	// The default operator class of a GIN index on a jsonb column supports both the ? operator, used by tag name
+	// queries, and the @> operator, used by tag name + value queries.
+	createIndexStmt := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (tags)`,
+		pgx.Identifier{newStore.name + "_tags"}.Sanitize(), newStore.table)
 
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
 	defer cancel()
 
-	_, err := connectionToDatabase.Exec(ctxWithTimeout, createTableStmt)
+	_, err := newStore.connectionPoolToDatabase.Exec(ctxWithTimeout, createTableStmt)
 	if err != nil {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
 
+	_, err = newStore.connectionPoolToDatabase.Exec(ctxWithTimeout, addTagsColumnStmt)
+	if err != nil {
+		return fmt.Errorf("failed to add tags column: %w", err)
+	}
+
+	err = migrateTagColumns(ctxWithTimeout, newStore)
+	if err != nil {
+		return fmt.Errorf("failed to migrate tag columns: %w", err)
+	}
+
+	_, err = newStore.connectionPoolToDatabase.Exec(ctxWithTimeout, createIndexStmt)
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+
 	return nil
 }
 
-func (p *Provider) createIndex(store *store, tagName string) error {
-	createIndexStatement := fmt.Sprintf("CREATE INDEX index_%s_%s ON %s(%s)",
-		store.name, tagName, store.name, tagName)
+// migrateTagColumns moves the tags of a table created before tags were kept in a jsonb column from the columns that
+// held them, one per tag name, to the tags column. The tag columns, along with their indexes, are dropped afterwards,
+// so the migration only happens once. Since PostgreSQL lower-cased the names of the tag columns, the migrated tag
+// names are lower-case.
+func migrateTagColumns(ctx context.Context, s *store) error {
+	columns, err := tagColumns(ctx, s.connectionPoolToDatabase, s.table)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
 
-	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), p.timeout)
-	defer cancel()
+	tx, err := s.connectionPoolToDatabase.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
 
-	_, err := store.connectionPoolToDatabase.Exec(ctxWithTimeout, createIndexStatement)
-	if err != nil && !strings.Contains(err.Error(), "already exists") {
-		return fmt.Errorf("failed to create index: %w", err)
+	err = moveTagColumns(ctx, tx, s.table)
+	if err != nil {
+		errRollback := tx.Rollback(ctx)
+		if errRollback != nil {
+			return fmt.Errorf("%w (failed to roll back transaction: %s)", err, errRollback.Error())
+		}
+
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
+// moveTagColumns copies the non-null values of the tag columns of a table into its tags column and drops the tag
+// columns. The table is locked first, and its tag columns looked up again, in case it was migrated concurrently.
+func moveTagColumns(ctx context.Context, tx pgx.Tx, table string) error {
+	_, err := tx.Exec(ctx, "LOCK TABLE "+table+" IN ACCESS EXCLUSIVE MODE")
+	if err != nil {
+		return fmt.Errorf("failed to lock table: %w", err)
+	}
+
+	columns, err := tagColumns(ctx, tx, table)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	tags := "tags"
+	drops := make([]string, len(columns))
+	arguments := make([]interface{}, len(columns))
+
+	for i, column := range columns {
+		identifier := pgx.Identifier{column}.Sanitize()
+		tags += fmt.Sprintf(" || jsonb_strip_nulls(jsonb_build_object($%d::text, %s::text))", i+1, identifier)
+		drops[i] = "DROP COLUMN " + identifier
+		arguments[i] = column
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET tags = %s", table, tags), arguments...)
+	if err != nil {
+		return fmt.Errorf("failed to copy tag columns: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s %s", table, strings.Join(drops, ", ")))
+	if err != nil {
+		return fmt.Errorf("failed to drop tag columns: %w", err)
+	}
+
+	return nil
+}
+
+// querier runs queries either directly on a connection pool or within a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// tagColumns returns the names of the columns of a table other than key, doc, bin and tags. Only tables created
+// before tags were kept in a jsonb column have such columns.
+func tagColumns(ctx context.Context, q querier, table string) ([]string, error) {
+	rows, err := q.Query(ctx, "SELECT attname FROM pg_attribute WHERE attrelid = $1::regclass AND attnum > 0 "+
+		"AND NOT attisdropped AND attname NOT IN ('key', 'doc', 'bin', 'tags') ORDER BY attnum", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up tag columns: %w", err)
+	}
+
+	defer rows.Close()
+
+	var columns []string
+
+	for rows.Next() {
+		var column string
+
+		if err = rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("failed to scan tag column: %w", err)
+		}
+
+		columns = append(columns, column)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to look up tag columns: %w", err)
+	}
+
+	return columns, nil
+}
+
 type store struct {
-	name                     string
+	name string
+	// table is the name of the store's table, quoted and qualified by schema if needed, for use in SQL statements.
+	table                    string
 	connectionPoolToDatabase *pgxpool.Pool
-	timeout                  time.Duration
-	close                    closer
+	// ownsConnectionPool is false if the connection pool is the Provider's, shared by all stores.
+	ownsConnectionPool bool
+	// channel is the channel on which changes to the store are notified, if enabled.
+	channel       string
+	notifyChanges bool
+	// closed is closed when the store is closed, which ends all subscriptions to its changes.
+	closed        chan struct{}
+	closeOnce     sync.Once
+	subscriptions sync.WaitGroup
+	lock          sync.Mutex
+	timeout       time.Duration
+	close         closer
 }
 
 // Put stores the key + value pair along with the (optional) tags.
-// Any tag names used must have been set in the store config prior to being used here.
 // If value is valid JSON, it will be stored using the jsonb type in PostgreSQL. When retrieved, it will be
 // equivalent JSON, but may not be byte-for-byte equal due to differences in whitespace or field order.
 // You should always unmarshal it first before doing comparisons with other JSON data.
-// When overwriting an existing key-value pair, the tags may not also get overwritten.
-// WARNING: Prepared statements are used to avoid SQL injection attacks using the key and value inputs, but tag names
-// could still be used for an SQL injection attack since prepared statement cannot be used for them as they refer
-// to column names. Be very careful if you use any user-provided strings in the tag names!
-// TODO (#229): Ensure that overwriting an existing key-value pair also updates the tags.
-// TODO (#229): In this implementation, tag names are case-insensitive. For other storage provider implementations,
-//            they are case-sensitive. Either this implementation should allow them to be case-sensitive or the
-//            interface should specify that they should be case-insensitive in order to ensure consistency among
-//            implementations.
+// When overwriting an existing key-value pair, its tags are replaced by the given ones. If the same tag name is given
+// more than once, the last tag value is stored.
 func (s *store) Put(key string, value []byte, tags ...storage.Tag) error {
 	err := validatePutInput(key, value, tags)
 	if err != nil {
 		return err
 	}
 
-	isJSON := false
-
-	err = fastjson.ValidateBytes(value)
-	if err == nil {
-		isJSON = true
-	}
-
-	columns := "(key,"
-
-	if isJSON {
-		columns += "doc"
-	} else {
-		columns += "bin"
+	tagsJSON, err := marshalTags(tags)
+	if err != nil {
+		return err
 	}
 
-	values := "($1,$2"
-
-	arguments := []interface{}{key, value}
-
-	// This offset ensures that the optional positional arguments start after the first two mandatory ones.
-	const argumentPositionOffset = 3
-
-	if len(tags) == 0 {
-		columns += ")"
-		values += ")"
-	} else {
-		for i := 0; i < len(tags); i++ {
-			columns += fmt.Sprintf(",%s", tags[i].Name)
-			values += fmt.Sprintf(",$%d", i+argumentPositionOffset)
-			arguments = append(arguments, tags[i].Value)
-
-			if i == len(tags)-1 {
-				columns += ")"
-				values += ")"
-			}
-		}
-	}
+	doc, bin := splitValue(value)
 
-	insertStmt :=
-		fmt.Sprintf("INSERT INTO %s %s VALUES %s "+
-			"ON CONFLICT (key) DO UPDATE SET doc = excluded.doc, bin = excluded.bin",
-			s.name, columns, values)
+	insertStmt := fmt.Sprintf("INSERT INTO %s (key, doc, bin, tags) VALUES ($1, $2, $3, $4::jsonb) "+
+		"ON CONFLICT (key) DO UPDATE SET doc = excluded.doc, bin = excluded.bin, tags = excluded.tags", s.table)
 
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), s.timeout)
 	defer cancel()
 
-	_, err = s.connectionPoolToDatabase.Exec(ctxWithTimeout, insertStmt, arguments...)
+	_, err = s.connectionPoolToDatabase.Exec(ctxWithTimeout, insertStmt, key, doc, bin, tagsJSON)
 	if err != nil {
 		return fmt.Errorf("failed to insert data into table: %w", err)
 	}
@@ -400,7 +648,7 @@ func (s *store) Get(key string) ([]byte, error) {
 
 	var bin []byte
 
-	selectStatement := "SELECT doc,bin FROM " + s.name + " WHERE key = $1"
+	selectStatement := "SELECT doc,bin FROM " + s.table + " WHERE key = $1"
 
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), s.timeout)
 	defer cancel()
@@ -421,55 +669,134 @@ func (s *store) Get(key string) ([]byte, error) {
 	return bin, nil
 }
 
-func (s *store) GetTags(string) ([]storage.Tag, error) {
-	return nil, errors.New("not implemented")
-}
+// GetTags fetches all tags associated with the given key, sorted by tag name.
+func (s *store) GetTags(key string) ([]storage.Tag, error) {
+	if key == "" {
+		return nil, errors.New("key cannot be empty")
+	}
 
-func (s *store) GetBulk(...string) ([][]byte, error) {
-	return nil, errors.New("not implemented")
-}
+	var tagsJSON []byte
 
-// Query returns all data that satisfies the expression. Expression format: TagName. (TagName + TagValue queries
-// are not currently implemented.)
-// This provider doesn't currently support any of the current query options.
-// spi.WithPageSize will simply be ignored since it only relates to performance and not the actual end result.
-// spi.WithInitialPageNum and spi.WithSortOrder will result in an error being returned since those options do
-// affect the results that the Iterator returns.
-// WARNING: The tag name used in the expression could be used to do an SQL injection attack since a prepared statement
-// cannot be used here. Be very careful if you use a user-provided string in the tag name!
-// TODO (#229): Support TagName + TagValue queries.
-// TODO (#229): In this implementation, tag names are case-insensitive. For other storage provider implementations,
-//            they are case-sensitive. Either this implementation should allow them to be case-sensitive or the
-//            interface should specify that they should be case-insensitive in order to ensure consistency among
-//            implementations.
-func (s *store) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
-	err := checkForUnsupportedQueryOptions(options)
+	selectStatement := "SELECT tags FROM " + s.table + " WHERE key = $1"
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	err := s.connectionPoolToDatabase.QueryRow(ctxWithTimeout, selectStatement, key).Scan(&tagsJSON)
 	if err != nil {
-		return nil, err
-	}
+		if strings.Contains(err.Error(), "no rows in result set") {
+			return nil, storage.ErrDataNotFound
+		}
 
-	if expression == "" {
-		return &iterator{}, errors.New("expression cannot be empty")
+		return nil, fmt.Errorf("failed to query table: %w", err)
 	}
 
-	expressionSplit := strings.Split(expression, ":")
-	_ = expressionSplit
+	return unmarshalTags(tagsJSON)
+}
 
-	if len(expressionSplit) != 1 {
-		return nil, errors.New("tag name + value queries not implemented")
+// GetBulk fetches the values associated with the given keys, using a single query.
+// If no data exists under a given key, then a nil []byte is returned for that value. It is not considered an error.
+// If any of the given keys are empty, then an error will be returned.
+func (s *store) GetBulk(keys ...string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keys slice must contain at least one key")
 	}
 
-	selectStatement := fmt.Sprintf("SELECT * FROM %s WHERE %s IS NOT NULL", s.name, expressionSplit[0])
+	for _, key := range keys {
+		if key == "" {
+			return nil, errors.New("key cannot be empty")
+		}
+	}
 
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), s.timeout)
 	defer cancel()
 
-	rows, err := s.connectionPoolToDatabase.Query(ctxWithTimeout, selectStatement)
+	rows, err := s.connectionPoolToDatabase.Query(ctxWithTimeout,
+		fmt.Sprintf("SELECT key, doc, bin FROM %s WHERE key = ANY($1)", s.table), keys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query table: %w", err)
 	}
 
-	return &iterator{rows: rows}, nil
+	defer rows.Close()
+
+	valuesByKey := make(map[string][]byte, len(keys))
+
+	for rows.Next() {
+		var (
+			key      string
+			doc, bin []byte
+		)
+
+		err = rows.Scan(&key, &doc, &bin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read query results: %w", err)
+		}
+
+		if doc != nil {
+			valuesByKey[key] = doc
+		} else {
+			valuesByKey[key] = bin
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query results: %w", err)
+	}
+
+	values := make([][]byte, len(keys))
+
+	for i, key := range keys {
+		values[i] = valuesByKey[key]
+	}
+
+	return values, nil
+}
+
+// Query returns all data that satisfies the expression. Expression format: TagName:TagValue.
+// If TagValue is not provided, then all data associated with the TagName will be returned.
+// This implementation also supports querying for data tagged with multiple tag name + value pairs (using AND logic).
+// To do this, separate the tag name + value pairs using &&. For example, TagName1:TagValue1&&TagName2 will return
+// only data that has been tagged with TagName1 with a value of TagValue1 and also tagged with TagName2.
+// If the tag you're using has tag values that are integers, then you can use the <, <=, >, >= operators instead
+// of : to get a range of matching data. For example, TagName>3 will return any data tagged with a tag named TagName
+// that has a value greater than 3. Data whose tag value is not an integer never matches a range.
+// Results are fetched from PostgreSQL one page at a time as the Iterator is advanced. If no page size is set,
+// then pages of 25 are used. If sorting is used, then values of the sort tag that are integers are sorted
+// numerically and before any other values. Results with equal sort values, or all results if sorting isn't used,
+// are ordered by key. Since each page is a separate query, data changed while iterating may cause results to be
+// skipped or repeated.
+// Tag name and tag name + value conditions make use of the index on the tags, while range conditions don't.
+func (s *store) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
+	if expression == "" {
+		return &iterator{}, errors.New("expression cannot be empty")
+	}
+
+	filter, arguments, err := prepareFilter(strings.Split(expression, "&&"))
+	if err != nil {
+		return nil, err
+	}
+
+	queryOptions := getQueryOptions(options)
+
+	orderBy, orderByArguments := prepareOrderBy(queryOptions.SortOptions, len(arguments)+1)
+
+	newIterator := &iterator{
+		store:            s,
+		filter:           filter,
+		arguments:        arguments,
+		orderBy:          orderBy,
+		orderByArguments: orderByArguments,
+		pageSize:         queryOptions.PageSize,
+		offset:           queryOptions.InitialPageNum * queryOptions.PageSize,
+	}
+
+	err = newIterator.fetchPage()
+	if err != nil {
+		return nil, err
+	}
+
+	return newIterator, nil
 }
 
 func (s *store) Delete(key string) error {
@@ -481,7 +808,7 @@ func (s *store) Delete(key string) error {
 	defer cancel()
 
 	_, err := s.connectionPoolToDatabase.Exec(ctxWithTimeout,
-		fmt.Sprintf(`DELETE FROM "%s" WHERE key=$1`, s.name), key)
+		fmt.Sprintf(`DELETE FROM %s WHERE key=$1`, s.table), key)
 	if err != nil {
 		return fmt.Errorf("failed to delete data in table: %w", err)
 	}
@@ -489,8 +816,41 @@ func (s *store) Delete(key string) error {
 	return nil
 }
 
-func (s *store) Batch([]storage.Operation) error {
-	return errors.New("not implemented")
+// Batch performs multiple Put and/or Delete operations in order. An operation with a nil value is a Delete.
+// All operations are done within a single transaction, so either all of them are applied or, if any of them fails,
+// none are. Consecutive Put operations are combined into multi-row upserts and consecutive Delete operations into
+// a single statement. If an operation on a key is followed by other operations on the same key, the last one wins.
+// As with Put, overwriting an existing key-value pair replaces its tags.
+func (s *store) Batch(operations []storage.Operation) error {
+	err := validateBatchInput(operations)
+	if err != nil {
+		return err
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	tx, err := s.connectionPoolToDatabase.Begin(ctxWithTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	err = s.executeBatch(ctxWithTimeout, tx, operations)
+	if err != nil {
+		errRollback := tx.Rollback(ctxWithTimeout)
+		if errRollback != nil {
+			return fmt.Errorf("%w (failed to roll back transaction: %s)", err, errRollback.Error())
+		}
+
+		return err
+	}
+
+	err = tx.Commit(ctxWithTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
 }
 
 // Flush always returns nil, since this store doesn't buffer data.
@@ -499,51 +859,278 @@ func (s *store) Flush() error {
 }
 
 func (s *store) Close() error {
-	s.connectionPoolToDatabase.Close()
+	// Closing a connection pool waits for all of its connections to be released, including those of subscriptions.
+	s.stopSubscriptions()
+
+	if s.ownsConnectionPool {
+		s.connectionPoolToDatabase.Close()
+	}
 
 	s.close(s.name)
 
 	return nil
 }
 
+// executeBatch splits the operations into runs of consecutive Put or Delete operations and executes each run.
+func (s *store) executeBatch(ctx context.Context, tx pgx.Tx, operations []storage.Operation) error {
+	for start := 0; start < len(operations); {
+		isDelete := operations[start].Value == nil
+
+		end := start + 1
+		for end < len(operations) && (operations[end].Value == nil) == isDelete {
+			end++
+		}
+
+		var err error
+
+		if isDelete {
+			err = s.batchDelete(ctx, tx, operations[start:end])
+		} else {
+			err = s.batchPut(ctx, tx, operations[start:end])
+		}
+
+		if err != nil {
+			return err
+		}
+
+		start = end
+	}
+
+	return nil
+}
+
+func (s *store) batchDelete(ctx context.Context, tx pgx.Tx, operations []storage.Operation) error {
+	keys := make([]string, len(operations))
+
+	for i, operation := range operations {
+		keys[i] = operation.Key
+	}
+
+	_, err := tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE key = ANY($1)`, s.table), keys)
+	if err != nil {
+		return fmt.Errorf("failed to delete data in table: %w", err)
+	}
+
+	return nil
+}
+
+// batchPut upserts the values of consecutive Put operations, using as few statements as the limit on the number of
+// parameters of a PostgreSQL statement allows.
+func (s *store) batchPut(ctx context.Context, tx pgx.Tx, operations []storage.Operation) error {
+	// A single upsert statement cannot affect the same row twice, so only the last Put on each key is kept.
+	operations = lastOperationPerKey(operations)
+
+	rowsPerStatement := maxStatementParameters / columnsPerRow
+
+	for start := 0; start < len(operations); start += rowsPerStatement {
+		end := start + rowsPerStatement
+		if end > len(operations) {
+			end = len(operations)
+		}
+
+		insertStmt, arguments, err := s.upsertStatement(operations[start:end])
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, insertStmt, arguments...)
+		if err != nil {
+			return fmt.Errorf("failed to insert data into table: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// upsertStatement builds a multi-row upsert of the given Put operations.
+func (s *store) upsertStatement(operations []storage.Operation) (string, []interface{}, error) {
+	rows := make([]string, len(operations))
+	arguments := make([]interface{}, 0, len(operations)*columnsPerRow)
+
+	for i, operation := range operations {
+		tagsJSON, err := marshalTags(operation.Tags)
+		if err != nil {
+			return "", nil, err
+		}
+
+		doc, bin := splitValue(operation.Value)
+
+		position := len(arguments)
+
+		rows[i] = fmt.Sprintf("($%d, $%d, $%d, $%d::jsonb)", position+1, position+2, position+3, position+4)
+
+		arguments = append(arguments, operation.Key, doc, bin, tagsJSON)
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (key, doc, bin, tags) VALUES %s "+
+		"ON CONFLICT (key) DO UPDATE SET doc = excluded.doc, bin = excluded.bin, tags = excluded.tags",
+		s.table, strings.Join(rows, ",")), arguments, nil
+}
+
+type entry struct {
+	key   string
+	value []byte
+	tags  []byte
+}
+
+// iterator fetches the results of a query one page at a time.
 type iterator struct {
-	rows pgx.Rows
+	store            *store
+	filter           string
+	arguments        []interface{}
+	orderBy          string
+	orderByArguments []interface{}
+	pageSize         int
+	// offset is the number of results before the next page to fetch.
+	offset   int
+	page     []entry
+	current  int
+	lastPage bool
 }
 
+// Next moves the pointer to the next entry in the iterator, fetching the next page from PostgreSQL if needed.
+// Note that it must be called before accessing the first entry.
+// It returns false if the iterator is exhausted - this is not considered an error.
 func (i *iterator) Next() (bool, error) {
-	return i.rows.Next(), nil
+	if i.store == nil {
+		return false, nil
+	}
+
+	if i.current+1 >= len(i.page) && !i.lastPage {
+		err := i.fetchPage()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if i.current+1 >= len(i.page) {
+		return false, nil
+	}
+
+	i.current++
+
+	return true, nil
 }
 
 func (i *iterator) Key() (string, error) {
-	rawValues := i.rows.RawValues()
+	currentEntry, err := i.currentEntry()
+	if err != nil {
+		return "", err
+	}
 
-	return string(rawValues[0]), nil
+	return currentEntry.key, nil
 }
 
 func (i *iterator) Value() ([]byte, error) {
-	rawValues := i.rows.RawValues()
-
-	if rawValues[1] != nil {
-		return rawValues[1], nil
+	currentEntry, err := i.currentEntry()
+	if err != nil {
+		return nil, err
 	}
 
-	return rawValues[2], nil
+	return currentEntry.value, nil
 }
 
+// Tags returns the tags associated with the key of the current entry, sorted by tag name.
 func (i *iterator) Tags() ([]storage.Tag, error) {
-	return nil, errors.New("not implemented")
+	currentEntry, err := i.currentEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalTags(currentEntry.tags)
 }
 
+// TotalItems returns a count of the number of entries matched by the query that generated this iterator.
+// This count is not affected by the page settings used. This runs a separate query on PostgreSQL, so the count
+// reflects the current state of the database, which may have changed since this iterator was created.
 func (i *iterator) TotalItems() (int, error) {
-	return -1, errors.New("not implemented")
+	if i.store == nil {
+		return -1, errors.New("iterator was not created by a query")
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), i.store.timeout)
+	defer cancel()
+
+	var totalItems int
+
+	err := i.store.connectionPoolToDatabase.QueryRow(ctxWithTimeout,
+		fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", i.store.table, i.filter), i.arguments...).Scan(&totalItems)
+	if err != nil {
+		return -1, fmt.Errorf("failed to count query results: %w", err)
+	}
+
+	return totalItems, nil
 }
 
+// Close releases the current page. Since each page is read completely when fetched, no database resources are held.
 func (i *iterator) Close() error {
-	i.rows.Close()
+	i.page = nil
+	i.current = -1
+	i.lastPage = true
 
 	return nil
 }
 
+// fetchPage reads the next page of results. The iterator points before its first entry.
+func (i *iterator) fetchPage() error {
+	selectStatement := fmt.Sprintf("SELECT key, doc, bin, tags FROM %s WHERE %s ORDER BY %s LIMIT %d OFFSET %d",
+		i.store.table, i.filter, i.orderBy, i.pageSize, i.offset)
+
+	arguments := make([]interface{}, 0, len(i.arguments)+len(i.orderByArguments))
+	arguments = append(arguments, i.arguments...)
+	arguments = append(arguments, i.orderByArguments...)
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), i.store.timeout)
+	defer cancel()
+
+	rows, err := i.store.connectionPoolToDatabase.Query(ctxWithTimeout, selectStatement, arguments...)
+	if err != nil {
+		return fmt.Errorf("failed to query table: %w", err)
+	}
+
+	defer rows.Close()
+
+	page := make([]entry, 0, i.pageSize)
+
+	for rows.Next() {
+		var (
+			key            string
+			doc, bin, tags []byte
+		)
+
+		err = rows.Scan(&key, &doc, &bin, &tags)
+		if err != nil {
+			return fmt.Errorf("failed to read query results: %w", err)
+		}
+
+		if doc != nil {
+			page = append(page, entry{key: key, value: doc, tags: tags})
+		} else {
+			page = append(page, entry{key: key, value: bin, tags: tags})
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("failed to read query results: %w", err)
+	}
+
+	i.page = page
+	i.current = -1
+	i.lastPage = len(page) < i.pageSize
+	i.offset += len(page)
+
+	return nil
+}
+
+func (i *iterator) currentEntry() (entry, error) {
+	if i.current < 0 || i.current >= len(i.page) {
+		return entry{}, errors.New("iterator has no current entry")
+	}
+
+	return i.page[i.current], nil
+}
+
 func setOptions(opts []Option, p *Provider) {
 	for _, opt := range opts {
 		opt(p)
@@ -614,25 +1201,195 @@ func validateTags(tags []storage.Tag) error {
 	return nil
 }
 
-func checkForUnsupportedQueryOptions(options []storage.QueryOption) error {
-	querySettings := getQueryOptions(options)
-
-	if querySettings.InitialPageNum != 0 {
-		return errors.New("setting initial page number not implemented")
+func validateBatchInput(operations []storage.Operation) error {
+	if len(operations) == 0 {
+		return errors.New("batch requires at least one operation")
 	}
 
-	if querySettings.SortOptions != nil {
-		return errors.New("custom sort options not implemented")
+	for _, operation := range operations {
+		if operation.Key == "" {
+			return errors.New("key cannot be empty")
+		}
+
+		err := validateTags(operation.Tags)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// lastOperationPerKey returns the operations without those followed by another operation on the same key.
+func lastOperationPerKey(operations []storage.Operation) []storage.Operation {
+	lastIndexes := make(map[string]int, len(operations))
+
+	for i, operation := range operations {
+		lastIndexes[operation.Key] = i
+	}
+
+	if len(lastIndexes) == len(operations) {
+		return operations
+	}
+
+	remaining := make([]storage.Operation, 0, len(lastIndexes))
+
+	for i, operation := range operations {
+		if lastIndexes[operation.Key] == i {
+			remaining = append(remaining, operation)
+		}
+	}
+
+	return remaining
+}
+
+// splitValue returns the value as the doc column if it is valid JSON, or as the bin column otherwise.
+func splitValue(value []byte) (doc, bin []byte) {
+	if fastjson.ValidateBytes(value) == nil {
+		return value, nil
+	}
+
+	return nil, value
+}
+
+// marshalTags converts tags into the JSON object stored in the tags column, which maps tag names to tag values.
+func marshalTags(tags []storage.Tag) (string, error) {
+	tagMap := make(map[string]string, len(tags))
+
+	for _, tag := range tags {
+		tagMap[tag.Name] = tag.Value
+	}
+
+	tagsJSON, err := json.Marshal(tagMap)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal tags: %w", err)
+	}
+
+	return string(tagsJSON), nil
+}
+
+func unmarshalTags(tagsJSON []byte) ([]storage.Tag, error) {
+	var tagMap map[string]string
+
+	err := json.Unmarshal(tagsJSON, &tagMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tags: %w", err)
+	}
+
+	tags := make([]storage.Tag, 0, len(tagMap))
+
+	for name, value := range tagMap {
+		tags = append(tags, storage.Tag{Name: name, Value: value})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+
+	return tags, nil
+}
+
+// prepareFilter converts the operands of a query expression, which are joined using AND logic, into the condition
+// of a WHERE clause and its arguments.
+func prepareFilter(operands []string) (string, []interface{}, error) {
+	conditions := make([]string, len(operands))
+
+	var arguments []interface{}
+
+	for i, operand := range operands {
+		tagName, operator, tagValue, err := splitOperand(operand)
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch operator {
+		case "":
+			arguments = append(arguments, tagName)
+			conditions[i] = fmt.Sprintf("tags ? $%d::text", len(arguments))
+		case "=":
+			tagJSON, errMarshal := json.Marshal(map[string]string{tagName: tagValue})
+			if errMarshal != nil {
+				return "", nil, fmt.Errorf("failed to marshal tag: %w", errMarshal)
+			}
+
+			arguments = append(arguments, string(tagJSON))
+			conditions[i] = fmt.Sprintf("tags @> $%d::jsonb", len(arguments))
+		default:
+			integerValue, errParse := strconv.ParseInt(tagValue, 10, 64)
+			if errParse != nil {
+				return "", nil, fmt.Errorf("invalid query format. when using any one of the <=, <, >=, > "+
+					"operators, the immediate value on the right side side must be a valid integer: %w", errParse)
+			}
+
+			arguments = append(arguments, tagName, integerValue)
+			conditions[i] = fmt.Sprintf("%s %s $%d", integerTagValue(len(arguments)-1), operator, len(arguments))
+		}
+	}
+
+	return strings.Join(conditions, " AND "), arguments, nil
+}
+
+// splitOperand splits an operand of a query expression into its tag name, SQL operator and tag value.
+// The operator is empty if the operand is only a tag name.
+func splitOperand(operand string) (tagName, operator, tagValue string, err error) {
+	for _, rangeOperator := range []string{"<=", "<", ">=", ">"} {
+		operandSplit := strings.Split(operand, rangeOperator)
+		if len(operandSplit) == rangeExpressionLength {
+			return operandSplit[0], rangeOperator, operandSplit[1], nil
+		}
+	}
+
+	operandSplit := strings.Split(operand, ":")
+
+	switch len(operandSplit) {
+	case equalsExpressionTagNameOnlyLength:
+		return operandSplit[0], "", "", nil
+	case equalsExpressionTagNameAndValueLength:
+		return operandSplit[0], "=", operandSplit[1], nil
+	default:
+		return "", "", "", errInvalidQueryExpressionFormat
+	}
+}
+
+// prepareOrderBy returns the ORDER BY clause for the given sort options, along with its arguments, which start at
+// the given position. Results are always ordered by key last, so that pages are consistent.
+func prepareOrderBy(sortOptions *storage.SortOptions, position int) (string, []interface{}) {
+	if sortOptions == nil {
+		return "key", nil
+	}
+
+	direction := "ASC"
+	if sortOptions.Order == storage.SortDescending {
+		direction = "DESC"
+	}
+
+	return fmt.Sprintf("%s %s NULLS LAST, tags->>$%d::text %s NULLS LAST, key",
+		integerTagValue(position), direction, position, direction), []interface{}{sortOptions.TagName}
+}
+
+// integerTagValue returns an SQL expression for the value, as an integer, of the tag whose name is the argument at
+// the given position. It is NULL if the value is not an integer. A CASE expression is used since PostgreSQL doesn't
+// guarantee the evaluation order of conditions.
+func integerTagValue(position int) string {
+	return fmt.Sprintf(`(CASE WHEN tags->>$%d::text ~ '^-?[0-9]+$' THEN (tags->>$%d::text)::numeric END)`,
+		position, position)
+}
+
 func getQueryOptions(options []storage.QueryOption) storage.QueryOptions {
 	var queryOptions storage.QueryOptions
 
 	for _, option := range options {
-		option(&queryOptions)
+		if option != nil {
+			option(&queryOptions)
+		}
+	}
+
+	if queryOptions.PageSize < 1 {
+		queryOptions.PageSize = defaultPageSize
+	}
+
+	if queryOptions.InitialPageNum < 0 {
+		queryOptions.InitialPageNum = 0
 	}
 
 	return queryOptions
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -78,6 +79,291 @@ func TestCommon(t *testing.T) {
 
 		runCommonTests(t, provider)
 	})
+	t.Run("Single database", func(t *testing.T) {
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString,
+			postgresql.WithSingleDatabase(),
+			postgresql.WithDBPrefix("testprefix_"))
+		require.NoError(t, err)
+
+		runCommonTests(t, provider)
+	})
+	t.Run("Single database with schema", func(t *testing.T) {
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString,
+			postgresql.WithSchema("aries_stores"))
+		require.NoError(t, err)
+
+		runCommonTests(t, provider)
+		testSingleDatabase(t)
+	})
+	t.Run("Change notifications", func(t *testing.T) {
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString,
+			postgresql.WithSchema("aries_notified_stores"),
+			postgresql.WithChangeNotifications())
+		require.NoError(t, err)
+
+		runCommonTests(t, provider)
+		testChangeNotifications(t)
+	})
+}
+
+func testSingleDatabase(t *testing.T) {
+	t.Helper()
+
+	t.Run("Stores are tables sharing the connection pool of the provider", func(t *testing.T) {
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString, postgresql.WithSchema("aries_stores"))
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, provider.Close())
+		}()
+
+		storeName := randomStoreName()
+
+		store1, err := provider.OpenStore(storeName)
+		require.NoError(t, err)
+
+		err = store1.Put("key", []byte("value"))
+		require.NoError(t, err)
+
+		// Closing a store must not close the connection pool shared with the other stores.
+		require.NoError(t, store1.Close())
+
+		store2, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		err = store2.Put("key", []byte("value"))
+		require.NoError(t, err)
+
+		connection, err := pgx.Connect(context.Background(), postgreSQLConnectionString)
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, connection.Close(context.Background()))
+		}()
+
+		var databaseExists bool
+
+		err = connection.QueryRow(context.Background(),
+			"SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", storeName).Scan(&databaseExists)
+		require.NoError(t, err)
+		require.False(t, databaseExists)
+
+		var value []byte
+
+		err = connection.QueryRow(context.Background(),
+			fmt.Sprintf(`SELECT bin FROM aries_stores.%s WHERE key = 'key'`, storeName)).Scan(&value)
+		require.NoError(t, err)
+		require.Equal(t, "value", string(value))
+	})
+
+	t.Run("Mixed-case prefix and schema name the tables of unquoted identifiers", func(t *testing.T) {
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString, postgresql.WithSchema("Aries_Stores"),
+			postgresql.WithDBPrefix("TestPrefix_"))
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, provider.Close())
+		}()
+
+		storeName := randomStoreName()
+
+		store, err := provider.OpenStore(storeName)
+		require.NoError(t, err)
+
+		err = store.Put("key", []byte("value"))
+		require.NoError(t, err)
+
+		require.NoError(t, provider.SetStoreConfig(storeName, spi.StoreConfiguration{}))
+
+		connection, err := pgx.Connect(context.Background(), postgreSQLConnectionString)
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, connection.Close(context.Background()))
+		}()
+
+		var value []byte
+
+		// Unquoted identifiers are folded to lower case, as they were by earlier versions of the Provider.
+		err = connection.QueryRow(context.Background(),
+			fmt.Sprintf(`SELECT bin FROM Aries_Stores.TestPrefix_%s WHERE key = 'key'`, storeName)).Scan(&value)
+		require.NoError(t, err)
+		require.Equal(t, "value", string(value))
+	})
+
+	t.Run("Tag columns of a store created by an earlier version are migrated", func(t *testing.T) {
+		connection, err := pgx.Connect(context.Background(), postgreSQLConnectionString)
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, connection.Close(context.Background()))
+		}()
+
+		storeName := randomStoreName()
+		table := "aries_stores." + storeName
+
+		// This is the layout of a store's table before tags were kept in a jsonb column, with a column per tag name.
+		for _, statement := range []string{
+			fmt.Sprintf(`CREATE TABLE %s (key text PRIMARY KEY, doc jsonb, bin bytea)`, table),
+			fmt.Sprintf(`ALTER TABLE %s ADD COLUMN tagname1 text DEFAULT NULL, ADD COLUMN tagname2 text DEFAULT NULL`,
+				table),
+			fmt.Sprintf(`CREATE INDEX index_%s_tagname1 ON %s(tagname1)`, storeName, table),
+			fmt.Sprintf(`INSERT INTO %s (key, bin, tagname1, tagname2) VALUES ('key1', 'value1', 'value', NULL), `+
+				`('key2', 'value2', 'value', 'other'), ('key3', 'value3', NULL, NULL)`, table),
+		} {
+			_, err = connection.Exec(context.Background(), statement)
+			require.NoError(t, err)
+		}
+
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString, postgresql.WithSchema("aries_stores"))
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, provider.Close())
+		}()
+
+		store, err := provider.OpenStore(storeName)
+		require.NoError(t, err)
+
+		tags, err := store.GetTags("key1")
+		require.NoError(t, err)
+		require.Equal(t, []spi.Tag{{Name: "tagname1", Value: "value"}}, tags)
+
+		tags, err = store.GetTags("key2")
+		require.NoError(t, err)
+		require.Equal(t, []spi.Tag{{Name: "tagname1", Value: "value"}, {Name: "tagname2", Value: "other"}}, tags)
+
+		tags, err = store.GetTags("key3")
+		require.NoError(t, err)
+		require.Empty(t, tags)
+
+		iterator, err := store.Query("tagname1:value")
+		require.NoError(t, err)
+
+		verifyExpectedIterator(t, iterator, []string{"key1", "key2"}, [][]byte{[]byte("value1"), []byte("value2")})
+
+		var columns int
+
+		err = connection.QueryRow(context.Background(), `SELECT count(*) FROM information_schema.columns `+
+			`WHERE table_schema = 'aries_stores' AND table_name = $1`, storeName).Scan(&columns)
+		require.NoError(t, err)
+		require.Equal(t, 4, columns)
+
+		// The migration only happens once.
+		require.NoError(t, store.Close())
+
+		store, err = provider.OpenStore(storeName)
+		require.NoError(t, err)
+
+		tags, err = store.GetTags("key2")
+		require.NoError(t, err)
+		require.Len(t, tags, 2)
+	})
+}
+
+func testChangeNotifications(t *testing.T) {
+	t.Helper()
+
+	t.Run("Puts and deletes are streamed to subscribers", func(t *testing.T) {
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString,
+			postgresql.WithSchema("aries_notified_stores"),
+			postgresql.WithChangeNotifications())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, provider.Close())
+		}()
+
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		subscriber, ok := store.(postgresql.ChangeSubscriber)
+		require.True(t, ok)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		changes, err := subscriber.Subscribe(ctx)
+		require.NoError(t, err)
+
+		err = store.Put("key1", []byte("value1"), spi.Tag{Name: "tagName1", Value: "tagValue1"})
+		require.NoError(t, err)
+
+		err = store.Batch([]spi.Operation{
+			{Key: "key2", Value: []byte(`{"field":"value2"}`)},
+			{Key: "key1"},
+		})
+		require.NoError(t, err)
+
+		expectedChanges := []postgresql.Change{
+			{
+				Key: "key1", Operation: postgresql.OperationPut,
+				Tags: []spi.Tag{{Name: "tagName1", Value: "tagValue1"}},
+			},
+			{Key: "key2", Operation: postgresql.OperationPut, Tags: []spi.Tag{}},
+			{
+				Key: "key1", Operation: postgresql.OperationDelete,
+				Tags: []spi.Tag{{Name: "tagName1", Value: "tagValue1"}},
+			},
+		}
+
+		for _, expectedChange := range expectedChanges {
+			select {
+			case change := <-changes:
+				require.Equal(t, expectedChange, change)
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "timed out waiting for change", expectedChange.Key)
+			}
+		}
+
+		cancel()
+
+		select {
+		case _, open := <-changes:
+			require.False(t, open)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the subscription to end")
+		}
+	})
+	t.Run("Closing the store ends subscriptions", func(t *testing.T) {
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString,
+			postgresql.WithSchema("aries_notified_stores"),
+			postgresql.WithChangeNotifications())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, provider.Close())
+		}()
+
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		changes, err := store.(postgresql.ChangeSubscriber).Subscribe(context.Background())
+		require.NoError(t, err)
+
+		require.NoError(t, store.Close())
+
+		_, open := <-changes
+		require.False(t, open)
+
+		_, err = store.(postgresql.ChangeSubscriber).Subscribe(context.Background())
+		require.EqualError(t, err, "store is closed")
+	})
+	t.Run("Change notifications are not enabled", func(t *testing.T) {
+		provider, err := postgresql.NewProvider(postgreSQLConnectionString, postgresql.WithSchema("aries_stores"))
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, provider.Close())
+		}()
+
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		changes, err := store.(postgresql.ChangeSubscriber).Subscribe(context.Background())
+		require.EqualError(t, err, "change notifications are not enabled")
+		require.Nil(t, changes)
+	})
 }
 
 func TestNewProvider(t *testing.T) {
@@ -94,8 +380,12 @@ func runCommonTests(t *testing.T, provider *postgresql.Provider) {
 
 	testProviderOpenStore(t, provider)
 	testProviderSetStoreConfig(t, provider)
+	testProviderGetStoreConfig(t, provider)
 	testStorePutGet(t, provider)
+	testStoreGetBulk(t, provider)
 	testStoreQuery(t, provider)
+	testStoreTags(t, provider)
+	testStoreBatch(t, provider)
 	testStoreFlush(t, provider)
 	testStoreClose(t, provider)
 	testProviderPing(t, provider)
@@ -221,6 +511,120 @@ func testProviderSetStoreConfig(t *testing.T, provider spi.Provider) {
 	})
 }
 
+func testProviderGetStoreConfig(t *testing.T, provider spi.Provider) {
+	t.Helper()
+
+	t.Run("Get the config that was set", func(t *testing.T) {
+		testStoreName := randomStoreName()
+
+		store, err := provider.OpenStore(testStoreName)
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		config, err := provider.GetStoreConfig(testStoreName)
+		require.NoError(t, err)
+		require.Empty(t, config.TagNames)
+
+		err = provider.SetStoreConfig(testStoreName,
+			spi.StoreConfiguration{TagNames: []string{"tagName1", "tagName2"}})
+		require.NoError(t, err)
+
+		config, err = provider.GetStoreConfig(testStoreName)
+		require.NoError(t, err)
+		require.Equal(t, []string{"tagName1", "tagName2"}, config.TagNames)
+
+		err = provider.SetStoreConfig(testStoreName, spi.StoreConfiguration{TagNames: []string{"tagName3"}})
+		require.NoError(t, err)
+
+		config, err = provider.GetStoreConfig(testStoreName)
+		require.NoError(t, err)
+		require.Equal(t, []string{"tagName3"}, config.TagNames)
+	})
+	t.Run("Config is kept after the store is closed", func(t *testing.T) {
+		testStoreName := randomStoreName()
+
+		store, err := provider.OpenStore(testStoreName)
+		require.NoError(t, err)
+
+		err = provider.SetStoreConfig(testStoreName, spi.StoreConfiguration{TagNames: []string{"tagName1"}})
+		require.NoError(t, err)
+
+		require.NoError(t, store.Close())
+
+		config, err := provider.GetStoreConfig(testStoreName)
+		require.NoError(t, err)
+		require.Equal(t, []string{"tagName1"}, config.TagNames)
+	})
+	t.Run("Store names are not case-sensitive", func(t *testing.T) {
+		testStoreName := randomStoreName()
+
+		store, err := provider.OpenStore(testStoreName)
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = provider.SetStoreConfig(testStoreName, spi.StoreConfiguration{TagNames: []string{"tagName1"}})
+		require.NoError(t, err)
+
+		config, err := provider.GetStoreConfig(strings.ToUpper(testStoreName))
+		require.NoError(t, err)
+		require.Equal(t, []string{"tagName1"}, config.TagNames)
+	})
+	t.Run("Store was never opened", func(t *testing.T) {
+		config, err := provider.GetStoreConfig(randomStoreName())
+		require.True(t, errors.Is(err, spi.ErrStoreNotFound), "Got unexpected error or no error")
+		require.Empty(t, config)
+	})
+}
+
+func testStoreGetBulk(t *testing.T, provider spi.Provider) {
+	t.Helper()
+
+	t.Run("Values are returned in the order of the keys", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = store.Put("key1", []byte("value1"))
+		require.NoError(t, err)
+
+		err = store.Put("key2", []byte(`{"field":"value2"}`))
+		require.NoError(t, err)
+
+		values, err := store.GetBulk("key2", "nonExistentKey", "key1", "key2")
+		require.NoError(t, err)
+		require.Len(t, values, 4)
+		require.JSONEq(t, `{"field":"value2"}`, string(values[0]))
+		require.Nil(t, values[1])
+		require.Equal(t, "value1", string(values[2]))
+		require.JSONEq(t, `{"field":"value2"}`, string(values[3]))
+	})
+	t.Run("Invalid keys", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		values, err := store.GetBulk()
+		require.EqualError(t, err, "keys slice must contain at least one key")
+		require.Nil(t, values)
+
+		values, err = store.GetBulk("key1", "")
+		require.EqualError(t, err, "key cannot be empty")
+		require.Nil(t, values)
+	})
+}
+
 type testStruct struct {
 	String string `json:"string"`
 
@@ -608,13 +1012,76 @@ func testStoreQuery(t *testing.T, provider spi.Provider) {
 			require.EqualError(t, err, "expression cannot be empty")
 			require.Empty(t, iterator)
 		})
-		t.Run("Tag name + value query, which isn't supported currently", func(t *testing.T) {
+		t.Run("Too many colons", func(t *testing.T) {
 			iterator, err := store.Query("name:value:somethingElse")
-			require.EqualError(t, err, "tag name + value queries not implemented")
-			require.Empty(t, iterator)
+			require.EqualError(t, err, "invalid expression format. It must be in the following format: "+
+				"TagName:TagValue or TagName1:TagValue1&&TagName2:TagValue2. Tag values are optional. "+
+				"If using tag values, <=, <, >=, or > may be used in place of the : to match a range of tag values")
+			require.Nil(t, iterator)
 		})
+		t.Run("Range query with a value that isn't an integer", func(t *testing.T) {
+			iterator, err := store.Query("name>value")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "the immediate value on the right side side must be a valid integer")
+			require.Nil(t, iterator)
+		})
+	})
+	t.Run("Tag name + value, multiple tag and range queries", func(t *testing.T) {
+		storeName := randomStoreName()
+
+		store, err := provider.OpenStore(storeName)
+		require.NoError(t, err)
+		require.NotNil(t, store)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = provider.SetStoreConfig(storeName,
+			spi.StoreConfiguration{TagNames: []string{"tagName1", "tagName2", "expiry"}})
+		require.NoError(t, err)
+
+		keysToPut := []string{"key1", "key2", "key3", "key4"}
+		valuesToPut := [][]byte{[]byte("value1"), []byte("value2"), []byte("value3"), []byte("value4")}
+		tagsToPut := [][]spi.Tag{
+			{{Name: "tagName1", Value: "tagValue1"}, {Name: "expiry", Value: "10"}},
+			{{Name: "tagName1", Value: "tagValue1"}, {Name: "tagName2", Value: "tagValue2"}, {Name: "expiry", Value: "20"}},
+			{{Name: "tagName1", Value: "tagValue3"}, {Name: "expiry", Value: "30"}},
+			{{Name: "tagName2", Value: "tagValue2"}, {Name: "expiry", Value: "notAnInteger"}},
+		}
+
+		putData(t, store, keysToPut, valuesToPut, tagsToPut)
+
+		testCases := []struct {
+			expression   string
+			expectedKeys []string
+		}{
+			{expression: "tagName1:tagValue1", expectedKeys: []string{"key1", "key2"}},
+			{expression: "tagName1:tagValue2"},
+			{expression: "tagName1:tagValue1&&tagName2", expectedKeys: []string{"key2"}},
+			{expression: "tagName2:tagValue2&&tagName1:tagValue1", expectedKeys: []string{"key2"}},
+			{expression: "expiry<20", expectedKeys: []string{"key1"}},
+			{expression: "expiry<=20", expectedKeys: []string{"key1", "key2"}},
+			{expression: "expiry>20", expectedKeys: []string{"key3"}},
+			{expression: "expiry>=20", expectedKeys: []string{"key2", "key3"}},
+			{expression: "expiry>10&&tagName1:tagValue1", expectedKeys: []string{"key2"}},
+			{expression: "expiry:notAnInteger", expectedKeys: []string{"key4"}},
+		}
+
+		for _, testCase := range testCases {
+			iterator, err := store.Query(testCase.expression)
+			require.NoError(t, err, testCase.expression)
+
+			expectedValues := make([][]byte, len(testCase.expectedKeys))
+
+			for i, expectedKey := range testCase.expectedKeys {
+				expectedValues[i] = []byte(strings.Replace(expectedKey, "key", "value", 1))
+			}
+
+			verifyExpectedIterator(t, iterator, testCase.expectedKeys, expectedValues)
+		}
 	})
-	t.Run("Unsupported query options", func(t *testing.T) {
+	t.Run("Paging and sorting", func(t *testing.T) {
 		storeName := randomStoreName()
 
 		store, err := provider.OpenStore(storeName)
@@ -625,13 +1092,338 @@ func testStoreQuery(t *testing.T, provider spi.Provider) {
 			require.NoError(t, store.Close())
 		}()
 
-		iterator, err := store.Query("expression", spi.WithInitialPageNum(1))
-		require.EqualError(t, err, "setting initial page number not implemented")
-		require.Nil(t, iterator)
+		err = provider.SetStoreConfig(storeName, spi.StoreConfiguration{TagNames: []string{"order"}})
+		require.NoError(t, err)
+
+		// Integer tag values are sorted numerically, so "10" comes after "9".
+		for i := 1; i <= 10; i++ {
+			err = store.Put(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)),
+				spi.Tag{Name: "order", Value: strconv.Itoa(i)})
+			require.NoError(t, err)
+		}
+
+		t.Run("Ascending, across pages", func(t *testing.T) {
+			iterator, err := store.Query("order", spi.WithPageSize(3),
+				spi.WithSortOrder(&spi.SortOptions{Order: spi.SortAscending, TagName: "order"}))
+			require.NoError(t, err)
+
+			verifyIteratorKeysInOrder(t, iterator,
+				[]string{"key1", "key2", "key3", "key4", "key5", "key6", "key7", "key8", "key9", "key10"})
+		})
+		t.Run("Descending, from an initial page", func(t *testing.T) {
+			iterator, err := store.Query("order", spi.WithPageSize(4), spi.WithInitialPageNum(1),
+				spi.WithSortOrder(&spi.SortOptions{Order: spi.SortDescending, TagName: "order"}))
+			require.NoError(t, err)
+
+			verifyIteratorKeysInOrder(t, iterator, []string{"key6", "key5", "key4", "key3", "key2", "key1"})
+
+			totalItems, err := iterator.TotalItems()
+			require.NoError(t, err)
+			require.Equal(t, 10, totalItems)
+		})
+		t.Run("Initial page past the end", func(t *testing.T) {
+			iterator, err := store.Query("order", spi.WithPageSize(5), spi.WithInitialPageNum(2))
+			require.NoError(t, err)
+
+			verifyIteratorKeysInOrder(t, iterator, nil)
+		})
+	})
+}
+
+func verifyIteratorKeysInOrder(t *testing.T, iterator spi.Iterator, expectedKeys []string) {
+	t.Helper()
+
+	var keys []string
+
+	for {
+		more, err := iterator.Next()
+		require.NoError(t, err)
+
+		if !more {
+			break
+		}
+
+		key, err := iterator.Key()
+		require.NoError(t, err)
+
+		keys = append(keys, key)
+	}
+
+	require.NoError(t, iterator.Close())
+	require.Equal(t, expectedKeys, keys)
+}
+
+func testStoreTags(t *testing.T, provider spi.Provider) {
+	t.Helper()
+
+	t.Run("Get tags", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = store.Put("key", []byte("value"),
+			spi.Tag{Name: "tagName2", Value: "tagValue2"}, spi.Tag{Name: "tagName1"})
+		require.NoError(t, err)
+
+		tags, err := store.GetTags("key")
+		require.NoError(t, err)
+		require.Equal(t, []spi.Tag{{Name: "tagName1"}, {Name: "tagName2", Value: "tagValue2"}}, tags)
+
+		err = store.Put("keyWithoutTags", []byte("value"))
+		require.NoError(t, err)
+
+		tags, err = store.GetTags("keyWithoutTags")
+		require.NoError(t, err)
+		require.Empty(t, tags)
+
+		tags, err = store.GetTags("nonExistentKey")
+		require.True(t, errors.Is(err, spi.ErrDataNotFound), "Got unexpected error or no error")
+		require.Nil(t, tags)
+
+		tags, err = store.GetTags("")
+		require.EqualError(t, err, "key cannot be empty")
+		require.Nil(t, tags)
+	})
+	t.Run("Overwriting a value replaces its tags", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = store.Put("key", []byte("value"), spi.Tag{Name: "oldTagName", Value: "oldTagValue"})
+		require.NoError(t, err)
+
+		err = store.Put("key", []byte("newValue"), spi.Tag{Name: "newTagName", Value: "newTagValue"})
+		require.NoError(t, err)
+
+		tags, err := store.GetTags("key")
+		require.NoError(t, err)
+		require.Equal(t, []spi.Tag{{Name: "newTagName", Value: "newTagValue"}}, tags)
+
+		iterator, err := store.Query("oldTagName")
+		require.NoError(t, err)
+
+		verifyExpectedIterator(t, iterator, nil, nil)
+
+		iterator, err = store.Query("newTagName:newTagValue")
+		require.NoError(t, err)
+
+		verifyExpectedIterator(t, iterator, []string{"key"}, [][]byte{[]byte("newValue")})
+	})
+	t.Run("Tag names are case-sensitive and don't need to be in the store config", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = store.Put("key1", []byte("value1"), spi.Tag{Name: "TagName", Value: "tagValue"})
+		require.NoError(t, err)
+
+		err = store.Put("key2", []byte("value2"), spi.Tag{Name: "tagname", Value: "tagValue"})
+		require.NoError(t, err)
+
+		iterator, err := store.Query("TagName:tagValue")
+		require.NoError(t, err)
+
+		verifyExpectedIterator(t, iterator, []string{"key1"}, [][]byte{[]byte("value1")})
+	})
+	t.Run("Tag names that aren't valid SQL identifiers are used as-is", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		tagName := `name"; DROP TABLE users; --`
+
+		err = store.Put("key", []byte("value"), spi.Tag{Name: tagName, Value: "5"})
+		require.NoError(t, err)
+
+		for _, expression := range []string{tagName, tagName + ":5", tagName + ">=5"} {
+			iterator, err := store.Query(expression,
+				spi.WithSortOrder(&spi.SortOptions{Order: spi.SortAscending, TagName: tagName}))
+			require.NoError(t, err)
+
+			verifyExpectedIterator(t, iterator, []string{"key"}, [][]byte{[]byte("value")})
+		}
+	})
+	t.Run("Iterator tags", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = store.Put("key", []byte("value"), spi.Tag{Name: "tagName", Value: "tagValue"})
+		require.NoError(t, err)
+
+		iterator, err := store.Query("tagName")
+		require.NoError(t, err)
+
+		more, err := iterator.Next()
+		require.NoError(t, err)
+		require.True(t, more)
+
+		tags, err := iterator.Tags()
+		require.NoError(t, err)
+		require.Equal(t, []spi.Tag{{Name: "tagName", Value: "tagValue"}}, tags)
+
+		require.NoError(t, iterator.Close())
+	})
+}
+
+func testStoreBatch(t *testing.T, provider spi.Provider) {
+	t.Helper()
+
+	t.Run("Puts and deletes are applied in order", func(t *testing.T) {
+		storeName := randomStoreName()
+
+		store, err := provider.OpenStore(storeName)
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = provider.SetStoreConfig(storeName, spi.StoreConfiguration{TagNames: []string{"tagName1", "tagName2"}})
+		require.NoError(t, err)
+
+		err = store.Put("key1", []byte("value1"))
+		require.NoError(t, err)
+
+		err = store.Batch([]spi.Operation{
+			{Key: "key1"},
+			{Key: "key2", Value: []byte("value2"), Tags: []spi.Tag{{Name: "tagName1", Value: "tagValue1"}}},
+			{Key: "key3", Value: []byte(`{"field":"value3"}`), Tags: []spi.Tag{{Name: "tagName2"}}},
+			{Key: "key4", Value: []byte("value4")},
+			{Key: "key4"},
+			{Key: "key5", Value: []byte("value5")},
+		})
+		require.NoError(t, err)
+
+		_, err = store.Get("key1")
+		require.True(t, errors.Is(err, spi.ErrDataNotFound))
+
+		value, err := store.Get("key2")
+		require.NoError(t, err)
+		require.Equal(t, "value2", string(value))
+
+		value, err = store.Get("key3")
+		require.NoError(t, err)
+		require.JSONEq(t, `{"field":"value3"}`, string(value))
+
+		_, err = store.Get("key4")
+		require.True(t, errors.Is(err, spi.ErrDataNotFound))
+
+		value, err = store.Get("key5")
+		require.NoError(t, err)
+		require.Equal(t, "value5", string(value))
+
+		iterator, err := store.Query("tagName1")
+		require.NoError(t, err)
+
+		verifyExpectedIterator(t, iterator, []string{"key2"}, [][]byte{[]byte("value2")})
+	})
+	t.Run("Last put on a key wins", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = store.Batch([]spi.Operation{
+			{Key: "key", Value: []byte("value1")},
+			{Key: "otherKey", Value: []byte("otherValue")},
+			{Key: "key", Value: []byte(`"value2"`)},
+		})
+		require.NoError(t, err)
+
+		value, err := store.Get("key")
+		require.NoError(t, err)
+		require.Equal(t, `"value2"`, string(value))
+
+		value, err = store.Get("otherKey")
+		require.NoError(t, err)
+		require.Equal(t, "otherValue", string(value))
+	})
+	t.Run("Large batch", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		// Enough operations to exceed the parameter limit of a single PostgreSQL statement.
+		operations := make([]spi.Operation, 30000)
+
+		for i := range operations {
+			operations[i] = spi.Operation{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))}
+		}
+
+		err = store.Batch(operations)
+		require.NoError(t, err)
 
-		iterator, err = store.Query("expression", spi.WithSortOrder(&spi.SortOptions{}))
-		require.EqualError(t, err, "custom sort options not implemented")
-		require.Nil(t, iterator)
+		for _, i := range []int{0, 16382, 16383, 29999} {
+			value, errGet := store.Get(fmt.Sprintf("key%d", i))
+			require.NoError(t, errGet)
+			require.Equal(t, fmt.Sprintf("value%d", i), string(value))
+		}
+	})
+	t.Run("Failure rolls back the whole batch", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = store.Put("key1", []byte("value1"))
+		require.NoError(t, err)
+
+		// PostgreSQL doesn't allow null characters in text, so the last operation fails.
+		err = store.Batch([]spi.Operation{
+			{Key: "key1"},
+			{Key: "key2", Value: []byte("value2")},
+			{Key: "key3\x00", Value: []byte("value3")},
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to insert data into table")
+
+		value, err := store.Get("key1")
+		require.NoError(t, err)
+		require.Equal(t, "value1", string(value))
+
+		_, err = store.Get("key2")
+		require.True(t, errors.Is(err, spi.ErrDataNotFound))
+	})
+	t.Run("Invalid input", func(t *testing.T) {
+		store, err := provider.OpenStore(randomStoreName())
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, store.Close())
+		}()
+
+		err = store.Batch(nil)
+		require.EqualError(t, err, "batch requires at least one operation")
+
+		err = store.Batch([]spi.Operation{{Key: "", Value: []byte("value")}})
+		require.EqualError(t, err, "key cannot be empty")
+
+		err = store.Batch([]spi.Operation{{Key: "key", Value: []byte("value"), Tags: []spi.Tag{{Name: "tag:name"}}}})
+		require.EqualError(t, err, `"tag:name" is an invalid tag name since it contains one or more of the `+
+			`following substrings: ":", "<=", "<", ">=", ">"`)
 	})
 }
 
@@ -845,26 +1637,9 @@ func checkIfTestStructsMatch(t *testing.T, retrievedValue []byte, storedTestData
 func testProviderAndStoreNotImplemented(t *testing.T, provider spi.Provider) {
 	t.Helper()
 
-	_, err := provider.GetStoreConfig("storename")
-	require.EqualError(t, err, "not implemented")
-
 	require.Panics(t, func() {
 		provider.GetOpenStores()
 	})
-
-	store, err := provider.OpenStore(randomStoreName())
-	require.NoError(t, err)
-
-	tags, err := store.GetTags("key")
-	require.EqualError(t, err, "not implemented")
-	require.Nil(t, tags)
-
-	values, err := store.GetBulk()
-	require.EqualError(t, err, "not implemented")
-	require.Nil(t, values)
-
-	err = store.Batch(nil)
-	require.EqualError(t, err, "not implemented")
 }
 
 func verifyExpectedIterator(t *testing.T, actualResultsItr spi.Iterator, // nolint:gocyclo // Test file
@@ -913,6 +1688,9 @@ func verifyExpectedIterator(t *testing.T, actualResultsItr spi.Iterator, // noli
 		receivedValue, itrErr := actualResultsItr.Value()
 		require.NoError(t, itrErr)
 
+		_, itrErr = actualResultsItr.Tags()
+		require.NoError(t, itrErr)
+
 		for i := 0; i < len(dataChecklist.keys); i++ {
 			if receivedKey == dataChecklist.keys[i] {
 				if string(receivedValue) == string(dataChecklist.values[i]) {
@@ -930,13 +1708,9 @@ func verifyExpectedIterator(t *testing.T, actualResultsItr spi.Iterator, // noli
 	err = actualResultsItr.Close()
 	require.NoError(t, err)
 
-	tags, err := actualResultsItr.Tags()
-	require.EqualError(t, err, "not implemented")
-	require.Nil(t, tags)
-
 	totalitems, err := actualResultsItr.TotalItems()
-	require.EqualError(t, err, "not implemented")
-	require.Equal(t, -1, totalitems)
+	require.NoError(t, err)
+	require.Equal(t, len(expectedKeys), totalitems)
 
 	for _, received := range dataChecklist.received {
 		if !received {